@@ -0,0 +1,385 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	aliasesKey         = "aliases"
+	aliasIndent        = "  "
+	projectFlag        = "project"
+	unknownAliasSource = "-"
+)
+
+var aliasesHeaderRegexp = regexp.MustCompile(`^aliases:\s*(.*?)\s*$`)
+
+// aliasFile is a config file held as lines, so that the aliases block can
+// be changed without disturbing the formatting or comments of the rest of
+// the file.
+type aliasFile struct {
+	lines []string
+}
+
+func isYamlComment(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "#")
+}
+
+func isIndented(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+func parseAliasLine(line string) (string, string, bool) {
+	var entry map[string]string
+
+	if err := yaml.Unmarshal([]byte(strings.TrimSpace(line)), &entry); err != nil || len(entry) != 1 {
+		return "", "", false
+	}
+
+	for name, target := range entry {
+		return name, target, true
+	}
+
+	return "", "", false
+}
+
+func formatAliasLine(indent string, name string, target string) string {
+	dat, _ := yaml.Marshal(map[string]string{name: target})
+	return indent + strings.TrimSpace(string(dat))
+}
+
+func (f *aliasFile) header() int {
+	for i, line := range f.lines {
+		if aliasesHeaderRegexp.MatchString(line) {
+			return i
+		}
+	}
+	return -1
+}
+
+// entries returns the line indexes of the aliases in the block.
+func (f *aliasFile) entries() []int {
+	var entries []int
+	header := f.header()
+
+	if header < 0 {
+		return nil
+	}
+
+	for i := header + 1; i < len(f.lines); i++ {
+		line := f.lines[i]
+
+		if strings.TrimSpace(line) == "" || isYamlComment(line) {
+			continue
+		}
+
+		if !isIndented(line) {
+			break
+		}
+
+		entries = append(entries, i)
+	}
+
+	return entries
+}
+
+// expandFlowStyle rewrites an aliases block written inline, such as
+// 'aliases: {d: deploy}', as one alias per line.
+func (f *aliasFile) expandFlowStyle() error {
+	header := f.header()
+
+	if header < 0 {
+		return nil
+	}
+
+	value := aliasesHeaderRegexp.FindStringSubmatch(f.lines[header])[1]
+
+	if value == "" || strings.HasPrefix(value, "#") {
+		return nil
+	}
+
+	var aliases map[string]string
+
+	if err := yaml.Unmarshal([]byte(value), &aliases); err != nil {
+		return fmt.Errorf("cannot parse aliases: %v", err)
+	}
+
+	lines := []string{aliasesKey + ":"}
+
+	for _, name := range sortedKeys(aliases) {
+		lines = append(lines, formatAliasLine(aliasIndent, name, aliases[name]))
+	}
+
+	rest := append(lines, f.lines[header+1:]...)
+	f.lines = append(f.lines[:header], rest...)
+	return nil
+}
+
+func (f *aliasFile) set(name string, target string) error {
+	if err := f.expandFlowStyle(); err != nil {
+		return err
+	}
+
+	header := f.header()
+
+	if header < 0 {
+		if n := len(f.lines); n > 0 && f.lines[n-1] == "" {
+			f.lines = f.lines[:n-1]
+		}
+
+		f.lines = append(f.lines, "", aliasesKey+":", formatAliasLine(aliasIndent, name, target), "")
+		return nil
+	}
+
+	entries := f.entries()
+	indent := aliasIndent
+
+	for _, i := range entries {
+		line := f.lines[i]
+		indent = line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+
+		if n, _, ok := parseAliasLine(line); ok && n == name {
+			f.lines[i] = formatAliasLine(indent, name, target)
+			return nil
+		}
+	}
+
+	pos := header + 1
+
+	if len(entries) > 0 {
+		pos = entries[len(entries)-1] + 1
+	}
+
+	rest := append([]string{formatAliasLine(indent, name, target)}, f.lines[pos:]...)
+	f.lines = append(f.lines[:pos], rest...)
+	return nil
+}
+
+func (f *aliasFile) remove(name string) (bool, error) {
+	if err := f.expandFlowStyle(); err != nil {
+		return false, err
+	}
+
+	entries := f.entries()
+
+	for _, i := range entries {
+		if n, _, ok := parseAliasLine(f.lines[i]); ok && n == name {
+			f.lines = append(f.lines[:i], f.lines[i+1:]...)
+
+			// An empty block would leave 'aliases:' with a null value.
+			if len(entries) == 1 {
+				f.lines[f.header()] = aliasesKey + ": {}"
+			}
+
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func readAliasFile(path string) (*aliasFile, error) {
+	dat, err := os.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return &aliasFile{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &aliasFile{lines: strings.Split(string(dat), "\n")}, nil
+}
+
+func (f *aliasFile) write(path string) error {
+	perm := os.FileMode(0644)
+
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	} else if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, []byte(strings.Join(f.lines, "\n")), perm)
+}
+
+func aliasConfigPath(cmd *cobra.Command) (string, error) {
+	project, err := cmd.Flags().GetBool(projectFlag)
+
+	if err != nil {
+		return "", err
+	}
+
+	path, err := userConfigPath()
+
+	if err != nil {
+		return "", err
+	}
+
+	if project {
+		if path = os.Getenv(poProjectFileEnvVar); path == "" {
+			return "", fmt.Errorf("no project config found")
+		}
+	}
+
+	if format := configFormat(path); format != formatYAML {
+		return "", fmt.Errorf("cannot edit aliases in %s, as only YAML configs can be edited", path)
+	}
+
+	return path, nil
+}
+
+func printAliases(cmd *cobra.Command, config *Config) {
+	names := sortedKeys(config.Aliases)
+	namePadding, targetPadding := minCommandPadding, minCommandPadding
+
+	for _, name := range names {
+		if l := displayWidth(name); l > namePadding {
+			namePadding = l
+		}
+		if l := displayWidth(config.Aliases[name]); l > targetPadding {
+			targetPadding = l
+		}
+	}
+
+	for _, name := range names {
+		source, ok := config.aliasSources[name]
+
+		if !ok {
+			source = unknownAliasSource
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "%s  %s  %s\n",
+			rightPad(name, namePadding),
+			rightPad(config.Aliases[name], targetPadding),
+			source)
+	}
+}
+
+func validateNewAlias(root *cobra.Command, config *Config, name string, target string) error {
+	if err := validateCommandName(name); err != nil {
+		return err
+	}
+
+	if _, ok := config.Commands[name]; ok {
+		return fmt.Errorf("alias %s has the same name as a command", name)
+	}
+
+	if isBuiltinCommand(root, config, name) {
+		return fmt.Errorf("alias %s has the same name as a built-in command", name)
+	}
+
+	// findCommand would take an alias too, but an alias has to name the
+	// command itself.
+	if _, ok := config.Commands[target]; !ok {
+		if command, ok := config.Aliases[target]; ok {
+			return fmt.Errorf("%s is an alias of %s; use %s instead", target, command, command)
+		}
+	}
+
+	if _, _, err := findCommand(config, target); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func makeAliasListCommand(config *Config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List aliases and the config that defines them",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			printAliases(cmd, config)
+			return nil
+		},
+	}
+}
+
+func makeAliasAddCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "add ALIAS COMMAND",
+		Short: "Add an alias for a command",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, target := args[0], args[1]
+
+			if err := validateNewAlias(cmd.Root(), config, name, target); err != nil {
+				return err
+			}
+
+			path, err := aliasConfigPath(cmd)
+
+			if err != nil {
+				return err
+			}
+
+			file, err := readAliasFile(path)
+
+			if err != nil {
+				return err
+			}
+
+			if err := file.set(name, target); err != nil {
+				return err
+			}
+
+			return file.write(path)
+		},
+	}
+
+	cmd.Flags().Bool(projectFlag, false, "add the alias to the project config")
+	return cmd
+}
+
+func makeAliasRmCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rm ALIAS",
+		Short: "Remove an alias",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := aliasConfigPath(cmd)
+
+			if err != nil {
+				return err
+			}
+
+			file, err := readAliasFile(path)
+
+			if err != nil {
+				return err
+			}
+
+			removed, err := file.remove(args[0])
+
+			if err != nil {
+				return err
+			}
+
+			if !removed {
+				return fmt.Errorf("no such alias in %s: %s", path, args[0])
+			}
+
+			return file.write(path)
+		},
+	}
+
+	cmd.Flags().Bool(projectFlag, false, "remove the alias from the project config")
+	return cmd
+}
+
+func makeAliasCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "alias",
+		Short: "Manage command aliases",
+	}
+
+	cmd.AddCommand(makeAliasListCommand(config))
+	cmd.AddCommand(makeAliasAddCommand(config))
+	cmd.AddCommand(makeAliasRmCommand())
+	return cmd
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+// argvScripts print what they were given as JSON, in each language a
+// script can be written in.
+var argvScripts = map[string]string{
+	"python3": `
+import json, os, sys
+print(json.dumps({
+    "argv": sys.argv[1:],
+    "args": json.loads(os.environ["PO_ARGS_JSON"]),
+    "flags": json.loads(os.environ["PO_FLAGS_JSON"]),
+}))
+`,
+	"node": `
+console.log(JSON.stringify({
+  argv: process.argv.slice(2),
+  args: JSON.parse(process.env.PO_ARGS_JSON),
+  flags: JSON.parse(process.env.PO_FLAGS_JSON),
+}));
+`,
+}
+
+type argvOutput struct {
+	Argv  []string
+	Args  []string
+	Flags map[string]interface{}
+}
+
+func TestInterpreterArgv(t *testing.T) {
+	args := []string{"a b", "it's", `say "hi"`, "$HOME", "naïve", ""}
+
+	for interpreter, script := range argvScripts {
+		t.Run(interpreter, func(t *testing.T) {
+			if _, err := exec.LookPath(interpreter); err != nil {
+				t.Skipf("%s is not installed", interpreter)
+			}
+
+			config, err := json.Marshal(map[string]interface{}{
+				"commands": map[string]interface{}{
+					"show": map[string]interface{}{
+						"exec": interpreter,
+						"args": []interface{}{map[string]interface{}{
+							"var":    "items",
+							"amount": map[string]interface{}{"at_least": 0, "at_most": nil},
+						}},
+						"flags": map[string]interface{}{
+							"width":   map[string]interface{}{"type": "int", "default": "800"},
+							"verbose": map[string]interface{}{"type": "bool"},
+							"name":    map[string]interface{}{"type": "string"},
+						},
+						"script": script,
+					},
+				},
+			})
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// JSON is YAML, so the config can be built without quoting it.
+			result := runPo(t, string(config), append([]string{"show", "--verbose", "--name", "x y", "--"}, args...)...)
+
+			if result.Code != 0 {
+				t.Fatalf("expected po to succeed, got %d: %s", result.Code, result.Stderr)
+			}
+
+			var out argvOutput
+
+			if err := json.Unmarshal([]byte(result.Stdout), &out); err != nil {
+				t.Fatalf("expected JSON, got %q: %v", result.Stdout, err)
+			}
+
+			if !reflect.DeepEqual(out.Argv, args) || !reflect.DeepEqual(out.Args, args) {
+				t.Errorf("expected the arguments as given, got argv %q and PO_ARGS_JSON %q", out.Argv, out.Args)
+			}
+
+			flags := map[string]interface{}{"width": 800.0, "verbose": true, "name": "x y"}
+
+			if !reflect.DeepEqual(out.Flags, flags) {
+				t.Errorf("expected flags %v, got %v", flags, out.Flags)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// negatesAnnotation marks the --no-NAME flag that turns off a bool flag
+// whose default is true, and holds the name of the flag it turns off.
+const negatesAnnotation = "po_negates"
+
+func negationName(name string) string {
+	return "no-" + name
+}
+
+// addNegationFlag adds --no-NAME for a bool flag that defaults to true, as
+// otherwise it could only be turned off with --NAME=false.
+func addNegationFlag(cmd *cobra.Command, name string) error {
+	negation := negationName(name)
+	cmd.Flags().Bool(negation, false, fmt.Sprintf("turn off --%s", name))
+	return cmd.Flags().SetAnnotation(negation, negatesAnnotation, []string{name})
+}
+
+func isNegationFlag(f *pflag.Flag) bool {
+	_, ok := f.Annotations[negatesAnnotation]
+	return ok
+}
+
+// applyNegationFlags turns off the flags whose --no-NAME was given, before
+// a command's arguments and flags are checked, so that --no-NAME has the
+// same effect as --NAME=false.
+func applyNegationFlags(validateArgs cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		var err error
+
+		cmd.LocalFlags().VisitAll(func(f *pflag.Flag) {
+			if err != nil || !isNegationFlag(f) || !f.Changed || f.Value.String() != "true" {
+				return
+			}
+
+			name := f.Annotations[negatesAnnotation][0]
+			target := cmd.LocalFlags().Lookup(name)
+
+			if target.Changed {
+				err = fmt.Errorf("flags --%s and --%s cannot be used together", name, f.Name)
+				return
+			}
+
+			target.Value.Set("false")
+			target.Changed = true
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return validateArgs(cmd, args)
+	}
+}
+
+func (flag *Flag) ExplicitFalse() bool {
+	return flag.ExplicitFalseP != nil && *flag.ExplicitFalseP
+}
+
+// explicitFalse is true for a bool flag that was set to false on the
+// command line, when its definition asks for that to be passed on to the
+// script rather than treated as if the flag weren't given.
+func explicitFalse(f *pflag.Flag, def Flag) bool {
+	return def.ExplicitFalse() && f.Changed && isFalseBoolFlag(f)
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+	"gopkg.in/yaml.v2"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// writeFileAtomic writes to a temporary file in the destination directory
+// and renames it into place, so concurrent readers never see a partial file.
+func writeFileAtomic(path string, dat []byte, perm os.FileMode) error {
+	file, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+
+	if err != nil {
+		return err
+	}
+
+	tempPath := file.Name()
+
+	if _, err := file.Write(dat); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+
+	return nil
+}
+
+const cacheLockName = "lock"
+
+func withCacheLock(fn func() error) error {
+	dir, err := makeCacheDir("")
+
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, cacheLockName), os.O_CREATE|os.O_RDWR, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if err := unix.Flock(int(file.Fd()), unix.LOCK_EX); err != nil {
+		return err
+	}
+
+	defer unix.Flock(int(file.Fd()), unix.LOCK_UN)
+
+	return fn()
+}
+
+// urlCacheMetaSuffix is the suffix of the file that sits next to each
+// cached URL, recording where it came from.
+const urlCacheMetaSuffix = ".meta"
+
+type urlCacheMeta struct {
+	Url     string
+	Fetched string
+	ETag    string `yaml:"etag,omitempty"`
+	Sha256  string
+}
+
+func newUrlCacheMeta(url string, dat []byte, fetched time.Time, etag string) *urlCacheMeta {
+	return &urlCacheMeta{
+		Url:     url,
+		Fetched: fetched.UTC().Format(time.RFC3339),
+		ETag:    etag,
+		Sha256:  sha256HexString(dat),
+	}
+}
+
+func isUrlCacheMeta(name string) bool {
+	return strings.HasSuffix(name, urlCacheMetaSuffix)
+}
+
+// urlCacheMetaPath is the path of the metadata for a cached URL, which is
+// shared by the fresh and stale copies.
+func urlCacheMetaPath(path string) string {
+	return strings.TrimSuffix(path, staleCacheSuffix) + urlCacheMetaSuffix
+}
+
+func readUrlCacheMeta(path string) (*urlCacheMeta, error) {
+	dat, err := os.ReadFile(urlCacheMetaPath(path))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var meta urlCacheMeta
+	return &meta, yaml.Unmarshal(dat, &meta)
+}
+
+func writeUrlCacheMeta(path string, meta *urlCacheMeta) error {
+	dat, err := yaml.Marshal(meta)
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(urlCacheMetaPath(path), dat, 0600)
+}
+
+// legacyCacheName is how older versions of po named cache entries, with
+// the SHA-1 of the URL.
+func legacyCacheName(s string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(s)))
+}
+
+// migrateUrlCache finds the cache entry for a URL. Entries cached by older
+// versions of po are named after the SHA-1 of the URL, whether as written
+// or normalized, and may have no metadata, so they're moved and given
+// metadata the first time they're read.
+func migrateUrlCache(url string) (string, error) {
+	path, err := urlCachePath(url)
+
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Dir(path)
+
+	for _, name := range []string{legacyCacheName(urlCacheKey(url)), legacyCacheName(url)} {
+		legacyPath := filepath.Join(dir, name)
+
+		for _, suffix := range []string{"", staleCacheSuffix, urlCacheMetaSuffix} {
+			if _, err := os.Stat(path + suffix); os.IsNotExist(err) {
+				if os.Rename(legacyPath+suffix, path+suffix) == nil {
+					tracef("moved the cache entry for %s to %s", url, path+suffix)
+				}
+			}
+		}
+	}
+
+	if _, err := os.Stat(urlCacheMetaPath(path)); !os.IsNotExist(err) {
+		return path, nil
+	}
+
+	for _, p := range []string{path, path + staleCacheSuffix} {
+		if info, err := os.Stat(p); err == nil {
+			if dat, err := os.ReadFile(p); err == nil {
+				writeUrlCacheMeta(path, newUrlCacheMeta(url, dat, info.ModTime(), ""))
+			}
+			break
+		}
+	}
+
+	return path, nil
+}
+
+type cacheEntry struct {
+	Kind    string
+	Name    string
+	Url     string
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// remove removes a cache entry, along with its metadata unless there's
+// still a fresh or stale copy of the same URL that needs it.
+func (entry *cacheEntry) remove() error {
+	if err := os.Remove(entry.Path); err != nil {
+		return err
+	}
+
+	base := strings.TrimSuffix(entry.Path, staleCacheSuffix)
+
+	for _, p := range []string{base, base + staleCacheSuffix} {
+		if _, err := os.Stat(p); err == nil {
+			return nil
+		}
+	}
+
+	os.Remove(urlCacheMetaPath(entry.Path))
+	return nil
+}
+
+func (entry *cacheEntry) Origin() string {
+	switch {
+	case entry.Kind == scriptsCacheName:
+		return "script"
+	case strings.HasSuffix(entry.Name, staleCacheSuffix):
+		return "import (stale)"
+	default:
+		return "import"
+	}
+}
+
+func listCacheEntries() ([]cacheEntry, error) {
+	var entries []cacheEntry
+
+	for _, kind := range []string{importsCacheName, scriptsCacheName} {
+		dir, err := cacheDir(kind)
+
+		if kind == scriptsCacheName {
+			dir, err = scriptsDir()
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		files, err := os.ReadDir(dir)
+
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			if isUrlCacheMeta(file.Name()) {
+				continue
+			}
+
+			info, err := file.Info()
+
+			if err != nil {
+				continue
+			}
+
+			entry := cacheEntry{
+				Kind:    kind,
+				Name:    file.Name(),
+				Path:    filepath.Join(dir, file.Name()),
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+			}
+
+			if meta, err := readUrlCacheMeta(entry.Path); err == nil {
+				entry.Url = meta.Url
+			}
+
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func collectCache(maxAge time.Duration) (int, error) {
+	removed := 0
+
+	err := withCacheLock(func() error {
+		entries, err := listCacheEntries()
+
+		if err != nil {
+			return err
+		}
+
+		cutoff := time.Now().Add(-maxAge)
+
+		for _, entry := range entries {
+			if entry.ModTime.Before(cutoff) {
+				if err := entry.remove(); err != nil {
+					return err
+				}
+				removed++
+			}
+		}
+
+		return nil
+	})
+
+	return removed, err
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
+
+const cacheGcStampName = "last-gc"
+
+func collectCacheDaily(config *Config) {
+	dir, err := cacheDir("")
+
+	if err != nil {
+		return
+	}
+
+	stampPath := filepath.Join(dir, cacheGcStampName)
+
+	if info, err := os.Stat(stampPath); err == nil {
+		if time.Since(info.ModTime()) < days(1) {
+			return
+		}
+	}
+
+	if _, err := collectCache(days(config.CacheMaxAgeDays())); err != nil {
+		return
+	}
+
+	if _, err := makeCacheDir(""); err != nil {
+		return
+	}
+
+	os.WriteFile(stampPath, []byte{}, 0600)
+	touchFile(stampPath)
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size < 1024:
+		return fmt.Sprintf("%dB", size)
+	case size < 1024*1024:
+		return fmt.Sprintf("%.1fK", float64(size)/1024)
+	default:
+		return fmt.Sprintf("%.1fM", float64(size)/(1024*1024))
+	}
+}
+
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	case age < days(1):
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(age.Hours()/24))
+	}
+}
+
+// DisplayName is the URL of a cached import if it's known, and otherwise
+// the name of its file.
+func (entry *cacheEntry) DisplayName() string {
+	if entry.Url != "" {
+		return entry.Url
+	}
+	return entry.Name
+}
+
+func printCacheEntries(out io.Writer, entries []cacheEntry) {
+	padding := minCommandPadding
+
+	for _, entry := range entries {
+		if l := displayWidth(entry.DisplayName()); l > padding {
+			padding = l
+		}
+	}
+
+	for _, entry := range entries {
+		fmt.Fprintf(out, "%s  %6s  %4s  %s\n",
+			rightPad(entry.DisplayName(), padding),
+			formatSize(entry.Size),
+			formatAge(time.Since(entry.ModTime)),
+			entry.Origin())
+	}
+}
+
+// loadedUrls are the URLs of the imports po has loaded, so that po cache
+// diff knows which cached copies to compare.
+var loadedUrls struct {
+	sync.Mutex
+	urls map[string]bool
+}
+
+func recordLoadedUrl(url string) {
+	loadedUrls.Lock()
+	defer loadedUrls.Unlock()
+
+	if loadedUrls.urls == nil {
+		loadedUrls.urls = make(map[string]bool)
+	}
+
+	loadedUrls.urls[url] = true
+}
+
+func loadedUrlList() []string {
+	loadedUrls.Lock()
+	defer loadedUrls.Unlock()
+
+	urls := make([]string, 0, len(loadedUrls.urls))
+
+	for url := range loadedUrls.urls {
+		urls = append(urls, url)
+	}
+
+	sort.Strings(urls)
+	return urls
+}
+
+// flatCommands finds every command by its full name, without its
+// subcommands, so that a change to a subcommand isn't also counted as a
+// change to its parent.
+func flatCommands(commands map[string]Command) map[string]Command {
+	flat := make(map[string]Command)
+
+	for _, name := range commandNames(commands, "") {
+		parent, key, _ := lookupCommand(commands, name)
+		command := parent[key]
+		command.Commands = nil
+		command.commandOrder = nil
+		flat[name] = command
+	}
+
+	return flat
+}
+
+// writeCommandChanges summarises the commands two versions of an import
+// add, remove or change.
+func writeCommandChanges(out io.Writer, url string, from []byte, to []byte) {
+	fromCfg, err := parseConfigAs(from, configFormat(url))
+
+	if err != nil {
+		fmt.Fprintf(out, "cannot compare commands: the cached copy is invalid: %v\n", err)
+		return
+	}
+
+	toCfg, err := parseConfigAs(to, configFormat(url))
+
+	if err != nil {
+		fmt.Fprintf(out, "cannot compare commands: the remote copy is invalid: %v\n", err)
+		return
+	}
+
+	fromCmds, toCmds := flatCommands(fromCfg.Commands), flatCommands(toCfg.Commands)
+
+	for _, name := range commandNames(toCfg.Commands, "") {
+		if fromCmd, ok := fromCmds[name]; !ok {
+			fmt.Fprintf(out, "added    %s\n", spacedName(name))
+		} else if !reflect.DeepEqual(fromCmd, toCmds[name]) {
+			fmt.Fprintf(out, "changed  %s\n", spacedName(name))
+		}
+	}
+
+	for _, name := range commandNames(fromCfg.Commands, "") {
+		if _, ok := toCmds[name]; !ok {
+			fmt.Fprintf(out, "removed  %s\n", spacedName(name))
+		}
+	}
+}
+
+// diffCachedImport compares the cached copy of an import with what its URL
+// now returns, leaving the cache as it is. It's true if they differ.
+func diffCachedImport(out io.Writer, url string) (bool, error) {
+	cached, err := readUrlCache(url)
+
+	if err == nil && cached == nil {
+		cached, err = readStaleUrlCache(url)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if cached == nil {
+		return false, fmt.Errorf("%s is not in the cache", url)
+	}
+
+	remote, err := fetchUrlWithRetries(httpClient, url)
+
+	if err != nil {
+		return false, err
+	}
+
+	if string(cached) == string(remote) {
+		fmt.Fprintf(out, "ok       %s\n", url)
+		return false, nil
+	}
+
+	fmt.Fprintf(out, "changed  %s\n", url)
+	writeUnifiedDiff(out, url+" (cached)", url+" (remote)", string(cached), string(remote))
+	writeCommandChanges(out, url, cached, remote)
+	return true, nil
+}
+
+func makeCacheCommand(config *Config) *cobra.Command {
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage cached imports and scripts",
+		Args:  cobra.NoArgs,
+	}
+
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove cache entries that haven't been used recently",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			maxAge, err := cmd.Flags().GetInt("max-age")
+
+			if err != nil {
+				return err
+			}
+
+			removed, err := collectCache(days(maxAge))
+
+			if err != nil {
+				return err
+			}
+
+			cmd.Printf("Removed %d cache entries\n", removed)
+			return nil
+		},
+	}
+
+	gcCmd.Flags().Int("max-age", config.CacheMaxAgeDays(), "remove entries unused for this many days")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List cached imports and scripts",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entries, err := listCacheEntries()
+
+			if err != nil {
+				return err
+			}
+
+			printCacheEntries(cmd.OutOrStdout(), entries)
+			return nil
+		},
+	}
+
+	pathCmd := &cobra.Command{
+		Use:   "path",
+		Short: "Print the cache directory",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, err := cacheDir("")
+
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), dir)
+			return nil
+		},
+	}
+
+	clearCmd := &cobra.Command{
+		Use:   "clear",
+		Short: "Mark cached imports and scripts as stale, so they're fetched again",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return deleteCacheFiles()
+		},
+	}
+
+	diffCmd := &cobra.Command{
+		Use:   "diff [URL]",
+		Short: "Compare cached imports with their URLs, without updating the cache",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			urls := loadedUrlList()
+
+			if len(args) > 0 {
+				urls = []string{normalizeImport(Import{Url: args[0]}).Url}
+			}
+
+			changed := 0
+
+			for _, url := range urls {
+				differs, err := diffCachedImport(cmd.OutOrStdout(), url)
+
+				if err != nil {
+					return err
+				}
+
+				if differs {
+					changed++
+				}
+			}
+
+			if changed == 1 {
+				return fmt.Errorf("1 import has changed")
+			} else if changed > 1 {
+				return fmt.Errorf("%d imports have changed", changed)
+			}
+
+			return nil
+		},
+	}
+
+	cacheCmd.AddCommand(clearCmd, diffCmd, gcCmd, listCmd, pathCmd)
+	return cacheCmd
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestLegacyCacheEntriesAreMigrated(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		url  string
+		key  string
+	}{
+		{"as written", "https://Example.com/team/po.yml/", "https://Example.com/team/po.yml/"},
+		{"normalized", "https://Example.com/team/po.yml/", urlCacheKey("https://Example.com/team/po.yml/")},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tempCacheDir(t)
+			dir, err := makeCacheDir(importsCacheName)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			legacyPath := filepath.Join(dir, legacyCacheName(test.key))
+			writeTestFile(t, legacyPath, "commands: {}\n")
+			writeTestFile(t, legacyPath+staleCacheSuffix, "commands: {old: {}}\n")
+
+			dat, err := readUrlCache(test.url)
+
+			if err != nil || string(dat) != "commands: {}\n" {
+				t.Fatalf("expected the legacy entry to be read, got %q (%v)", dat, err)
+			}
+
+			path, err := urlCachePath(test.url)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if filepath.Base(path) != sha256HexString([]byte(urlCacheKey(test.url))) {
+				t.Errorf("expected the entry to be named by SHA-256, got %s", filepath.Base(path))
+			}
+
+			for _, suffix := range []string{"", staleCacheSuffix} {
+				if _, err := os.Stat(legacyPath + suffix); !os.IsNotExist(err) {
+					t.Errorf("expected %s to be moved, got %v", legacyPath+suffix, err)
+				}
+
+				if _, err := os.Stat(path + suffix); err != nil {
+					t.Errorf("expected %s to exist, got %v", path+suffix, err)
+				}
+			}
+
+			meta, err := readUrlCacheMeta(path)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if meta.Url != test.url || meta.Sha256 != sha256HexString(dat) {
+				t.Errorf("expected metadata for the migrated entry, got %+v", meta)
+			}
+		})
+	}
+}
+
+func TestLegacyCacheEntryDoesNotReplaceNewOne(t *testing.T) {
+	tempCacheDir(t)
+	url := "https://example.com/po.yml"
+
+	if err := writeUrlCache(url, []byte("new"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := makeCacheDir(importsCacheName)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, filepath.Join(dir, legacyCacheName(url)), "old")
+
+	if dat, err := readUrlCache(url); err != nil || string(dat) != "new" {
+		t.Errorf("expected the SHA-256 entry to be kept, got %q (%v)", dat, err)
+	}
+}
+
+// tempCacheDir points po's cache at a temporary directory.
+func tempCacheDir(t *testing.T) string {
+	t.Helper()
+
+	saved := customCacheDir
+	customCacheDir = t.TempDir()
+	t.Cleanup(func() { customCacheDir = saved })
+
+	return customCacheDir
+}
+
+const (
+	cacheWriterEnvVar  = "PO_TEST_CACHE_WRITER"
+	cacheWriterUrl     = "https://example.com/po.yml"
+	cacheWriterCounter = "counter"
+	cacheWriterRounds  = 50
+)
+
+// TestCacheWriter is run as a separate process by the stress test below,
+// so that the writers are as separate as two runs of po would be.
+func TestCacheWriter(t *testing.T) {
+	id := os.Getenv(cacheWriterEnvVar)
+
+	if id == "" {
+		t.Skip("only run as a writer for TestConcurrentCacheWriters")
+	}
+
+	customCacheDir = os.Getenv(cacheDirEnvVar)
+	dat := bytes.Repeat([]byte(id), 64*1024)
+
+	for i := 0; i < cacheWriterRounds; i++ {
+		if err := writeUrlCache(cacheWriterUrl, dat, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		err := withCacheLock(func() error {
+			path := filepath.Join(customCacheDir, cacheWriterCounter)
+			count, _ := os.ReadFile(path)
+			n, _ := strconv.Atoi(string(count))
+			return os.WriteFile(path, []byte(strconv.Itoa(n+1)), 0600)
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConcurrentCacheWriters(t *testing.T) {
+	dir := tempCacheDir(t)
+	writers := 8
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+
+	for i := 0; i < writers; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestCacheWriter$")
+		cmd.Env = append(os.Environ(), cacheWriterEnvVar+"="+string(rune('a'+i)), cacheDirEnvVar+"="+dir)
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if out, err := cmd.CombinedOutput(); err != nil {
+				errs <- fmt.Errorf("%v: %s", err, out)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+
+	// Whatever is in the cache while the writers run must be the whole of
+	// what one of them wrote.
+	for reading := true; reading; {
+		select {
+		case <-done:
+			reading = false
+		default:
+		}
+
+		dat, err := readUrlCache(cacheWriterUrl)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if dat != nil && (len(dat) != 64*1024 || len(bytes.Trim(dat, string(dat[:1]))) != 0) {
+			t.Fatalf("expected a whole cache entry, got %d mixed bytes", len(dat))
+		}
+	}
+
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	count, err := os.ReadFile(filepath.Join(dir, cacheWriterCounter))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := strconv.Atoi(string(count)); n != writers*cacheWriterRounds {
+		t.Errorf("expected every locked update to be kept, got %d of %d", n, writers*cacheWriterRounds)
+	}
+}
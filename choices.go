@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+)
+
+// missingChoices finds the arguments left out of a command line, if each
+// of them has choices that the user could be asked to pick from. If any
+// missing argument has no choices, nothing is returned, as the command
+// couldn't be run anyway.
+func missingChoices(defs []Argument, args []string) []Argument {
+	if len(args) >= minArgLength(defs) {
+		return nil
+	}
+
+	var missing []Argument
+	remaining := len(args)
+
+	for _, def := range defs {
+		given := def.AtLeast()
+
+		if given > remaining {
+			given = remaining
+		}
+
+		remaining -= given
+
+		for n := given; n < def.AtLeast(); n++ {
+			if len(def.Choices) == 0 {
+				return nil
+			}
+			missing = append(missing, def)
+		}
+	}
+
+	return missing
+}
+
+// promptArgChoices asks for any arguments with choices that were left
+// out, when po is run interactively, and adds them to the end of the
+// arguments. Without a terminal, or with --no-input, a missing argument
+// is an error as usual.
+func promptArgChoices(cmd *cobra.Command, defs []Argument) {
+	validateArgs := cmd.Args
+	run := cmd.Run
+	var chosen []string
+
+	cmd.Args = func(cmd *cobra.Command, args []string) error {
+		if isInteractive() {
+			for _, def := range missingChoices(defs, args) {
+				prompt := fmt.Sprintf("Choose %s", def.DisplayName())
+
+				if def.Desc != "" {
+					prompt = fmt.Sprintf("Choose %s (%s)", def.DisplayName(), def.Desc)
+				}
+
+				choice, err := choose(prompt, def.Choices)
+
+				if err != nil {
+					return err
+				}
+
+				chosen = append(chosen, choice)
+			}
+		}
+
+		return validateArgs(cmd, append(args, chosen...))
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		run(cmd, append(args, chosen...))
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// completionTimeout limits how long a completion script can run, so that
+// a slow one can't hang the shell.
+const (
+	completionCmdName = "completion"
+	completionTimeout = 2 * time.Second
+)
+
+// completing is true when the shell is asking po for completions.
+func completing() bool {
+	if len(os.Args) < 2 {
+		return false
+	}
+	return os.Args[1] == cobra.ShellCompRequestCmd || os.Args[1] == cobra.ShellCompNoDescRequestCmd
+}
+
+func (arg *Argument) checkChoices(vals []string) error {
+	if len(arg.Choices) == 0 {
+		return nil
+	}
+
+	for _, val := range vals {
+		if !containsString(arg.Choices, val) {
+			return fmt.Errorf("argument %s must be one of: %s", arg.Var, strings.Join(arg.Choices, ", "))
+		}
+	}
+
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// argAt finds the definition for the argument at a position, or nil if
+// the command takes no more arguments.
+func argAt(defs []Argument, position int) *Argument {
+	for i := range defs {
+		atMost := defs[i].AtMost()
+
+		if atMost == 0 || position < atMost {
+			return &defs[i]
+		}
+
+		position -= atMost
+	}
+
+	return nil
+}
+
+// runCompletionScript runs a script and returns each line it prints. Any
+// failure is ignored, as an error can't be shown while completing.
+func runCompletionScript(env []string, script string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, defaultExecPath, "-c", script)
+	cmd.Env = env
+	// Don't wait for anything the script started that still holds stdout.
+	cmd.WaitDelay = 100 * time.Millisecond
+	out, err := cmd.Output()
+
+	if err != nil {
+		tracef("completion script failed: %v", err)
+		return nil
+	}
+
+	var lines []string
+
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+func completionCandidates(env []string, choices []string, script string, toComplete string) []string {
+	candidates := choices
+
+	if len(candidates) == 0 && script != "" {
+		candidates = runCompletionScript(env, script)
+	}
+
+	var matches []string
+
+	for _, c := range candidates {
+		if strings.HasPrefix(c, toComplete) {
+			matches = append(matches, c)
+		}
+	}
+
+	return matches
+}
+
+// argCompletionFunc completes an argument from its choices, or from the
+// output of its completion script. Arguments with neither fall back to
+// the shell's own completion of file names, or of directories for dir
+// arguments.
+func argCompletionFunc(env []string, command *Command) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		arg := argAt(command.Args, len(args))
+
+		if arg == nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		if len(arg.Choices) == 0 && arg.Complete == "" {
+			return nil, pathCompletion(arg.Type)
+		}
+
+		candidates := completionCandidates(env, arg.Choices, arg.Complete, toComplete)
+		return candidates, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completionWithDesc is a completion candidate with a description, which
+// zsh and fish show beside it. Shells that can't show descriptions are
+// sent the candidate alone.
+func completionWithDesc(name string, desc string) string {
+	if desc == "" {
+		return name
+	}
+	return name + "\t" + desc
+}
+
+// colonFormCompletions completes the full names of subcommands, such as
+// db:migrate, once a colon has been typed. The colon forms are hidden so
+// that they don't fill up listings, so cobra doesn't offer them itself.
+func colonFormCompletions(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 || !strings.Contains(toComplete, ":") {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var names []string
+
+	for _, subCmd := range listedCommands(cmd.Root()) {
+		if isColonForm(subCmd) && unavailableReason(subCmd) == "" && strings.HasPrefix(subCmd.Name(), toComplete) {
+			names = append(names, completionWithDesc(subCmd.Name(), subCmd.Short))
+		}
+	}
+
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+// useBashCompletionWithoutDescriptions makes po completion bash generate
+// a script that offers names alone. Bash has nowhere to show descriptions
+// but beside the names in the list of candidates, where a long one pushes
+// the rest of the list out of line.
+func useBashCompletionWithoutDescriptions(root *cobra.Command) {
+	root.InitDefaultCompletionCmd()
+
+	for _, cmd := range root.Commands() {
+		if cmd.Name() != completionCmdName {
+			continue
+		}
+
+		for _, shellCmd := range cmd.Commands() {
+			if shellCmd.Name() == "bash" {
+				shellCmd.RunE = func(cmd *cobra.Command, args []string) error {
+					return cmd.Root().GenBashCompletionV2(cmd.OutOrStdout(), false)
+				}
+			}
+		}
+	}
+}
+
+// checkFlagChoices adds a check that flags with choices have one of them
+// to a command's check of its arguments, as both happen once the flags
+// are parsed.
+func checkFlagChoices(checkArgs cobra.PositionalArgs, flags map[string]Flag) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		for name, flag := range flags {
+			value := cmd.Flags().Lookup(name)
+
+			if len(flag.Choices) > 0 && value.Changed && !containsString(flag.Choices, value.Value.String()) {
+				return fmt.Errorf("flag --%s must be one of: %s", name, strings.Join(flag.Choices, ", "))
+			}
+		}
+
+		if checkArgs == nil {
+			return nil
+		}
+
+		return checkArgs(cmd, args)
+	}
+}
+
+// registerFlagCompletions completes flag values from their choices or
+// completion scripts. Bool flags complete to true or false, and file and
+// dir flags to paths.
+func registerFlagCompletions(cmd *cobra.Command, env []string, flags map[string]Flag) error {
+	for name, flag := range flags {
+		choices := flag.Choices
+		script := flag.Complete
+
+		if flag.Type == "bool" && len(choices) == 0 {
+			choices = []string{"true", "false"}
+		}
+
+		if len(choices) == 0 && script == "" && !isPathType(flag.Type) {
+			continue
+		}
+
+		directive := cobra.ShellCompDirectiveNoFileComp
+
+		if len(choices) == 0 && script == "" {
+			directive = pathCompletion(flag.Type)
+		}
+
+		err := cmd.RegisterFlagCompletionFunc(name, func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completionCandidates(env, choices, script, toComplete), directive
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	defaultContainerRuntime = "docker"
+	containerScriptPath     = "/tmp/po-script"
+	noContainerFlag         = "no-container"
+)
+
+type Container struct {
+	Image   string
+	Runtime string
+	Volumes []string
+	Workdir string
+}
+
+func (c *Container) Validate() error {
+	if c.Image == "" {
+		return fmt.Errorf("container requires an 'image' key set")
+	}
+	return nil
+}
+
+func (c *Container) RuntimeOrDefault() string {
+	if c.Runtime == "" {
+		return defaultContainerRuntime
+	}
+	return c.Runtime
+}
+
+// hostVolume makes the host side of a relative bind mount absolute, as
+// older container runtimes treat anything else as a named volume.
+func hostVolume(volume string) (string, error) {
+	if !strings.HasPrefix(volume, ".") {
+		return volume, nil
+	}
+
+	parts := strings.SplitN(volume, ":", 2)
+	host, err := filepath.Abs(parts[0])
+
+	if err != nil {
+		return "", err
+	}
+
+	parts[0] = host
+	return strings.Join(parts, ":"), nil
+}
+
+// addedEnvVars returns the variables that po added to the environment on
+// top of those it inherited.
+func addedEnvVars(env []string) []string {
+	host := make(map[string]bool)
+
+	for _, kv := range os.Environ() {
+		host[kv] = true
+	}
+
+	var added []string
+
+	for _, kv := range env {
+		if strings.IndexByte(kv, '=') > 0 && !host[kv] {
+			added = append(added, kv)
+		}
+	}
+
+	return added
+}
+
+// Args wraps a script invocation in a container run. The cached script is
+// mounted into the container read-only, and when no volumes are given
+// the project directory is mounted at the same path it has on the host.
+func (c *Container) Args(interpreter []string, scriptPath string, env []string) ([]string, error) {
+	runtime, err := exec.LookPath(c.RuntimeOrDefault())
+
+	if err != nil {
+		return nil, fmt.Errorf("container runtime '%s' not found in PATH", c.RuntimeOrDefault())
+	}
+
+	args := []string{runtime, "run", "--rm", "-i"}
+
+	if isInteractive() && isTerminal(os.Stdout) {
+		args = append(args, "-t")
+	}
+
+	volumes := c.Volumes
+	workdir := c.Workdir
+
+	if len(volumes) == 0 {
+		dir, err := os.Getwd()
+
+		if err != nil {
+			return nil, err
+		}
+
+		volumes = []string{dir + ":" + dir}
+
+		if workdir == "" {
+			workdir = dir
+		}
+	}
+
+	for _, volume := range volumes {
+		volume, err := hostVolume(volume)
+
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, "-v", volume)
+	}
+
+	args = append(args, "-v", scriptPath+":"+containerScriptPath+":ro")
+
+	if workdir != "" {
+		args = append(args, "-w", workdir)
+	}
+
+	// Variables are forwarded by name so that their values don't appear in
+	// the process list.
+	for _, kv := range addedEnvVars(env) {
+		args = append(args, "-e", kv[:strings.IndexByte(kv, '=')])
+	}
+
+	args = append(args, c.Image)
+	args = append(args, interpreter...)
+	return append(args, containerScriptPath), nil
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	detachFlag         = "detach"
+	detachedDirName    = "detached"
+	detachedLogDirName = "logs"
+	stopTimeout        = 10 * time.Second
+	stopPollInterval   = 100 * time.Millisecond
+	followInterval     = 250 * time.Millisecond
+)
+
+type detachedProcess struct {
+	Pid     int       `json:"pid"`
+	Command string    `json:"command"`
+	Dir     string    `json:"dir"`
+	Log     string    `json:"log"`
+	Started time.Time `json:"started"`
+}
+
+func detachedStateDir(name string) string {
+	return filepath.Join(userStateDir(), "po", name)
+}
+
+// detachedKey identifies a command within a project, so the same command
+// can be running in several projects at once.
+func detachedKey(dir string, command string) string {
+	return sha256HexString([]byte(dir))[:12] + "-" + strings.Replace(command, ":", "_", -1)
+}
+
+func newDetachedProcess(command string, dir string) *detachedProcess {
+	key := detachedKey(dir, command)
+
+	return &detachedProcess{
+		Command: command,
+		Dir:     dir,
+		Log:     filepath.Join(detachedStateDir(detachedLogDirName), key+".log"),
+	}
+}
+
+func (p *detachedProcess) registryPath() string {
+	return filepath.Join(detachedStateDir(detachedDirName), detachedKey(p.Dir, p.Command)+".json")
+}
+
+func (p *detachedProcess) Running() bool {
+	err := syscall.Kill(p.Pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+func (p *detachedProcess) write() error {
+	dat, err := json.Marshal(p)
+
+	if err != nil {
+		return err
+	}
+
+	path := p.registryPath()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, dat, 0600)
+}
+
+func (p *detachedProcess) remove() {
+	os.Remove(p.registryPath())
+}
+
+// readDetachedProcesses returns the detached processes that are still
+// running, and removes any entries left behind by processes that have
+// since exited.
+func readDetachedProcesses() ([]detachedProcess, error) {
+	files, err := os.ReadDir(detachedStateDir(detachedDirName))
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var procs []detachedProcess
+
+	for _, file := range files {
+		path := filepath.Join(detachedStateDir(detachedDirName), file.Name())
+		dat, err := os.ReadFile(path)
+
+		if err != nil {
+			continue
+		}
+
+		var p detachedProcess
+
+		if err := json.Unmarshal(dat, &p); err != nil || !p.Running() {
+			os.Remove(path)
+			continue
+		}
+
+		procs = append(procs, p)
+	}
+
+	return procs, nil
+}
+
+func findDetachedProcess(dir string, command string) (*detachedProcess, error) {
+	procs, err := readDetachedProcesses()
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range procs {
+		if p.Dir == dir && p.Command == command {
+			return &p, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// start runs the script in a new session, so that it isn't affected by
+// signals sent to po's terminal, with its output going to the log file.
+func (p *detachedProcess) start(args []string, env []string) error {
+	running, err := findDetachedProcess(p.Dir, p.Command)
+
+	if err != nil {
+		return err
+	}
+
+	if running != nil {
+		return fmt.Errorf("%s is already running (pid %d)", p.Command, running.Pid)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p.Log), 0700); err != nil {
+		return err
+	}
+
+	logFile, err := os.OpenFile(p.Log, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	defer logFile.Close()
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = env
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	p.Pid = cmd.Process.Pid
+	p.Started = time.Now()
+
+	if err := p.write(); err != nil {
+		return err
+	}
+
+	return cmd.Process.Release()
+}
+
+// detachedWrapper is the shell script that runs a detached script when
+// there's something to do once it ends. It carries on past SIGINT and
+// SIGTERM, which po stop sends to the whole process group, so that it
+// still gets to do it. The shell only has the script's exit status, so a
+// status over 128 is taken to mean the script was killed by a signal.
+const detachedWrapper = `trap : INT TERM
+%s
+code=$?
+signal=
+[ $code -gt 128 ] && signal=SIG$(kill -l $code)
+%s
+%s
+exit $code`
+
+// detachedArgs are the arguments that start a script in the background.
+// po has exited by the time the script ends, so a script with a temporary
+// directory to remove or an on_exit script to run is started by a shell
+// that waits for it and does these things itself.
+func detachedArgs(name string, exec string, options []string, env []string, p *preparedScript, opts runOptions) ([]string, error) {
+	if p.tempDir == "" && opts.OnExit == "" {
+		return p.Args, nil
+	}
+
+	dirs := []string{p.tempDir}
+	onExit := ":"
+
+	if opts.OnExit != "" {
+		e, err := prepareScript(name+":on_exit", exec, options, env, opts.OnExit, opts)
+
+		if err != nil {
+			return nil, err
+		}
+
+		dirs = append(dirs, e.tempDir)
+		onExit = "PO_EXIT_CODE=$code PO_SIGNAL=$signal " + shellQuoteArgs(e.Args)
+	}
+
+	cleanup := ":"
+
+	for _, dir := range dirs {
+		if dir != "" {
+			cleanup += "; rm -rf -- " + shellQuote(dir)
+		}
+	}
+
+	script := fmt.Sprintf(detachedWrapper, shellQuoteArgs(p.Args), onExit, cleanup)
+	return []string{defaultExecPath, "-c", script, name}, nil
+}
+
+// stop sends SIGTERM to the process group, and SIGKILL if it's still
+// running after stopTimeout.
+func (p *detachedProcess) stop() error {
+	if err := syscall.Kill(-p.Pid, syscall.SIGTERM); err != nil && err != syscall.ESRCH {
+		return err
+	}
+
+	for deadline := time.Now().Add(stopTimeout); time.Now().Before(deadline); {
+		if !p.Running() {
+			p.remove()
+			return nil
+		}
+		time.Sleep(stopPollInterval)
+	}
+
+	if err := syscall.Kill(-p.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return err
+	}
+
+	p.remove()
+	return nil
+}
+
+func followFile(out io.Writer, path string) error {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	for {
+		if _, err := io.Copy(out, file); err != nil {
+			return err
+		}
+		time.Sleep(followInterval)
+	}
+}
+
+func printDetachedProcesses(out io.Writer, procs []detachedProcess) {
+	padding := minCommandPadding
+
+	for _, p := range procs {
+		if l := displayWidth(p.Command); l > padding {
+			padding = l
+		}
+	}
+
+	for _, p := range procs {
+		fmt.Fprintf(out, "%s  %7d  %4s  %s\n",
+			rightPad(p.Command, padding),
+			p.Pid,
+			formatAge(time.Since(p.Started)),
+			p.Dir)
+	}
+}
+
+func findRunningCommand(name string) (*detachedProcess, error) {
+	dir, err := os.Getwd()
+
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := findDetachedProcess(dir, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p == nil {
+		return nil, fmt.Errorf("%s is not running in the background", name)
+	}
+
+	return p, nil
+}
+
+func makePsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ps",
+		Short: "List commands running in the background",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			all, err := cmd.Flags().GetBool("all")
+
+			if err != nil {
+				return err
+			}
+
+			dir, err := os.Getwd()
+
+			if err != nil {
+				return err
+			}
+
+			procs, err := readDetachedProcesses()
+
+			if err != nil {
+				return err
+			}
+
+			var listed []detachedProcess
+
+			for _, p := range procs {
+				if all || p.Dir == dir {
+					listed = append(listed, p)
+				}
+			}
+
+			printDetachedProcesses(cmd.OutOrStdout(), listed)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolP("all", "a", false, "list background commands from every project")
+	return cmd
+}
+
+func makeLogsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs COMMAND",
+		Short: "Print the output of a command running in the background",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			follow, err := cmd.Flags().GetBool("follow")
+
+			if err != nil {
+				return err
+			}
+
+			dir, err := os.Getwd()
+
+			if err != nil {
+				return err
+			}
+
+			// The log outlives the process, so it can be read after it exits.
+			p := newDetachedProcess(args[0], dir)
+
+			if follow {
+				return followFile(cmd.OutOrStdout(), p.Log)
+			}
+
+			file, err := os.Open(p.Log)
+
+			if os.IsNotExist(err) {
+				return fmt.Errorf("no logs for %s", args[0])
+			} else if err != nil {
+				return err
+			}
+
+			defer file.Close()
+
+			_, err = io.Copy(cmd.OutOrStdout(), file)
+			return err
+		},
+	}
+
+	cmd.Flags().BoolP("follow", "f", false, "keep printing output as it's written")
+	return cmd
+}
+
+func makeStopCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "stop COMMAND",
+		Short: "Stop a command running in the background",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := findRunningCommand(args[0])
+
+			if err != nil {
+				return err
+			}
+
+			return p.stop()
+		},
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestDetachedScriptCleansUpAndRunsOnExit(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	opts := runOptions{NoScriptCache: true, OnExit: `echo "on_exit $PO_EXIT_CODE"`}
+	p, err := prepareScript("bg", "", nil, os.Environ(), "echo main; exit 3", opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := detachedArgs("bg", "", nil, os.Environ(), p, opts)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(args[0], args[1:]...).Output()
+
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Errorf("expected the script's exit code, got %v", err)
+	}
+
+	if string(out) != "main\non_exit 3\n" {
+		t.Errorf("expected the script and then on_exit to run, got %q", out)
+	}
+
+	if files, _ := os.ReadDir(os.TempDir()); len(files) != 0 {
+		t.Errorf("expected the temporary scripts to be removed, got %v", files)
+	}
+}
+
+func TestDetachedScriptWithNothingToDoAfter(t *testing.T) {
+	p := &preparedScript{Args: []string{"/bin/sh", "script.sh"}}
+	args, err := detachedArgs("bg", "", nil, nil, p, runOptions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(args, " ") != "/bin/sh script.sh" {
+		t.Errorf("expected the script to be started as it is, got %q", args)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	diffContext = 3
+
+	// maxDiffCells limits the size of the table used to find the lines two
+	// texts have in common. Texts too large for it are shown as entirely
+	// replaced.
+	maxDiffCells = 16 * 1024 * 1024
+)
+
+type diffOp struct {
+	Kind byte
+	Line string
+}
+
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// diffLines finds the edits that turn the lines a into the lines b, as a
+// list of kept (' '), removed ('-') and added ('+') lines.
+func diffLines(a []string, b []string) []diffOp {
+	var ops []diffOp
+
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+		return ops
+	}
+
+	width := len(b) + 1
+	common := make([]int32, (len(a)+1)*width)
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				common[i*width+j] = common[(i+1)*width+j+1] + 1
+			case common[(i+1)*width+j] >= common[i*width+j+1]:
+				common[i*width+j] = common[(i+1)*width+j]
+			default:
+				common[i*width+j] = common[i*width+j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && common[(i+1)*width+j] >= common[i*width+j+1]):
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+
+	return ops
+}
+
+func hunkRange(start int, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	} else if length == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+// writeUnifiedDiff prints the differences between two texts in the
+// unified format of diff -u. Nothing is printed if they're the same.
+func writeUnifiedDiff(out io.Writer, fromName string, toName string, from string, to string) {
+	if from == to {
+		return
+	}
+
+	ops := diffLines(splitLines(from), splitLines(to))
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", fromName, toName)
+
+	for start := 0; start < len(ops); {
+		for start < len(ops) && ops[start].Kind == ' ' {
+			start++
+		}
+
+		if start == len(ops) {
+			break
+		}
+
+		// A hunk runs from the first change until there are more than
+		// twice the context lines without one.
+		first := start - diffContext
+
+		if first < 0 {
+			first = 0
+		}
+
+		end := start
+
+		for end < len(ops) {
+			next := end
+
+			for next < len(ops) && ops[next].Kind == ' ' {
+				next++
+			}
+
+			if next == len(ops) || next-end > 2*diffContext {
+				break
+			}
+
+			for next < len(ops) && ops[next].Kind != ' ' {
+				next++
+			}
+
+			end = next
+		}
+
+		last := end + diffContext
+
+		if last > len(ops) {
+			last = len(ops)
+		}
+
+		fromStart, toStart := 0, 0
+
+		for _, op := range ops[:first] {
+			if op.Kind != '+' {
+				fromStart++
+			}
+			if op.Kind != '-' {
+				toStart++
+			}
+		}
+
+		fromLength, toLength := 0, 0
+
+		for _, op := range ops[first:last] {
+			if op.Kind != '+' {
+				fromLength++
+			}
+			if op.Kind != '-' {
+				toLength++
+			}
+		}
+
+		fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(fromStart, fromLength), hunkRange(toStart, toLength))
+
+		for _, op := range ops[first:last] {
+			line := op.Line
+
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n\\ No newline at end of file\n"
+			}
+
+			fmt.Fprintf(out, "%c%s", op.Kind, line)
+		}
+
+		start = last
+	}
+}
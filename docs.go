@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	docsFormatMarkdown = "markdown"
+	maxHeadingLevel    = 6
+)
+
+func markdownCell(s string) string {
+	s = strings.Replace(strings.TrimSpace(s), "\n", " ", -1)
+	return strings.Replace(s, "|", `\|`, -1)
+}
+
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "`" + s + "`"
+}
+
+func markdownHeading(level int, text string) string {
+	if level > maxHeadingLevel {
+		level = maxHeadingLevel
+	}
+	return strings.Repeat("#", level) + " " + text
+}
+
+func writeArgumentsTable(out io.Writer, args []Argument) {
+	fmt.Fprintln(out, "**Arguments**")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "| Name | Required | Description |")
+	fmt.Fprintln(out, "|------|----------|-------------|")
+
+	for _, arg := range args {
+		required := "no"
+
+		if arg.AtLeast() > 0 {
+			required = "yes"
+		}
+
+		fmt.Fprintf(out, "| %s | %s | %s |\n",
+			markdownCode(formatArgDef(arg)), required, markdownCell(arg.Desc))
+	}
+
+	fmt.Fprintln(out)
+}
+
+func writeFlagsTable(out io.Writer, flags map[string]Flag, names []string) {
+	fmt.Fprintln(out, "**Flags**")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "| Name | Short | Type | Default | Description |")
+	fmt.Fprintln(out, "|------|-------|------|---------|-------------|")
+
+	for _, name := range names {
+		flag := flags[name]
+		short := ""
+
+		if flag.Short != "" {
+			short = "-" + flag.Short
+		}
+
+		fmt.Fprintf(out, "| %s | %s | %s | %s | %s |\n",
+			markdownCode("--"+name),
+			markdownCode(short),
+			flag.Type,
+			markdownCode(markdownCell(flag.Default)),
+			markdownCell(flag.Desc))
+	}
+
+	fmt.Fprintln(out)
+}
+
+func writeCodeBlock(out io.Writer, text string) {
+	fmt.Fprintln(out, "```")
+	fmt.Fprintln(out, strings.TrimRight(text, "\n"))
+	fmt.Fprintln(out, "```")
+	fmt.Fprintln(out)
+}
+
+func writeCommandDocs(out io.Writer, name string, command Command, level int, all bool) {
+	if command.Abstract() || (command.Hidden() && !all) {
+		return
+	}
+
+	fmt.Fprintln(out, markdownHeading(level, spacedName(name)))
+	fmt.Fprintln(out)
+
+	if command.Short != "" {
+		fmt.Fprintln(out, strings.TrimSpace(command.Short))
+		fmt.Fprintln(out)
+	}
+
+	usage := "po " + formatUsage(spacedName(name), &command)
+
+	if len(command.Flags) > 0 {
+		usage = "po " + formatUsageWithFlags(spacedName(name), &command)
+	}
+
+	writeCodeBlock(out, usage)
+
+	if command.Long != "" {
+		fmt.Fprintln(out, strings.TrimSpace(command.Long))
+		fmt.Fprintln(out)
+	}
+
+	if command.Extends != "" {
+		fmt.Fprintf(out, "Extends %s.\n\n", markdownCode(spacedName(command.Extends)))
+	}
+
+	if len(command.Args) > 0 {
+		writeArgumentsTable(out, command.Args)
+	}
+
+	if len(command.Flags) > 0 {
+		writeFlagsTable(out, command.Flags, command.FlagNames())
+	}
+
+	if command.Example != "" || len(command.Examples) > 0 {
+		fmt.Fprintln(out, "**Example**")
+		fmt.Fprintln(out)
+
+		if command.Example != "" {
+			writeCodeBlock(out, command.Example)
+		}
+
+		for _, example := range command.Examples {
+			if example.Desc != "" {
+				fmt.Fprintln(out, strings.TrimSpace(example.Desc))
+				fmt.Fprintln(out)
+			}
+
+			writeCodeBlock(out, example.Cmd)
+		}
+	}
+
+	writeCommandsDocs(out, command.Commands, command.CommandNames(), name+":", level+1, all)
+}
+
+func writeCommandsDocs(out io.Writer, commands map[string]Command, names []string, prefix string, level int, all bool) {
+	for _, name := range names {
+		writeCommandDocs(out, prefix+name, commands[name], level, all)
+	}
+}
+
+func writeDocs(out io.Writer, config *Config, all bool) {
+	fmt.Fprintln(out, markdownHeading(1, "Commands"))
+	fmt.Fprintln(out)
+	writeCommandsDocs(out, config.Commands, config.CommandNames(), "", 2, all)
+}
+
+func makeDocsCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "docs",
+		Short: "Generate documentation for the project's commands",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, err := cmd.Flags().GetString("format")
+
+			if err != nil {
+				return err
+			}
+
+			if format != docsFormatMarkdown {
+				return fmt.Errorf("unsupported format: %s", format)
+			}
+
+			all, err := cmd.Flags().GetBool("all")
+
+			if err != nil {
+				return err
+			}
+
+			output, err := cmd.Flags().GetString("output")
+
+			if err != nil {
+				return err
+			}
+
+			if output == "" {
+				writeDocs(cmd.OutOrStdout(), config, all)
+				return nil
+			}
+
+			var buf strings.Builder
+			writeDocs(&buf, config, all)
+			return os.WriteFile(output, []byte(buf.String()), 0644)
+		},
+	}
+
+	cmd.Flags().String("format", docsFormatMarkdown, "output format")
+	cmd.Flags().StringP("output", "o", "", "write the documentation to a file")
+	cmd.Flags().Bool("all", false, "include hidden commands")
+	return cmd
+}
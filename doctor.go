@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+)
+
+const doctorCmdName = "doctor"
+
+// doctorCheck is the result of one of the checks po doctor makes. A check
+// with a Problem is one that stops commands from running; one with only a
+// Note is working, but not in the usual way.
+type doctorCheck struct {
+	Name    string
+	Problem string
+	Note    string
+}
+
+func (check doctorCheck) Print(out io.Writer) {
+	switch {
+	case check.Problem != "":
+		fmt.Fprintf(out, "problem  %s: %s\n", check.Name, check.Problem)
+	case check.Note != "":
+		fmt.Fprintf(out, "note     %s: %s\n", check.Name, check.Note)
+	default:
+		fmt.Fprintf(out, "ok       %s\n", check.Name)
+	}
+}
+
+// checkScriptsCacheNoexec looks for a cache mounted noexec, which would
+// stop scripts being run from it if po didn't keep them elsewhere.
+func checkScriptsCacheNoexec() doctorCheck {
+	check := doctorCheck{Name: "scripts cache mount"}
+	dir, err := cacheDir(scriptsCacheName)
+
+	if err != nil {
+		check.Problem = err.Error()
+		return check
+	}
+
+	if !isNoexecDir(dir) {
+		return check
+	}
+
+	if fallback, err := scriptsDir(); err == nil && fallback != dir {
+		check.Note = fmt.Sprintf("%s is mounted noexec, so scripts are kept in %s instead", dir, fallback)
+	} else {
+		check.Problem = fmt.Sprintf("%s and %s are both mounted noexec; "+
+			"set cache_dir in the config to a directory that isn't", dir, noexecFallbackDir())
+	}
+
+	return check
+}
+
+// checkScriptsDirWritable makes sure scripts can be written where they're
+// kept, as otherwise each one is written to a temporary file instead.
+func checkScriptsDirWritable() doctorCheck {
+	check := doctorCheck{Name: "scripts directory"}
+	dir, err := scriptsDir()
+
+	if err != nil {
+		check.Problem = err.Error()
+		return check
+	}
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		check.Note = fmt.Sprintf("%s will be made when a command is first run", dir)
+		return check
+	}
+
+	file, err := os.CreateTemp(dir, ".doctor-")
+
+	if err != nil {
+		check.Problem = fmt.Sprintf("cannot write to %s, so scripts are written to temporary files (%v)", dir, err)
+		return check
+	}
+
+	file.Close()
+	os.Remove(file.Name())
+	return check
+}
+
+func makeDoctorCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   doctorCmdName,
+		Short: "Check that po can run commands on this system",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			checks := []doctorCheck{
+				checkScriptsCacheNoexec(),
+				checkScriptsDirWritable(),
+			}
+
+			problems := 0
+
+			for _, check := range checks {
+				check.Print(cmd.OutOrStdout())
+
+				if check.Problem != "" {
+					problems++
+				}
+			}
+
+			if problems == 1 {
+				return fmt.Errorf("found 1 problem")
+			} else if problems > 1 {
+				return fmt.Errorf("found %d problems", problems)
+			}
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// poMainEnvVar makes the test binary run po itself, so that tests can run
+// po from start to finish as a separate process.
+const poMainEnvVar = "PO_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(poMainEnvVar) != "" {
+		main()
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+type poResult struct {
+	Stdout string
+	Stderr string
+	Code   int
+}
+
+// runPo runs po in a project with the config given, and with a home of
+// its own.
+func runPo(t *testing.T, config string, args ...string) poResult {
+	t.Helper()
+
+	dir := t.TempDir()
+	home := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, configFileName), config)
+
+	var stdout, stderr bytes.Buffer
+	po := exec.Command(os.Args[0], args...)
+	po.Dir = dir
+	po.Stdout = &stdout
+	po.Stderr = &stderr
+	po.Env = append(os.Environ(),
+		poMainEnvVar+"=1",
+		"HOME="+home,
+		"XDG_CONFIG_HOME="+filepath.Join(home, "config"),
+		"XDG_CACHE_HOME="+filepath.Join(home, "cache"),
+		"XDG_STATE_HOME="+filepath.Join(home, "state"),
+		"NO_COLOR=1",
+	)
+
+	err := po.Run()
+
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		t.Fatal(err)
+	}
+
+	return poResult{stdout.String(), stderr.String(), po.ProcessState.ExitCode()}
+}
+
+func TestExitCodes(t *testing.T) {
+	config := `
+commands:
+  greet:
+    args:
+      - var: name
+    script: echo hello $name
+  fail:
+    script: exit 7
+`
+
+	for _, test := range []struct {
+		name   string
+		config string
+		args   []string
+		code   int
+	}{
+		{"success", config, []string{"greet", "world"}, 0},
+		{"script failure", config, []string{"fail"}, 7},
+		{"unknown command", config, []string{"nope"}, exitUnknownCommand},
+		{"missing argument", config, []string{"greet"}, exitUsage},
+		{"too many arguments", config, []string{"greet", "a", "b"}, exitUsage},
+		{"unknown flag", config, []string{"greet", "--bogus", "world"}, exitUsage},
+		{"bad config", "commands: [", []string{"greet", "world"}, exitConfig},
+		{"invalid config", "commands:\n  greet:\n    script: a\n    script_url: https://example.com/b\n", []string{"greet"}, exitConfig},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if result := runPo(t, test.config, test.args...); result.Code != test.code {
+				t.Errorf("expected exit code %d, got %d: %s", test.code, result.Code, result.Stderr)
+			}
+		})
+	}
+}
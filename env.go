@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	envCmdName    = "env"
+	envFormatFlag = "format"
+
+	envFormatText = "text"
+	envFormatRaw0 = "raw0"
+)
+
+// envFormat is set when po env is run, so that the command it names prints
+// the environment it would have been run with instead of running.
+var envFormat string
+
+// escapeControl escapes the control characters in a value, along with
+// backslashes, so that a value holding a newline can't be mistaken for
+// more than one line when it's printed.
+func escapeControl(s string) string {
+	var b strings.Builder
+
+	for _, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// printEnv prints environment variables as KEY=value lines with their
+// values escaped, or in the raw0 format, exactly as they are with each
+// terminated by a NUL, for other programs to read.
+func printEnv(out io.Writer, env []string, format string) error {
+	for _, kv := range env {
+		var err error
+
+		if format == envFormatRaw0 {
+			_, err = fmt.Fprintf(out, "%s\x00", kv)
+		} else {
+			_, err = fmt.Fprintf(out, "%s\n", escapeControl(kv))
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitEnvFormat splits the --format options at the start of the arguments
+// to po env from the rest.
+func splitEnvFormat(args []string) (string, []string, error) {
+	format := envFormatText
+	prefix := "--" + envFormatFlag + "="
+
+	for len(args) > 0 {
+		if args[0] == "--"+envFormatFlag && len(args) > 1 {
+			format, args = args[1], args[2:]
+		} else if strings.HasPrefix(args[0], prefix) {
+			format, args = strings.TrimPrefix(args[0], prefix), args[1:]
+		} else {
+			break
+		}
+	}
+
+	if format != envFormatText && format != envFormatRaw0 {
+		return "", nil, usageError{fmt.Errorf("invalid format '%s' (must be %s or %s)",
+			format, envFormatText, envFormatRaw0)}
+	}
+
+	return format, args, nil
+}
+
+// envArgs removes 'env', and the --format option that can follow it, from
+// the arguments to po if they're followed by a command from the config.
+// The command is then parsed as usual, but prints its environment instead
+// of running. If it's followed by anything else, the arguments are left
+// for po env to reject.
+func envArgs(config *Config, args []string) ([]string, string, error) {
+	positional := positionalArgs(args)
+
+	if len(positional) == 0 || positional[0] != envCmdName {
+		return args, "", nil
+	}
+
+	i := 0
+
+	for args[i] != envCmdName {
+		i++
+	}
+
+	before := args[:i]
+	format, rest, err := splitEnvFormat(args[i+1:])
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	stripped := append(append([]string(nil), before...), rest...)
+
+	if _, ok := invokedCommand(config, stripped); !ok {
+		return args, "", nil
+	}
+
+	return stripped, format, nil
+}
+
+// makeEnvCommand makes po env, which prints the environment variables po
+// would add when running a command with the given arguments and flags.
+// Commands from the config are handled by the command itself, so this
+// only handles anything else.
+func makeEnvCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                "env [--format FORMAT] COMMAND [ARGS...]",
+		Short:              "Print the environment a command would run with",
+		DisableFlagParsing: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, rest, err := splitEnvFormat(args)
+
+			if err != nil {
+				return err
+			}
+
+			positional := positionalArgs(rest)
+
+			switch {
+			case len(positional) > 0:
+				return usageError{fmt.Errorf("unknown command %q in the config", positional[0])}
+			case hasAnyArg(rest, "-h", "--help"):
+				return cmd.Help()
+			default:
+				return usageError{fmt.Errorf("requires the name of a command")}
+			}
+		},
+		ValidArgsFunction: makeRunCommand(config).ValidArgsFunction,
+	}
+
+	cmd.Flags().String(envFormatFlag, envFormatText,
+		fmt.Sprintf("output format (%s or %s)", envFormatText, envFormatRaw0))
+	return cmd
+}
+
+const (
+	defaultArgsVar  = "ARGS"
+	defaultFlagsVar = "FLAGS"
+)
+
+var envVarNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envNames are the names of the variables po generates for a command's
+// arguments and flags. The prefix goes in front of all of them, including
+// the variables for each argument and flag.
+type envNames struct {
+	Prefix string
+	Args   string
+	Flags  string
+}
+
+func (config *Config) envNames() envNames {
+	names := envNames{
+		Prefix: config.EnvPrefix,
+		Args:   defaultArgsVar,
+		Flags:  defaultFlagsVar,
+	}
+
+	if config.ArgsVar != "" {
+		names.Args = config.ArgsVar
+	}
+
+	if config.FlagsVar != "" {
+		names.Flags = config.FlagsVar
+	}
+
+	names.Args = names.Prefix + names.Args
+	names.Flags = names.Prefix + names.Flags
+	return names
+}
+
+func (config *Config) validateEnvNames() error {
+	for _, key := range []struct{ name, value string }{
+		{"args_var", config.ArgsVar},
+		{"flags_var", config.FlagsVar},
+		{"env_prefix", config.EnvPrefix},
+	} {
+		if key.value != "" && !envVarNameRegexp.MatchString(key.value) {
+			return fmt.Errorf("invalid %s '%s' (must be letters, digits and underscores, "+
+				"and not start with a digit)", key.name, key.value)
+		}
+	}
+
+	return nil
+}
+
+// warnShadowedEnvVars warns when a variable that po generates for a
+// command replaces one it inherited, such as a FLAGS holding compiler
+// flags, unless there's a prefix that rules this out. A script run by po
+// that runs po again inherits the variables of the outer command, so
+// these are expected and aren't warned about.
+func warnShadowedEnvVars(name string, names envNames, generated []string) {
+	if names.Prefix != "" || os.Getenv("PO_COMMAND") != "" {
+		return
+	}
+
+	for _, kv := range generated {
+		key := kv[:strings.IndexByte(kv, '=')]
+
+		if _, ok := os.LookupEnv(key); ok {
+			printWarning("%s is set in the environment, and is replaced by po for command %s; "+
+				"set env_prefix, args_var or flags_var in the config to keep it", key, spacedName(name))
+		}
+	}
+}
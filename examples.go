@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const examplesCmdName = "examples"
+
+// exampleLines are the lines of a command's examples that run po, which
+// are the ones that can be checked.
+func exampleLines(command *Command) []string {
+	text := []string{command.Example}
+
+	for _, example := range command.Examples {
+		text = append(text, example.Cmd)
+	}
+
+	var lines []string
+
+	for _, t := range text {
+		for _, line := range strings.Split(t, "\n") {
+			if line = strings.TrimSpace(line); strings.HasPrefix(line, "po ") {
+				lines = append(lines, line)
+			}
+		}
+	}
+
+	return lines
+}
+
+// splitExampleLine splits an example into words as a shell would, as far
+// as the first pipe, redirect or other operator, or a comment, which ends
+// the part of the line that po is given. As in a shell, a backslash
+// escapes the character after it, and a # only starts a comment at the
+// start of a word.
+func splitExampleLine(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord := false
+	escaped := false
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			// Within double quotes, a backslash only escapes the characters
+			// that would otherwise be special there.
+			if quote == '"' && !strings.ContainsRune("$`\"\\", r) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote == '\'' && r != '\'':
+			word.WriteRune(r)
+		case quote == '"' && r != '"':
+			word.WriteRune(r)
+		case quote != 0:
+			quote = 0
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case r == '#' && inWord:
+			word.WriteRune(r)
+		case strings.ContainsRune("|&;<>#", r):
+			if inWord {
+				words = append(words, word.String())
+			}
+			return words, nil
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	} else if escaped {
+		return nil, fmt.Errorf("backslash at the end of the line")
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+// exampleRootFlags are the flags given to po examples that change which
+// commands there are, so they're passed on to the examples it checks.
+var exampleRootFlags = []string{userFlag, offlineFlag, trustAllFlag, lenientFlag}
+
+// checkExample checks that po would accept an example. Config commands are
+// run again with --dry-run, which checks their arguments and flags, and
+// builds their environment, without running their scripts. A built-in
+// command could do something even with --dry-run, so its arguments and
+// flags are only parsed.
+func checkExample(root *cobra.Command, args []string) error {
+	cmd, rest, err := root.Find(args)
+
+	if err != nil {
+		return err
+	} else if cmd == root {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	if !isConfigCommand(cmd) && cmd.Name() != runCmdName {
+		if err := cmd.ParseFlags(rest); err != nil {
+			return err
+		}
+		return cmd.ValidateArgs(cmd.Flags().Args())
+	}
+
+	executable, err := os.Executable()
+
+	if err != nil {
+		return err
+	}
+
+	poArgs := []string{"--dry-run", "--" + skipChecksFlag, "--" + noInputFlag}
+
+	for _, name := range exampleRootFlags {
+		if f := root.PersistentFlags().Lookup(name); f != nil && f.Changed {
+			poArgs = append(poArgs, "--"+name+"="+f.Value.String())
+		}
+	}
+
+	var stderr bytes.Buffer
+	po := exec.Command(executable, append(poArgs, args...)...)
+	po.Stdout = io.Discard
+	po.Stderr = &stderr
+
+	if err := po.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return err
+		}
+		return fmt.Errorf("%s", exampleProblem(stderr.String()))
+	}
+
+	return nil
+}
+
+// exampleProblem picks out the error from what po printed when it refused
+// an example, leaving out any warnings and the usage hint.
+func exampleProblem(stderr string) string {
+	var problem string
+
+	for _, line := range strings.Split(strings.TrimSpace(stderr), "\n") {
+		switch {
+		case strings.HasPrefix(line, "ERROR ["):
+			if i := strings.Index(line, "]: "); i >= 0 {
+				return line[i+3:]
+			}
+			return line
+		case strings.HasPrefix(line, "error: "):
+			return strings.TrimPrefix(line, "error: ")
+		case line != "" && !strings.HasPrefix(line, "Run '"):
+			problem = line
+		}
+	}
+
+	if problem == "" {
+		return "refused by po"
+	}
+
+	return problem
+}
+
+// exampleCommandNames are the commands whose examples po examples covers:
+// all of them, or the one given along with its subcommands.
+func exampleCommandNames(config *Config, args []string) ([]string, error) {
+	if len(args) == 0 {
+		return commandNames(config.Commands, ""), nil
+	}
+
+	name := strings.Join(args, ":")
+	command, _, err := findCommand(config, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]string{name}, commandNames(command.Commands, name+":")...), nil
+}
+
+func printExamples(out io.Writer, config *Config, names []string) {
+	bold := color.New(color.Bold)
+	first := true
+
+	for _, name := range names {
+		command, _, err := findCommand(config, name)
+
+		if err != nil || (command.Example == "" && len(command.Examples) == 0) {
+			continue
+		}
+
+		if !first {
+			fmt.Fprintln(out)
+		}
+
+		first = false
+		bold.Fprintln(out, spacedName(name))
+		writeExamples(out, command.Example, command.Examples)
+	}
+}
+
+// checkExamples checks each example that runs po, and prints the ones po
+// wouldn't accept along with the config they're in. It returns how many
+// there were.
+func checkExamples(out io.Writer, root *cobra.Command, config *Config, names []string) int {
+	failures := 0
+
+	for _, name := range names {
+		command, _, err := findCommand(config, name)
+
+		if err != nil {
+			continue
+		}
+
+		source := config.commandSources[strings.SplitN(name, ":", 2)[0]]
+
+		for _, line := range exampleLines(command) {
+			words, err := splitExampleLine(line)
+
+			if err == nil {
+				err = checkExample(root, words[1:])
+			}
+
+			if err != nil {
+				fmt.Fprintf(out, "%s: command %s: example %q: %v\n", source, spacedName(name), line, err)
+				failures++
+			}
+		}
+	}
+
+	return failures
+}
+
+func makeExamplesCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   examplesCmdName + " [COMMAND]",
+		Short: "Print the examples of commands, or check that they still work",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			names, err := exampleCommandNames(config, args)
+
+			if err != nil {
+				return err
+			}
+
+			if check, _ := cmd.Flags().GetBool("check"); !check {
+				printExamples(cmd.OutOrStdout(), config, names)
+				return nil
+			}
+
+			failures := checkExamples(cmd.OutOrStdout(), cmd.Root(), config, names)
+
+			if failures == 1 {
+				return fmt.Errorf("found 1 example that po would not accept")
+			} else if failures > 1 {
+				return fmt.Errorf("found %d examples that po would not accept", failures)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("check", false, "check that the examples that run po name a command, its arguments and flags correctly")
+	return cmd
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitExampleLine(t *testing.T) {
+	for _, test := range []struct {
+		line  string
+		words []string
+	}{
+		{`po build --target x86 # the default`, []string{"po", "build", "--target", "x86"}},
+		{`po tag v1#beta`, []string{"po", "tag", "v1#beta"}},
+		{`po tag "a"#b`, []string{"po", "tag", "a#b"}},
+		{`po grep foo | wc -l`, []string{"po", "grep", "foo"}},
+		{`po say hello\ world`, []string{"po", "say", "hello world"}},
+		{`po say \#1 \| \'`, []string{"po", "say", "#1", "|", "'"}},
+		{`po say "a \"b\" \$c \d"`, []string{"po", "say", `a "b" $c \d`}},
+		{`po say 'a \b'`, []string{"po", "say", `a \b`}},
+	} {
+		words, err := splitExampleLine(test.line)
+
+		if err != nil {
+			t.Errorf("%s: %v", test.line, err)
+		} else if !reflect.DeepEqual(words, test.words) {
+			t.Errorf("%s: expected %q, got %q", test.line, test.words, words)
+		}
+	}
+
+	for _, line := range []string{`po say "hello`, `po say hello\`} {
+		if words, err := splitExampleLine(line); err == nil {
+			t.Errorf("%s: expected an error, got %q", line, words)
+		}
+	}
+}
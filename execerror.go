@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// scriptExecError explains why a script couldn't be started, as the error
+// from exec alone doesn't say which file it was about. A missing file is
+// nearly always a bad interpreter, while a file that can't be run is either
+// an interpreter without its executable bit or a script on a filesystem
+// mounted noexec.
+func scriptExecError(name string, p *preparedScript, err error) error {
+	interpreter := p.Args[0]
+
+	// A container or remote host is run by its runtime or ssh, and the
+	// interpreter is only looked for once it's running.
+	if p.Script == "" {
+		return fmt.Errorf("cannot run %s for command '%s': %v", interpreter, name, err)
+	}
+
+	if errors.Is(err, syscall.ENOENT) {
+		if _, statErr := os.Stat(interpreter); os.IsNotExist(statErr) {
+			return fmt.Errorf("interpreter '%s' not found; check the 'exec' key of command '%s', "+
+				"or the 'shell' key of the config", interpreter, name)
+		}
+		return fmt.Errorf("cannot run script %s for command '%s' with %s: %v", p.Script, name, interpreter, err)
+	}
+
+	if errors.Is(err, syscall.EACCES) {
+		if info, statErr := os.Stat(interpreter); statErr == nil && info.Mode()&0111 == 0 {
+			return fmt.Errorf("interpreter '%s' is not executable; check the 'exec' key of command '%s'",
+				interpreter, name)
+		}
+		if isNoexecDir(filepath.Dir(interpreter)) {
+			return fmt.Errorf("interpreter '%s' is on a filesystem mounted noexec; check the 'exec' key of command '%s'",
+				interpreter, name)
+		}
+		return fmt.Errorf("permission denied running script %s for command '%s' with %s; "+
+			"if %s is on a filesystem mounted noexec, set 'cache_dir' in the config to a directory that isn't",
+			p.Script, name, interpreter, filepath.Dir(p.Script))
+	}
+
+	return fmt.Errorf("cannot run script %s for command '%s' with %s: %v", p.Script, name, interpreter, err)
+}
+
+// isStartError is true for an error from starting a process, rather than
+// from anything done before it, such as opening its log file.
+func isStartError(err error) bool {
+	var pathErr *fs.PathError
+	return errors.As(err, &pathErr) && pathErr.Op == "fork/exec"
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"github.com/spf13/cobra"
+	"strings"
+)
+
+// Exit codes let scripts that run po tell why it failed. A script that
+// fails exits with its own code, rather than exitFailure.
+const (
+	exitFailure        = 1
+	exitUsage          = 2
+	exitConfig         = 3
+	exitUnknownCommand = 127
+	exitInterrupted    = 130
+)
+
+// usageError is an error in how po was called, such as an unknown flag or
+// the wrong number of arguments.
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e usageError) Unwrap() error {
+	return e.err
+}
+
+func isUnknownCommandError(err error) bool {
+	return strings.HasPrefix(err.Error(), "unknown command ")
+}
+
+func exitCode(err error) int {
+	var usageErr usageError
+
+	switch {
+	case !errors.As(err, &usageErr):
+		return exitFailure
+	case isUnknownCommandError(err):
+		return exitUnknownCommand
+	default:
+		return exitUsage
+	}
+}
+
+// markUsageErrors makes the errors from parsing flags and checking the
+// arguments of a command and its subcommands usage errors, so that they
+// can be told apart from errors in running the command.
+func markUsageErrors(cmd *cobra.Command) {
+	if !cmd.HasParent() {
+		cmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+			return usageError{err}
+		})
+	}
+
+	if validateArgs := cmd.Args; validateArgs != nil {
+		cmd.Args = func(cmd *cobra.Command, args []string) error {
+			if err := validateArgs(cmd, args); err != nil {
+				return usageError{err}
+			}
+			return nil
+		}
+	}
+
+	for _, subCmd := range cmd.Commands() {
+		markUsageErrors(subCmd)
+	}
+}
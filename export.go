@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// findCommand finds a command by its full name, such as db:migrate, or by
+// an alias, along with the environment the config gives it.
+func findCommand(config *Config, name string) (*Command, map[string]string, error) {
+	if _, ok := config.Commands[name]; !ok {
+		if target, ok := config.Aliases[name]; ok {
+			name = target
+		}
+	}
+
+	env := make(map[string]string)
+	mergeStringMaps(env, config.Environment)
+
+	commands := config.Commands
+	var command *Command
+
+	for _, part := range strings.Split(name, ":") {
+		cmd, ok := commands[part]
+
+		if !ok {
+			return nil, nil, fmt.Errorf("no such command: %s", name)
+		}
+
+		mergeStringMaps(env, cmd.Environment)
+		command = &cmd
+		commands = cmd.Commands
+	}
+
+	return command, env, nil
+}
+
+var sensitiveEnvRegexp = regexp.MustCompile(`(?i)(secret|token|passw(or)?d|credential|api_?key|private_?key)`)
+
+func isSensitiveEnvVar(name string) bool {
+	return sensitiveEnvRegexp.MatchString(name)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func exportEnvironment(out io.Writer, env map[string]string) {
+	if len(env) == 0 {
+		return
+	}
+
+	fmt.Fprintln(out, "\n# Environment")
+
+	for _, k := range sortedKeys(env) {
+		if isSensitiveEnvVar(k) {
+			fmt.Fprintf(out, "export %s=\"${%s:?set %s before running}\"\n", k, k, k)
+		} else {
+			fmt.Fprintf(out, "export %s=%s\n", k, shellQuote(env[k]))
+		}
+	}
+}
+
+// shellVarName is a name that a shell can assign to, as the names of
+// arguments and flags can have hyphens in them.
+func shellVarName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func exportArguments(out io.Writer, names envNames, defs []Argument) {
+	if len(defs) == 0 {
+		return
+	}
+
+	fmt.Fprintln(out, "\n# Arguments")
+	vars := make([]string, len(defs))
+
+	for i, def := range defs {
+		name := shellVarName(names.Prefix + def.Var)
+
+		if def.AtLeast() > 0 {
+			fmt.Fprintf(out, "export %s=\"${%s:?argument %s is required}\"\n",
+				name, name, strings.ToUpper(def.Var))
+		} else {
+			fmt.Fprintf(out, "export %s=\"${%s:-}\"\n", name, name)
+		}
+		vars[i] = "$" + name
+	}
+
+	fmt.Fprintf(out, "export %s=\"${%s:-%s}\"\n", names.Args, names.Args, strings.Join(vars, " "))
+}
+
+// exportFlags exports the defaults of a command's flags, in the order they
+// were declared.
+func exportFlags(out io.Writer, names envNames, command *Command) {
+	if len(command.Flags) == 0 {
+		return
+	}
+
+	fmt.Fprintln(out, "\n# Flags")
+
+	for _, name := range command.FlagNames() {
+		def := command.Flags[name]
+		value := def.Default
+		name = shellVarName(names.Prefix + name)
+
+		if def.Type == "bool" && !parseBool(value) {
+			value = ""
+		}
+
+		fmt.Fprintf(out, "export %s=\"${%s:-%s}\"\n", name, name, value)
+	}
+
+	fmt.Fprintf(out, "export %s=\"${%s:-}\"\n", names.Flags, names.Flags)
+}
+
+func exportCommand(out io.Writer, config *Config, name string) error {
+	command, env, err := findCommand(config, name)
+
+	if err != nil {
+		return err
+	}
+
+	if !command.HasScript() {
+		return fmt.Errorf("command %s has no script to export", name)
+	}
+
+	if command.IsTemplate() {
+		return fmt.Errorf("command %s is a template and cannot be exported", name)
+	}
+
+	if !command.AvailableOn(runtime.GOOS) {
+		return fmt.Errorf("command %s is not available on %s", name, runtime.GOOS)
+	}
+
+	script := command.PlatformScript(runtime.GOOS)
+
+	if script == "" {
+		if script, err = readScriptUrl(command.ScriptUrl, command.Sha256); err != nil {
+			return err
+		}
+	}
+
+	shell := interpreterOrDefault(config.Shell)
+
+	if command.Exec != "" {
+		shell = defaultExecPath
+	}
+
+	fmt.Fprintf(out, "#! %s\n", shell)
+	fmt.Fprintf(out, "# Exported from po command '%s'\n", name)
+
+	exportEnvironment(out, env)
+	exportArguments(out, config.envNames(), command.Args)
+	exportFlags(out, config.envNames(), command)
+
+	if command.WorkDir != "" {
+		fmt.Fprintf(out, "\ncd %s || exit 1\n", shellQuote(command.WorkDir))
+	}
+
+	fmt.Fprintln(out)
+
+	if command.Exec != "" {
+		fmt.Fprintln(out, "script=$(mktemp) || exit 1")
+		fmt.Fprintln(out, "trap 'rm -f \"$script\"' EXIT")
+		delimiter := heredocDelimiter(script)
+		fmt.Fprintf(out, "cat > \"$script\" <<'%s'\n", delimiter)
+		fmt.Fprint(out, strings.TrimRight(script, "\n")+"\n")
+		fmt.Fprintln(out, delimiter)
+		fmt.Fprintf(out, "%s \"$script\" \"$@\"\n", command.Exec)
+		return nil
+	}
+
+	if command.Strict() && len(config.ShellOptions) > 0 {
+		fmt.Fprintf(out, "set %s\n", strings.Join(config.ShellOptions, " "))
+	}
+
+	if command.IncludePrelude() {
+		script = composeScript(config.Prelude, script)
+	}
+
+	fmt.Fprint(out, strings.TrimRight(script, "\n")+"\n")
+	return nil
+}
+
+func makeExportCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "export COMMAND",
+		Short: "Export a command as a standalone script",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			output, err := cmd.Flags().GetString("output")
+
+			if err != nil {
+				return err
+			}
+
+			if output == "" {
+				return exportCommand(cmd.OutOrStdout(), config, args[0])
+			}
+
+			var buf strings.Builder
+
+			if err := exportCommand(&buf, config, args[0]); err != nil {
+				return err
+			}
+
+			return os.WriteFile(output, []byte(buf.String()), os.FileMode(0755))
+		},
+	}
+
+	cmd.Flags().StringP("output", "o", "", "write the script to a file")
+	return cmd
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestExportKeepsFlagOrder(t *testing.T) {
+	config := mustParseConfig(t, `
+commands:
+  deploy:
+    flags:
+      zone: {type: string, default: eu}
+      force: {type: bool}
+      attempts: {type: int, default: "3"}
+    script: echo $zone
+`)
+
+	var out bytes.Buffer
+
+	if err := exportCommand(&out, config, "deploy"); err != nil {
+		t.Fatal(err)
+	}
+
+	zone := strings.Index(out.String(), "export zone=")
+	force := strings.Index(out.String(), "export force=")
+	attempts := strings.Index(out.String(), "export attempts=")
+
+	if zone < 0 || !(zone < force && force < attempts) {
+		t.Errorf("expected the flags in the order they were declared, got:\n%s", out.String())
+	}
+}
+
+func TestExportFindsAliasesAndNamesVarsForTheShell(t *testing.T) {
+	config := mustParseConfig(t, `
+aliases:
+  d: deploy
+commands:
+  deploy:
+    args:
+      - var: target-env
+    flags:
+      dry-run: {type: bool}
+    exec: python3
+    script: |
+      print("deploy")
+      PO_SCRIPT_EOF
+`)
+
+	var out bytes.Buffer
+
+	if err := exportCommand(&out, config, "d"); err != nil {
+		t.Fatalf("expected the alias to be exported, got %v", err)
+	}
+
+	for _, line := range []string{
+		`export target_env="${target_env:?argument TARGET-ENV is required}"`,
+		`export dry_run="${dry_run:-}"`,
+		`cat > "$script" <<'PO_SCRIPT_EOF_1'`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("expected %s in:\n%s", line, out.String())
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lookupCommand finds the map that holds a command given its full name,
+// so that the command can be replaced in place.
+func lookupCommand(commands map[string]Command, name string) (map[string]Command, string, bool) {
+	parts := strings.Split(name, ":")
+
+	for _, part := range parts[:len(parts)-1] {
+		cmd, ok := commands[part]
+
+		if !ok {
+			return nil, "", false
+		}
+
+		commands = cmd.Commands
+	}
+
+	key := parts[len(parts)-1]
+	_, ok := commands[key]
+	return commands, key, ok
+}
+
+type extendsResolver struct {
+	commands map[string]Command
+	resolved map[string]bool
+}
+
+func (r *extendsResolver) resolve(name string, chain []string) error {
+	if r.resolved[name] {
+		return nil
+	}
+
+	for _, n := range chain {
+		if n == name {
+			return fmt.Errorf("extends cycle: %s", strings.Join(append(chain, name), " -> "))
+		}
+	}
+
+	commands, key, _ := lookupCommand(r.commands, name)
+	command := commands[key]
+
+	if command.Extends != "" {
+		baseCommands, baseKey, ok := lookupCommand(r.commands, command.Extends)
+
+		if !ok {
+			return fmt.Errorf("command %s extends %s, which does not exist", name, command.Extends)
+		}
+
+		if err := r.resolve(command.Extends, append(chain, name)); err != nil {
+			return err
+		}
+
+		base := baseCommands[baseKey]
+		extended := base.inheritable()
+		tracef("extending %s from %s", name, command.Extends)
+		extended.Merge(&command)
+		commands[key] = extended
+	}
+
+	r.resolved[name] = true
+	return nil
+}
+
+// resolveExtends replaces each command that extends another with a copy of
+// the base's script, arguments, flags, environment and interpreter, with
+// the command's own settings merged over the top. This happens once all
+// the configs are merged, so a command can extend one from any of them.
+func resolveExtends(config *Config) error {
+	r := &extendsResolver{
+		commands: config.Commands,
+		resolved: make(map[string]bool),
+	}
+
+	for _, name := range commandNames(config.Commands, "") {
+		if err := r.resolve(name, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
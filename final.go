@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// finalViolation is a command marked final in Source, whose script a
+// config merged over it from Override tried to change, or that it tried to
+// mark as not final.
+type finalViolation struct {
+	Name     string
+	Source   string
+	Override string
+	Unmarked bool
+}
+
+func (v finalViolation) String() string {
+	if v.Unmarked {
+		return fmt.Sprintf("command %s is final in %s, and cannot be made not final by %s",
+			v.Name, v.Source, v.Override)
+	}
+	return fmt.Sprintf("command %s is final in %s, and its script cannot be changed by %s",
+		v.Name, v.Source, v.Override)
+}
+
+// finalCommand is a command marked final, and the config it was marked in.
+type finalCommand struct {
+	Name   string
+	Source string
+}
+
+func sortedCommandNames(commands map[string]Command) []string {
+	names := make([]string, 0, len(commands))
+
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// changesScript is true if merging b over a would change what a runs.
+// Descriptions, flags and the like can be changed on a final command, but
+// not its script or exec.
+func changesScript(a *Command, b *Command) bool {
+	differs := func(x, y string) bool {
+		return y != "" && x != y
+	}
+
+	return (b.Script != "" || b.ScriptUrl != "") &&
+		(a.Script != b.Script || a.ScriptUrl != b.ScriptUrl || a.ScriptFile != b.ScriptFile) ||
+		differs(a.ScriptDarwin, b.ScriptDarwin) ||
+		differs(a.ScriptLinux, b.ScriptLinux) ||
+		differs(a.ScriptWindows, b.ScriptWindows) ||
+		differs(a.Exec, b.Exec)
+}
+
+func finalViolations(name string, a, b *Command, source, override string) []finalViolation {
+	var violations []finalViolation
+
+	if a.Final() && b.FinalP != nil && !*b.FinalP {
+		violations = append(violations, finalViolation{name, source, override, true})
+	} else if a.Final() && changesScript(a, b) {
+		violations = append(violations, finalViolation{name, source, override, false})
+	}
+
+	for _, subName := range sortedCommandNames(b.Commands) {
+		if subA, ok := a.Commands[subName]; ok {
+			subB := b.Commands[subName]
+			violations = append(violations,
+				finalViolations(name+" "+subName, &subA, &subB, source, override)...)
+		}
+	}
+
+	return violations
+}
+
+func commandSource(config *Config, name string) string {
+	if source, ok := config.commandSources[name]; ok {
+		return source
+	}
+	return unknownAliasSource
+}
+
+// checkFinal records the final commands of a that merging b over it would
+// change, so that loading the config can fail with the sources of both.
+func (a *Config) checkFinal(b *Config) {
+	for _, name := range sortedCommandNames(b.Commands) {
+		if va, ok := a.Commands[name]; ok {
+			vb := b.Commands[name]
+			a.finalViolations = append(a.finalViolations, finalViolations(name, &va, &vb,
+				commandSource(a, name), commandSource(b, name))...)
+		}
+	}
+
+	a.finalViolations = append(a.finalViolations, b.finalViolations...)
+}
+
+// mergeCommandSources records where the commands of b were defined, except
+// for final commands, which keep the source that marked them final.
+func (a *Config) mergeCommandSources(b *Config) {
+	if a.commandSources == nil {
+		a.commandSources = make(map[string]string)
+	}
+
+	for name, source := range b.commandSources {
+		if command, ok := a.Commands[name]; !ok || !command.Final() {
+			a.commandSources[name] = source
+		}
+	}
+}
+
+// finalCommands finds the commands marked final, including subcommands,
+// which have the source of their top-level command.
+func finalCommands(config *Config) []finalCommand {
+	var finals []finalCommand
+
+	var walk func(name string, command Command, source string)
+
+	walk = func(name string, command Command, source string) {
+		if command.Final() {
+			finals = append(finals, finalCommand{name, source})
+		}
+
+		for _, subName := range sortedCommandNames(command.Commands) {
+			walk(name+" "+subName, command.Commands[subName], source)
+		}
+	}
+
+	for _, name := range sortedCommandNames(config.Commands) {
+		walk(name, config.Commands[name], commandSource(config, name))
+	}
+
+	return finals
+}
+
+func finalProblems(config *Config) []string {
+	var problems []string
+
+	for _, v := range config.finalViolations {
+		problems = append(problems, v.String())
+	}
+
+	return problems
+}
+
+// checkFinalCommands fails if the script of a final command was changed,
+// unless po validate is being run, so that it can report every violation.
+func checkFinalCommands(config *Config, args []string) error {
+	if len(config.finalViolations) == 0 {
+		return nil
+	}
+
+	if invokesCommand(args, validateCmdName) {
+		return nil
+	}
+
+	return fmt.Errorf("%s", config.finalViolations[0])
+}
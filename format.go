@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+	"io"
+	neturl "net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	formatYAML = "YAML"
+	formatJSON = "JSON"
+	formatTOML = "TOML"
+)
+
+// configFileNames are the names po looks for a config under.
+var configFileNames = []string{"po.yml", "po.yaml", ".po.yml", "po.json", "po.toml"}
+
+// configFormat finds the format of a config file or URL from its
+// extension. Anything that isn't JSON or TOML is read as YAML.
+func configFormat(location string) string {
+	if u, err := neturl.Parse(location); err == nil && u.Scheme != "" && u.Opaque == "" {
+		location = u.Path
+	}
+
+	switch strings.ToLower(path.Ext(location)) {
+	case ".json":
+		return formatJSON
+	case ".toml":
+		return formatTOML
+	default:
+		return formatYAML
+	}
+}
+
+// parseConfigAs parses a config in any of the supported formats. JSON and
+// TOML are converted to YAML first, so that they're read exactly as the
+// same config written in YAML would be.
+func parseConfigAs(dat []byte, format string) (*Config, error) {
+	var err error
+
+	switch format {
+	case formatJSON:
+		dat, err = jsonToYAML(dat)
+	case formatTOML:
+		dat, err = tomlToYAML(dat)
+	default:
+		return parseConfig(dat)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := parseConfig(dat)
+
+	// A config for a newer po isn't invalid, so it's reported as it is.
+	var versionErr *configVersionError
+
+	if errors.As(err, &versionErr) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("invalid %s config: %v", format, err)
+	}
+
+	return config, nil
+}
+
+func jsonToYAML(dat []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(dat))
+	decoder.UseNumber()
+	value, err := readJSONValue(decoder)
+
+	if err == nil {
+		if _, err = decoder.Token(); err == io.EOF {
+			return yaml.Marshal(value)
+		} else if err == nil {
+			err = fmt.Errorf("unexpected data after the config")
+		}
+	}
+
+	if syntaxErr, ok := err.(*json.SyntaxError); ok {
+		before := dat[:syntaxErr.Offset]
+		line := bytes.Count(before, []byte("\n")) + 1
+		column := len([]rune(string(before[bytes.LastIndexByte(before, '\n')+1:])))
+		return nil, &sourceError{Format: "json", Line: line, Column: column, Message: err.Error()}
+	}
+
+	return nil, fmt.Errorf("json: %v", err)
+}
+
+// readJSONValue reads a JSON value token by token, keeping the keys of
+// objects in order, as the order of a matrix's axes matters.
+func readJSONValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := token.(type) {
+	case json.Delim:
+		if t == '{' {
+			var object yaml.MapSlice
+
+			for decoder.More() {
+				key, err := decoder.Token()
+
+				if err != nil {
+					return nil, err
+				}
+
+				value, err := readJSONValue(decoder)
+
+				if err != nil {
+					return nil, err
+				}
+
+				object = append(object, yaml.MapItem{Key: key, Value: value})
+			}
+
+			_, err := decoder.Token()
+			return object, err
+		}
+
+		array := []interface{}{}
+
+		for decoder.More() {
+			value, err := readJSONValue(decoder)
+
+			if err != nil {
+				return nil, err
+			}
+
+			array = append(array, value)
+		}
+
+		_, err := decoder.Token()
+		return array, err
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		return t.Float64()
+	default:
+		return t, nil
+	}
+}
+
+func tomlToYAML(dat []byte) ([]byte, error) {
+	var table map[string]interface{}
+	meta, err := toml.Decode(string(dat), &table)
+
+	if err != nil {
+		return nil, err
+	}
+
+	order := make(map[string]int)
+
+	for i, key := range meta.Keys() {
+		order[tomlKey(key[:len(key)-1], key[len(key)-1])] = i
+	}
+
+	return yaml.Marshal(orderTOMLValue(table, nil, order))
+}
+
+func tomlKey(keyPath []string, key string) string {
+	return strings.Join(append(append([]string(nil), keyPath...), key), "\x00")
+}
+
+// orderTOMLValue puts the keys of TOML tables back in the order they were
+// written, as the order of a matrix's axes matters.
+func orderTOMLValue(value interface{}, keyPath []string, order map[string]int) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+
+		for k := range v {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		position := func(k string) int {
+			if i, ok := order[tomlKey(keyPath, k)]; ok {
+				return i
+			}
+			return len(order)
+		}
+
+		sort.SliceStable(keys, func(i, j int) bool {
+			return position(keys[i]) < position(keys[j])
+		})
+
+		table := make(yaml.MapSlice, len(keys))
+
+		for i, k := range keys {
+			childPath := append(append([]string(nil), keyPath...), k)
+			table[i] = yaml.MapItem{Key: k, Value: orderTOMLValue(v[k], childPath, order)}
+		}
+
+		return table
+	case []map[string]interface{}:
+		array := make([]interface{}, len(v))
+
+		for i, item := range v {
+			array[i] = orderTOMLValue(item, keyPath, order)
+		}
+
+		return array
+	case []interface{}:
+		array := make([]interface{}, len(v))
+
+		for i, item := range v {
+			array[i] = orderTOMLValue(item, keyPath, order)
+		}
+
+		return array
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return v
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseConfigAsReportsNewerVersions(t *testing.T) {
+	for format, dat := range map[string]string{
+		formatYAML: "version: 99\ncommands: {}\n",
+		formatJSON: `{"version": "99", "commands": {}}`,
+		formatTOML: "version = \"99\"\n",
+	} {
+		_, err := parseConfigAs([]byte(dat), format)
+
+		if _, ok := err.(*configVersionError); !ok {
+			t.Errorf("%s: expected a version error, got %v", format, err)
+		} else if strings.Contains(err.Error(), "invalid") {
+			t.Errorf("%s: expected a newer config not to be called invalid: %v", format, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"os"
+	"strings"
+)
+
+const (
+	parentFlagEnvPrefix = "PO_PARENT_"
+	forwardFlagsEnvVar  = "PO_FORWARD_FLAGS"
+)
+
+// parentFlagEnvName is the variable that holds the value of a flag of the
+// command that ran po, such as PO_PARENT_DRY_RUN for --dry-run.
+func parentFlagEnvName(name string) string {
+	return parentFlagEnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// parentFlagValue is true for a flag the command declares that has a value
+// worth passing on: one that was given, or has a default other than false.
+// Flags cobra adds, such as --help and the --no-NAME negations, are left
+// out, as are bool flags that are false only because they weren't given.
+func parentFlagValue(flagDefs map[string]Flag, f *pflag.Flag) bool {
+	if _, ok := flagDefs[f.Name]; !ok {
+		return false
+	}
+	return f.Changed || !isFalseBoolFlag(f)
+}
+
+// parentFlagEnvVars pass the flags of a command on to the po commands its
+// script runs. Any inherited from a command further up are removed first,
+// so that each command only sees the flags of the one that ran it, along
+// with the names of the flags it forwards.
+func parentFlagEnvVars(env []string, flagDefs map[string]Flag, flags *pflag.FlagSet, forward []string) []string {
+	kept := env[:0]
+
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, parentFlagEnvPrefix) && !strings.HasPrefix(kv, forwardFlagsEnvVar+"=") {
+			kept = append(kept, kv)
+		}
+	}
+
+	visitFlagsWithValues(flags, func(f *pflag.Flag) {
+		if parentFlagValue(flagDefs, f) {
+			kept = append(kept, parentFlagEnvName(f.Name)+"="+flagValueOrDefault(f))
+		}
+	})
+
+	var forwarded []string
+
+	for _, name := range forward {
+		if f := flags.Lookup(name); f != nil && (f.Changed || f.DefValue != "") && parentFlagValue(flagDefs, f) {
+			forwarded = append(forwarded, name)
+		}
+	}
+
+	if len(forwarded) > 0 {
+		kept = append(kept, forwardFlagsEnvVar+"="+strings.Join(forwarded, ","))
+	}
+
+	return kept
+}
+
+// forwardedFlags formats the flags a command forwards as they'd be given
+// to the commands it runs, for --dry-run.
+func forwardedFlags(flagDefs map[string]Flag, flags *pflag.FlagSet, forward []string) string {
+	var parts []string
+
+	for _, name := range forward {
+		if f := flags.Lookup(name); f != nil && (f.Changed || f.DefValue != "") && parentFlagValue(flagDefs, f) {
+			parts = append(parts, fmt.Sprintf("--%s=%s", name, flagValueOrDefault(f)))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// applyForwardedFlags sets the flags a parent command forwarded, if this
+// command has a flag of the same name that wasn't given on the command
+// line. It returns the flags that were set.
+func applyForwardedFlags(flags *pflag.FlagSet) ([]string, error) {
+	names := os.Getenv(forwardFlagsEnvVar)
+
+	if names == "" {
+		return nil, nil
+	}
+
+	var applied []string
+
+	for _, name := range strings.Split(names, ",") {
+		f := flags.Lookup(name)
+		value, ok := os.LookupEnv(parentFlagEnvName(name))
+
+		if f == nil || f.Changed || !ok {
+			continue
+		}
+
+		if err := f.Value.Set(value); err != nil {
+			return nil, fmt.Errorf("invalid value %q forwarded for flag --%s: %v", value, name, err)
+		}
+
+		f.Changed = true
+		applied = append(applied, fmt.Sprintf("--%s=%s", name, value))
+	}
+
+	return applied, nil
+}
+
+// forwardParentFlags sets the flags forwarded by the command whose script
+// ran this one before its arguments and flags are checked, so that a
+// forwarded value is checked just as a typed one would be.
+func forwardParentFlags(validateArgs cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		applied, err := applyForwardedFlags(cmd.LocalFlags())
+
+		if err != nil {
+			return err
+		}
+
+		if len(applied) > 0 {
+			tracef("forwarded from the parent command: %s", strings.Join(applied, " "))
+		}
+
+		return validateArgs(cmd, args)
+	}
+}
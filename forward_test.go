@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+	"reflect"
+	"testing"
+)
+
+func TestParentFlagEnvVars(t *testing.T) {
+	cmd := &cobra.Command{Use: "release"}
+	cmd.Flags().String("version", "", "")
+	cmd.Flags().Bool("verbose", false, "")
+	cmd.Flags().Bool("color", true, "")
+	cmd.Flags().Bool("signed", false, "")
+	cmd.Flags().String("undeclared", "x", "")
+	cmd.InitDefaultHelpFlag()
+
+	if err := addNegationFlag(cmd, "color"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.Flags().Parse([]string{"--version", "1.2", "--signed=false"}); err != nil {
+		t.Fatal(err)
+	}
+
+	defs := map[string]Flag{"version": {}, "verbose": {}, "color": {}, "signed": {}}
+	env := []string{"HOME=/home/po", "PO_PARENT_OLD=1", forwardFlagsEnvVar + "=old"}
+	forward := []string{"version", "verbose", "help", "undeclared"}
+
+	expected := []string{
+		"HOME=/home/po",
+		"PO_PARENT_COLOR=true",
+		"PO_PARENT_SIGNED=false",
+		"PO_PARENT_VERSION=1.2",
+		forwardFlagsEnvVar + "=version",
+	}
+
+	if env := parentFlagEnvVars(env, defs, cmd.Flags(), forward); !reflect.DeepEqual(env, expected) {
+		t.Errorf("expected %v, got %v", expected, env)
+	}
+
+	if flags := forwardedFlags(defs, cmd.Flags(), forward); flags != "--version=1.2" {
+		t.Errorf("expected only --version to be forwarded, got %q", flags)
+	}
+}
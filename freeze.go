@@ -0,0 +1,339 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultVendorDir     = "po_vendor"
+	vendorManifestName   = "vendor.yml"
+	vendorQueryHashSize  = 8
+	vendorIndexFileName  = "index.yml"
+	vendorFilePermission = 0644
+)
+
+type vendorEntry struct {
+	Url    string
+	Sha256 string
+}
+
+type vendorManifest struct {
+	Files map[string]vendorEntry
+}
+
+func vendorPath(rawurl string) (string, error) {
+	u, err := url.Parse(rawurl)
+
+	if err != nil {
+		return "", err
+	}
+
+	p := path.Clean("/" + u.Path)
+
+	if p == "/" {
+		p = "/" + vendorIndexFileName
+	}
+
+	name := filepath.Join(u.Host, filepath.FromSlash(p[1:]))
+
+	if u.RawQuery != "" {
+		name += "-" + sha256HexString([]byte(u.RawQuery))[:vendorQueryHashSize]
+	}
+
+	return name, nil
+}
+
+func commandImports(commands map[string]Command) []Import {
+	var imports []Import
+
+	for _, cmd := range commands {
+		imports = append(imports, cmd.Imports...)
+		imports = append(imports, commandImports(cmd.Commands)...)
+	}
+
+	return imports
+}
+
+func configImports(config *Config) []Import {
+	imports := append([]Import{}, config.Imports...)
+	imports = append(imports, commandImports(config.Commands)...)
+
+	sort.Slice(imports, func(i, j int) bool {
+		if imports[i].Url != imports[j].Url {
+			return imports[i].Url < imports[j].Url
+		}
+		return imports[i].File < imports[j].File
+	})
+
+	return imports
+}
+
+func replaceUrlImport(text string, rawurl string, file string) string {
+	re := regexp.MustCompile(`(?m)\burl:([ \t]*)["']?` + regexp.QuoteMeta(rawurl) + `["']?([ \t]*(?:$|[,}#]))`)
+	return re.ReplaceAllString(text, "file:${1}"+strings.Replace(file, "$", "$$", -1)+"${2}")
+}
+
+type freezer struct {
+	dir      string
+	manifest vendorManifest
+	vendored map[string]string
+}
+
+func newFreezer(dir string) *freezer {
+	return &freezer{
+		dir:      dir,
+		manifest: vendorManifest{Files: make(map[string]vendorEntry)},
+		vendored: make(map[string]string),
+	}
+}
+
+// rewriteImports vendors the URL imports in a config and points them at
+// the vendored files. A config fetched from baseUrl may also have imports
+// relative to it; these are vendored alongside it in the same layout, so a
+// relative 'file' import still resolves and only 'url' keys are rewritten.
+// Rewriting only works on YAML, so configs in other formats can't have
+// URL imports of their own.
+func (f *freezer) rewriteImports(text string, format string, baseDir string, baseUrl string) (string, error) {
+	config, err := parseConfigAs([]byte(text), format)
+
+	if err != nil {
+		return "", err
+	}
+
+	for _, imp := range configImports(config) {
+		if imp.Url == "" && baseUrl == "" {
+			continue
+		}
+
+		u := imp.Url
+
+		if u == "" {
+			u = filepath.ToSlash(imp.File)
+		}
+
+		if u, err = expandImportPath(u); err != nil {
+			return "", err
+		}
+
+		if baseUrl != "" {
+			if u, err = resolveUrl(baseUrl, u); err != nil {
+				return "", err
+			}
+		}
+
+		vendoredPath, err := f.vendorUrl(u)
+
+		if err != nil {
+			return "", err
+		}
+
+		if imp.Url == "" {
+			continue
+		}
+
+		if format != formatYAML {
+			return "", fmt.Errorf("cannot rewrite URL imports in a %s config", format)
+		}
+
+		rel, err := filepath.Rel(baseDir, vendoredPath)
+
+		if err != nil {
+			return "", err
+		}
+
+		text = replaceUrlImport(text, imp.Url, filepath.ToSlash(rel))
+	}
+
+	return text, nil
+}
+
+func (f *freezer) vendorUrl(rawurl string) (string, error) {
+	if p, ok := f.vendored[rawurl]; ok {
+		return p, nil
+	}
+
+	rel, err := vendorPath(rawurl)
+
+	if err != nil {
+		return "", err
+	}
+
+	abs := filepath.Join(f.dir, rel)
+	f.vendored[rawurl] = abs
+
+	dat, err := fetchUrlWithRetries(httpClient, rawurl)
+
+	if err != nil {
+		return "", err
+	}
+
+	f.manifest.Files[filepath.ToSlash(rel)] = vendorEntry{
+		Url:    rawurl,
+		Sha256: sha256HexString(dat),
+	}
+
+	text, err := f.rewriteImports(string(dat), configFormat(rawurl), filepath.Dir(abs), rawurl)
+
+	if err != nil {
+		return "", fmt.Errorf("cannot vendor %s: %v", rawurl, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(abs), 0755); err != nil {
+		return "", err
+	}
+
+	return abs, os.WriteFile(abs, []byte(text), vendorFilePermission)
+}
+
+func (f *freezer) writeManifest() error {
+	dat, err := yaml.Marshal(&f.manifest)
+
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(f.dir, vendorManifestName)
+	return os.WriteFile(path, dat, vendorFilePermission)
+}
+
+func freezeImports(out io.Writer, configPath string, dir string) error {
+	dat, err := os.ReadFile(configPath)
+
+	if err != nil {
+		return err
+	}
+
+	f := newFreezer(dir)
+	text, err := f.rewriteImports(string(dat), configFormat(configPath), filepath.Dir(configPath), "")
+
+	if err != nil {
+		return err
+	}
+
+	if len(f.vendored) == 0 {
+		fmt.Fprintln(out, "No URL imports to vendor")
+		return nil
+	}
+
+	if err := f.writeManifest(); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(configPath, []byte(text), vendorFilePermission); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Vendored %d imports into %s\n", len(f.vendored), dir)
+	return nil
+}
+
+func readVendorManifest(dir string) (*vendorManifest, error) {
+	dat, err := os.ReadFile(filepath.Join(dir, vendorManifestName))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest vendorManifest
+
+	if err := yaml.Unmarshal(dat, &manifest); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+func checkVendoredImports(out io.Writer, dir string) error {
+	manifest, err := readVendorManifest(dir)
+
+	if err != nil {
+		return err
+	}
+
+	files := make([]string, 0, len(manifest.Files))
+
+	for file := range manifest.Files {
+		files = append(files, file)
+	}
+
+	sort.Strings(files)
+	drifted := 0
+
+	for _, file := range files {
+		entry := manifest.Files[file]
+		dat, err := fetchUrlWithRetries(httpClient, entry.Url)
+
+		if err != nil {
+			return err
+		}
+
+		if sha256HexString(dat) != entry.Sha256 {
+			fmt.Fprintf(out, "changed  %s (%s)\n", entry.Url, file)
+			drifted++
+		} else {
+			fmt.Fprintf(out, "ok       %s (%s)\n", entry.Url, file)
+		}
+	}
+
+	if drifted > 0 {
+		return fmt.Errorf("%d vendored imports differ from their URLs", drifted)
+	}
+
+	return nil
+}
+
+func makeFreezeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "freeze [DIR]",
+		Short: "Vendor URL imports into the project",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := defaultVendorDir
+
+			if len(args) > 0 {
+				dir = args[0]
+			}
+
+			dir, err := filepath.Abs(dir)
+
+			if err != nil {
+				return err
+			}
+
+			check, err := cmd.Flags().GetBool("check")
+
+			if err != nil {
+				return err
+			}
+
+			if check {
+				return checkVendoredImports(cmd.OutOrStdout(), dir)
+			}
+
+			configPath, err := findProjectConfig()
+
+			if err != nil {
+				return err
+			}
+
+			if configPath == "" {
+				return fmt.Errorf("no project %s file found", configFileName)
+			}
+
+			return freezeImports(cmd.OutOrStdout(), configPath, dir)
+		},
+	}
+
+	cmd.Flags().Bool("check", false, "check vendored imports against their URLs")
+	return cmd
+}
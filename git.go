@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const (
+	poProjectRootEnvVar = "PO_PROJECT_ROOT"
+	poGitRootEnvVar     = "PO_GIT_ROOT"
+	poGitBranchEnvVar   = "PO_GIT_BRANCH"
+	poGitShaEnvVar      = "PO_GIT_SHA"
+)
+
+// gitInfo is the git checkout a command is run in. Branch is empty when
+// HEAD is detached, and Sha is empty before the first commit.
+type gitInfo struct {
+	Root   string
+	Branch string
+	Sha    string
+}
+
+func runGit(args ...string) ([]string, error) {
+	out, err := exec.Command("git", args...).Output()
+	return strings.Split(strings.TrimSpace(string(out)), "\n"), err
+}
+
+// readGitInfo asks git about the current directory, as git knows how to
+// find the checkout of a worktree or a submodule. Outside a checkout, or
+// without git installed, it returns nothing.
+func readGitInfo() gitInfo {
+	if _, err := exec.LookPath("git"); err != nil {
+		return gitInfo{}
+	}
+
+	lines, err := runGit("rev-parse", "--show-toplevel", "HEAD", "--symbolic-full-name", "HEAD")
+
+	if err == nil && len(lines) == 3 {
+		info := gitInfo{Root: lines[0], Sha: lines[1]}
+
+		// With a detached HEAD, git gives HEAD itself as its name.
+		if strings.HasPrefix(lines[2], "refs/heads/") {
+			info.Branch = strings.TrimPrefix(lines[2], "refs/heads/")
+		}
+
+		return info
+	}
+
+	// HEAD can't be resolved before the first commit, but the branch
+	// it's on is still known.
+	lines, err = runGit("rev-parse", "--show-toplevel")
+
+	if err != nil || lines[0] == "" {
+		return gitInfo{}
+	}
+
+	info := gitInfo{Root: lines[0]}
+
+	if branch, err := runGit("symbolic-ref", "--short", "-q", "HEAD"); err == nil {
+		info.Branch = branch[0]
+	}
+
+	return info
+}
+
+// projectEnvVars are the variables that tell a script where its project
+// is, which save scripts working it out for themselves. The git variables
+// need git to be run, so git_env: false leaves them out.
+func projectEnvVars(withGit bool) []string {
+	vars := []string{poProjectRootEnvVar + "=" + os.Getenv(poPathEnvVar)}
+
+	if !withGit {
+		return vars
+	}
+
+	defer startPhase("read git metadata")()
+	info := readGitInfo()
+
+	return append(vars,
+		poGitRootEnvVar+"="+info.Root,
+		poGitBranchEnvVar+"="+info.Branch,
+		poGitShaEnvVar+"="+info.Sha)
+}
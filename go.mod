@@ -0,0 +1,19 @@
+module github.com/weavejester/po
+
+go 1.26.0
+
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/fatih/color v1.13.0
+	github.com/mattn/go-isatty v0.0.14
+	github.com/spf13/cobra v1.8.1
+	github.com/spf13/pflag v1.0.6
+	golang.org/x/sys v0.47.0
+	golang.org/x/text v0.42.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/mattn/go-colorable v0.1.9 // indirect
+)
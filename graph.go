@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	graphCmdName = "graph"
+
+	graphFormatTree    = "tree"
+	graphFormatDot     = "dot"
+	graphFormatMermaid = "mermaid"
+)
+
+var graphFormats = []string{graphFormatTree, graphFormatDot, graphFormatMermaid}
+
+// graphNode is a command in the graph of how commands relate: the
+// subcommands it's made of, and the command it extends.
+type graphNode struct {
+	Name     string
+	Parent   string
+	Children []string
+	Extends  string
+	Default  string
+	Abstract bool
+	Source   string
+}
+
+// graph holds the commands of a config by their full names, in the order
+// they were declared, with each command before its subcommands.
+type graph struct {
+	names []string
+	nodes map[string]*graphNode
+}
+
+// markImported records that the commands of a config were loaded from an
+// import, so that they can be told apart from the project's own.
+func (config *Config) markImported() {
+	if config.importedSources == nil {
+		config.importedSources = make(map[string]bool)
+	}
+
+	for _, source := range config.commandSources {
+		config.importedSources[source] = true
+	}
+}
+
+func (a *Config) mergeImportedSources(b *Config) {
+	if len(b.importedSources) > 0 && a.importedSources == nil {
+		a.importedSources = make(map[string]bool)
+	}
+
+	for source := range b.importedSources {
+		a.importedSources[source] = true
+	}
+}
+
+// importSource is the import a command was loaded from, or "" if it's
+// from one of the configs po found itself. Subcommands are from the same
+// place as their top-level command.
+func (config *Config) importSource(name string) string {
+	source := config.commandSources[strings.SplitN(name, ":", 2)[0]]
+
+	if config.importedSources[source] {
+		return source
+	}
+
+	return ""
+}
+
+func newGraph(config *Config) *graph {
+	g := &graph{nodes: make(map[string]*graphNode)}
+	g.addCommands(config, "", config.Commands, config.CommandNames())
+	return g
+}
+
+func (g *graph) addCommands(config *Config, parent string, commands map[string]Command, names []string) {
+	for _, name := range names {
+		command := commands[name]
+		fullName := name
+
+		if parent != "" {
+			fullName = parent + ":" + name
+		}
+
+		node := &graphNode{
+			Name:     fullName,
+			Parent:   parent,
+			Extends:  command.Extends,
+			Default:  command.DefaultSubcommand,
+			Abstract: command.Abstract(),
+			Source:   config.importSource(fullName),
+		}
+
+		subNames := command.CommandNames()
+
+		for _, subName := range subNames {
+			node.Children = append(node.Children, fullName+":"+subName)
+		}
+
+		g.names = append(g.names, fullName)
+		g.nodes[fullName] = node
+		g.addCommands(config, fullName, command.Commands, subNames)
+	}
+}
+
+// focus limits the graph to a command and the commands it depends on:
+// its subcommands, the commands it extends, and theirs in turn.
+func (g *graph) focus(name string) (*graph, error) {
+	name = strings.Replace(name, " ", ":", -1)
+
+	if _, ok := g.nodes[name]; !ok {
+		return nil, fmt.Errorf("no such command: %s", spacedName(name))
+	}
+
+	keep := make(map[string]bool)
+	queue := []string{name}
+
+	for len(queue) > 0 {
+		node, ok := g.nodes[queue[0]]
+		queue = queue[1:]
+
+		if !ok || keep[node.Name] {
+			continue
+		}
+
+		keep[node.Name] = true
+		queue = append(queue, node.Children...)
+
+		if node.Extends != "" {
+			queue = append(queue, node.Extends)
+		}
+	}
+
+	focused := &graph{nodes: make(map[string]*graphNode)}
+
+	for _, n := range g.names {
+		if keep[n] {
+			focused.names = append(focused.names, n)
+			focused.nodes[n] = g.nodes[n]
+		}
+	}
+
+	return focused, nil
+}
+
+func (node *graphNode) isDefault(g *graph) bool {
+	parent, ok := g.nodes[node.Parent]
+	return ok && parent.Default != "" && parent.Name+":"+parent.Default == node.Name
+}
+
+// notes are what the tree format shows in brackets after a command.
+func (node *graphNode) notes(g *graph) []string {
+	var notes []string
+
+	if node.isDefault(g) {
+		notes = append(notes, "default")
+	}
+
+	if node.Abstract {
+		notes = append(notes, "abstract")
+	}
+
+	if node.Extends != "" {
+		notes = append(notes, "extends "+spacedName(node.Extends))
+	}
+
+	if node.Source != "" {
+		notes = append(notes, "imported from "+node.Source)
+	}
+
+	return notes
+}
+
+// writeTree prints each command with its subcommands indented beneath it.
+// A command whose parent isn't in the graph is shown by its full name.
+func writeTree(out io.Writer, g *graph) {
+	var write func(name string, label string, indent string, branch string)
+
+	write = func(name string, label string, indent string, branch string) {
+		node := g.nodes[name]
+		line := indent + branch + label
+
+		if notes := node.notes(g); len(notes) > 0 {
+			line += " (" + strings.Join(notes, ", ") + ")"
+		}
+
+		fmt.Fprintln(out, line)
+
+		switch branch {
+		case "├── ":
+			indent += "│   "
+		case "└── ":
+			indent += "    "
+		}
+
+		var children []string
+
+		for _, child := range node.Children {
+			if _, ok := g.nodes[child]; ok {
+				children = append(children, child)
+			}
+		}
+
+		for i, child := range children {
+			childBranch := "├── "
+
+			if i == len(children)-1 {
+				childBranch = "└── "
+			}
+
+			write(child, child[len(name)+1:], indent, childBranch)
+		}
+	}
+
+	for _, name := range g.names {
+		if _, ok := g.nodes[g.nodes[name].Parent]; !ok {
+			write(name, spacedName(name), "", "")
+		}
+	}
+}
+
+// writeDot prints the graph in Graphviz's DOT language. Imported commands
+// have dashed outlines, and abstract ones are grey.
+func writeDot(out io.Writer, g *graph) {
+	fmt.Fprintln(out, "digraph po {")
+	fmt.Fprintln(out, "  rankdir=LR;")
+	fmt.Fprintln(out, "  node [shape=box];")
+
+	for _, name := range g.names {
+		node := g.nodes[name]
+		var attrs []string
+
+		if node.Source != "" {
+			attrs = append(attrs, "style=dashed", "tooltip="+strconv.Quote("imported from "+node.Source))
+		}
+
+		if node.Abstract {
+			attrs = append(attrs, "fontcolor=gray")
+		}
+
+		fmt.Fprintf(out, "  %s", strconv.Quote(spacedName(name)))
+
+		if len(attrs) > 0 {
+			fmt.Fprintf(out, " [%s]", strings.Join(attrs, ", "))
+		}
+
+		fmt.Fprintln(out, ";")
+	}
+
+	g.visitEdges(func(from string, to string, label string) {
+		fmt.Fprintf(out, "  %s -> %s [label=%s];\n",
+			strconv.Quote(spacedName(from)), strconv.Quote(spacedName(to)), strconv.Quote(label))
+	})
+
+	fmt.Fprintln(out, "}")
+}
+
+// writeMermaid prints the graph as a Mermaid flowchart, for Markdown that
+// renders them.
+func writeMermaid(out io.Writer, g *graph) {
+	ids := make(map[string]string, len(g.names))
+	var imported []string
+
+	fmt.Fprintln(out, "flowchart LR")
+
+	for i, name := range g.names {
+		ids[name] = "n" + strconv.Itoa(i)
+		label := strings.Replace(spacedName(name), `"`, "#quot;", -1)
+		fmt.Fprintf(out, "  %s[\"%s\"]\n", ids[name], label)
+
+		if g.nodes[name].Source != "" {
+			imported = append(imported, ids[name])
+		}
+	}
+
+	g.visitEdges(func(from string, to string, label string) {
+		fmt.Fprintf(out, "  %s -->|%s| %s\n", ids[from], label, ids[to])
+	})
+
+	if len(imported) > 0 {
+		fmt.Fprintln(out, "  classDef imported stroke-dasharray: 5 5")
+		fmt.Fprintf(out, "  class %s imported\n", strings.Join(imported, ","))
+	}
+}
+
+// visitEdges calls f for each edge between two commands in the graph.
+func (g *graph) visitEdges(f func(from string, to string, label string)) {
+	for _, name := range g.names {
+		node := g.nodes[name]
+
+		for _, child := range node.Children {
+			if _, ok := g.nodes[child]; !ok {
+				continue
+			}
+
+			if g.nodes[child].isDefault(g) {
+				f(name, child, "default")
+			} else {
+				f(name, child, "subcommand")
+			}
+		}
+
+		if _, ok := g.nodes[node.Extends]; ok {
+			f(name, node.Extends, "extends")
+		}
+	}
+}
+
+func makeGraphCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   graphCmdName,
+		Short: "Print how the commands relate to each other",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			format, err := cmd.Flags().GetString("format")
+
+			if err != nil {
+				return err
+			}
+
+			focus, err := cmd.Flags().GetString("focus")
+
+			if err != nil {
+				return err
+			}
+
+			g := newGraph(config)
+
+			if focus != "" {
+				if g, err = g.focus(focus); err != nil {
+					return err
+				}
+			}
+
+			switch format {
+			case graphFormatTree:
+				writeTree(cmd.OutOrStdout(), g)
+			case graphFormatDot:
+				writeDot(cmd.OutOrStdout(), g)
+			case graphFormatMermaid:
+				writeMermaid(cmd.OutOrStdout(), g)
+			default:
+				return usageError{fmt.Errorf("invalid format '%s' (must be one of: %s)",
+					format, strings.Join(graphFormats, ", "))}
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("format", graphFormatTree,
+		fmt.Sprintf("output format (%s)", strings.Join(graphFormats, ", ")))
+	cmd.Flags().String("focus", "", "only show a command and the commands it depends on")
+	return cmd
+}
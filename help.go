@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"strings"
+)
+
+const helpAllFlag = "all"
+
+// findHelpCommand finds the command that help was asked for. The first
+// name can be an alias, and names can be given in their colon form, such
+// as db:migrate, or separately, as in db migrate.
+func findHelpCommand(root *cobra.Command, config *Config, args []string) (*cobra.Command, error) {
+	name := args[0]
+
+	if _, ok := config.Commands[name]; !ok {
+		if target, ok := config.Aliases[name]; ok {
+			name = target
+		}
+	}
+
+	path := append(strings.Split(name, ":"), args[1:]...)
+	cmd := root
+
+	for _, name := range path {
+		subCmd := findSubCommand(cmd, name)
+
+		if subCmd == nil {
+			return nil, unknownHelpCommandError(cmd, name)
+		}
+
+		cmd = subCmd
+	}
+
+	return cmd, nil
+}
+
+func findSubCommand(cmd *cobra.Command, name string) *cobra.Command {
+	for _, subCmd := range cmd.Commands() {
+		if subCmd.Name() == name || subCmd.HasAlias(name) {
+			return subCmd
+		}
+	}
+	return nil
+}
+
+func unknownHelpCommandError(parent *cobra.Command, name string) error {
+	msg := fmt.Sprintf("unknown command %q for %q", name, parent.CommandPath())
+
+	if parent.SuggestionsMinimumDistance <= 0 {
+		parent.SuggestionsMinimumDistance = 2
+	}
+
+	if suggestions := parent.SuggestionsFor(name); len(suggestions) > 0 {
+		msg += "\n\nDid you mean this?\n\t" + strings.Join(suggestions, "\n\t")
+	}
+
+	return usageError{fmt.Errorf("%s", msg)}
+}
+
+// writeAllHelp prints the help for every command from the config that
+// would be listed, each under its full name, such as for generating
+// documentation.
+func writeAllHelp(cmd *cobra.Command, config *Config) {
+	bold := color.New(color.Bold, color.Underline)
+
+	for _, subCmd := range listedCommands(cmd) {
+		if !subCmd.IsAvailableCommand() {
+			continue
+		}
+
+		if _, ok := config.Commands[subCmd.Name()]; !ok && !cmd.HasParent() {
+			continue
+		}
+
+		out := subCmd.OutOrStderr()
+		bold.Fprintf(out, "%s\n\n", subCmd.CommandPath())
+		subCmd.Help()
+		fmt.Fprintln(out)
+
+		writeAllHelp(subCmd, config)
+	}
+}
+
+const helpCmdName = "help"
+
+func makeHelpCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   helpCmdName + " [COMMAND]",
+		Short: "Help about any command",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			root := cmd.Root()
+
+			if all, _ := cmd.Flags().GetBool(helpAllFlag); all {
+				writeAllHelp(root, config)
+				return nil
+			}
+
+			if len(args) == 0 {
+				return root.Help()
+			}
+
+			helpCmd, err := findHelpCommand(root, config, args)
+
+			if err != nil {
+				return err
+			}
+
+			return helpCmd.Help()
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			parent := cmd.Root()
+
+			for _, name := range args {
+				if parent = findSubCommand(parent, name); parent == nil {
+					return nil, cobra.ShellCompDirectiveNoFileComp
+				}
+			}
+
+			var names []string
+
+			for _, subCmd := range listedCommands(parent) {
+				if subCmd.IsAvailableCommand() && strings.HasPrefix(subCmd.Name(), toComplete) {
+					names = append(names, completionWithDesc(subCmd.Name(), subCmd.Short))
+				}
+			}
+
+			return names, cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+
+	cmd.Flags().Bool(helpAllFlag, false, "print the help for every command")
+	return cmd
+}
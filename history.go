@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	historyFileName     = "history.jsonl"
+	defaultHistoryLimit = 20
+)
+
+type historyEntry struct {
+	Time       time.Time         `json:"time"`
+	Dir        string            `json:"dir"`
+	Command    string            `json:"command"`
+	Args       []string          `json:"args"`
+	Flags      map[string]string `json:"flags,omitempty"`
+	Argv       []string          `json:"argv"`
+	ExitCode   *int              `json:"exit_code,omitempty"`
+	DurationMs int64             `json:"duration_ms,omitempty"`
+
+	// path is the history file the entry is recorded in. It's found when
+	// the entry is made, before the command changes to its work_dir.
+	path string
+}
+
+func userStateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return dir
+	} else if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".local", "state")
+	} else {
+		return filepath.Join(fallbackDir(), "state")
+	}
+}
+
+// historyPath is the absolute path of the history file, so that it stays
+// the same file whichever directory po is in when it's written.
+func historyPath() string {
+	path := filepath.Join(userStateDir(), "po", historyFileName)
+
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+
+	return path
+}
+
+func newHistoryEntry(cmd *cobra.Command, args []string) *historyEntry {
+	dir, _ := os.Getwd()
+	flags := make(map[string]string)
+
+	cmd.LocalFlags().Visit(func(flag *pflag.Flag) {
+		flags[flag.Name] = flag.Value.String()
+	})
+
+	return &historyEntry{
+		Time:    time.Now(),
+		Dir:     dir,
+		Command: cmd.Root().Name() + " " + commandName(cmd),
+		Args:    args,
+		Flags:   flags,
+		Argv:    os.Args[1:],
+		path:    historyPath(),
+	}
+}
+
+// Record appends the entry to the history file. History is a convenience,
+// so any error is ignored rather than getting in the way of the command.
+func (entry *historyEntry) Record(exitCode *int) {
+	if entry == nil {
+		return
+	}
+
+	if exitCode != nil {
+		entry.ExitCode = exitCode
+		entry.DurationMs = time.Since(entry.Time).Milliseconds()
+	}
+
+	dat, err := json.Marshal(entry)
+
+	if err != nil {
+		return
+	}
+
+	path := entry.path
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+
+	if err != nil {
+		return
+	}
+
+	defer file.Close()
+	file.Write(append(dat, '\n'))
+}
+
+func readHistory() ([]historyEntry, error) {
+	file, err := os.Open(historyPath())
+
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var entry historyEntry
+
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+func filterHistory(entries []historyEntry, command string, dir string, limit int) []historyEntry {
+	var filtered []historyEntry
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+
+		if command != "" && entry.Command != command && entry.Command != "po "+command {
+			continue
+		}
+
+		if dir != "" && entry.Dir != dir {
+			continue
+		}
+
+		filtered = append(filtered, entry)
+
+		if limit > 0 && len(filtered) == limit {
+			break
+		}
+	}
+
+	for i, j := 0, len(filtered)-1; i < j; i, j = i+1, j-1 {
+		filtered[i], filtered[j] = filtered[j], filtered[i]
+	}
+
+	return filtered
+}
+
+func formatHistoryStatus(entry historyEntry) string {
+	if entry.ExitCode == nil {
+		return ""
+	}
+
+	duration := time.Duration(entry.DurationMs) * time.Millisecond
+	return fmt.Sprintf("  (exit %d, %v)", *entry.ExitCode, duration.Round(time.Millisecond))
+}
+
+func printHistory(out io.Writer, entries []historyEntry) {
+	for _, entry := range entries {
+		fmt.Fprintf(out, "%s  %s  po %s%s\n",
+			entry.Time.Local().Format("2006-01-02 15:04:05"),
+			entry.Dir,
+			escapeControl(strings.Join(entry.Argv, " ")),
+			formatHistoryStatus(entry))
+	}
+}
+
+func makeHistoryCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "List recently run commands",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			command, err := cmd.Flags().GetString("command")
+
+			if err != nil {
+				return err
+			}
+
+			dir, err := cmd.Flags().GetString("dir")
+
+			if err != nil {
+				return err
+			}
+
+			if dir != "" {
+				if dir, err = filepath.Abs(dir); err != nil {
+					return err
+				}
+			}
+
+			limit, err := cmd.Flags().GetInt("limit")
+
+			if err != nil {
+				return err
+			}
+
+			entries, err := readHistory()
+
+			if err != nil {
+				return err
+			}
+
+			printHistory(cmd.OutOrStdout(), filterHistory(entries, command, dir, limit))
+			return nil
+		},
+	}
+
+	cmd.Flags().String("command", "", "only list runs of this command")
+	cmd.Flags().String("dir", "", "only list runs in this project directory")
+	cmd.Flags().IntP("limit", "n", defaultHistoryLimit, "maximum number of runs to list")
+	return cmd
+}
+
+func makeRerunCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:     "rerun",
+		Aliases: []string{"!!"},
+		Short:   "Run the last command in this project again",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir, err := os.Getwd()
+
+			if err != nil {
+				return err
+			}
+
+			entries, err := readHistory()
+
+			if err != nil {
+				return err
+			}
+
+			last := filterHistory(entries, "", dir, 1)
+
+			if len(last) == 0 {
+				return fmt.Errorf("no commands have been run in %s", dir)
+			}
+
+			executable, err := os.Executable()
+
+			if err != nil {
+				return err
+			}
+
+			argv := last[0].Argv
+			fmt.Fprintf(cmd.ErrOrStderr(), "po %s\n", escapeControl(strings.Join(argv, " ")))
+
+			return unix.Exec(executable, append([]string{os.Args[0]}, argv...), os.Environ())
+		},
+	}
+}
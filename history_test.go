@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHistoryIsRecordedWhereItWasFound(t *testing.T) {
+	dir := t.TempDir()
+	workDir := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", "state")
+	t.Chdir(dir)
+
+	root := &cobra.Command{Use: "po"}
+	cmd := &cobra.Command{Use: "build"}
+	root.AddCommand(cmd)
+
+	entry := newHistoryEntry(cmd, nil)
+	t.Chdir(workDir)
+	entry.Record(nil)
+
+	if _, err := os.Stat(filepath.Join(dir, "state", "po", historyFileName)); err != nil {
+		t.Errorf("expected the history to be recorded before the work_dir was changed to: %v", err)
+	}
+}
+
+func TestHistoryWithoutHome(t *testing.T) {
+	fallback := clearHome(t)
+
+	if path := historyPath(); path != filepath.Join(fallback, "state", "po", historyFileName) {
+		t.Errorf("expected the history in %s, got %s", fallback, path)
+	}
+}
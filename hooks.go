@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// globalHooks are the before_each and after_each scripts of a config,
+// which run around every command that doesn't skip them. They run with
+// the config's shell, on this host, even for a command that runs in a
+// container or on a remote host.
+type globalHooks struct {
+	Exec    string
+	Options []string
+	Before  string
+	After   string
+}
+
+func newGlobalHooks(config *Config, command *Command) *globalHooks {
+	if command.SkipGlobalHooks() || (config.BeforeEach == "" && config.AfterEach == "") {
+		return nil
+	}
+
+	hooks := &globalHooks{Exec: config.Shell, Options: config.ShellOptions}
+
+	if config.BeforeEach != "" {
+		hooks.Before = composeScript(config.Prelude, config.BeforeEach)
+	}
+
+	if config.AfterEach != "" {
+		hooks.After = composeScript(config.Prelude, config.AfterEach)
+	}
+
+	return hooks
+}
+
+func (hooks *globalHooks) hasAfter() bool {
+	return hooks != nil && hooks.After != ""
+}
+
+func (hooks *globalHooks) run(name string, hook string, env []string, script string, opts runOptions) (int, error) {
+	local := runOptions{NoScriptCache: opts.NoScriptCache}
+	p, err := prepareScript(name+":"+hook, hooks.Exec, hooks.Options, env, script, local)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer p.cleanup()
+
+	tracef("running %s %s", hook, strings.Join(p.Args, " "))
+	return p.run()
+}
+
+// runBefore runs the before_each script of the config before a command.
+// If it fails, the command isn't run.
+func (hooks *globalHooks) runBefore(name string, env []string, opts runOptions) error {
+	if hooks == nil || hooks.Before == "" {
+		return nil
+	}
+
+	code, err := hooks.run(name, "before_each", env, hooks.Before, opts)
+
+	if err != nil {
+		return fmt.Errorf("cannot run before_each for %s: %v", name, err)
+	} else if code != 0 {
+		return fmt.Errorf("before_each failed (exit %d), so %s was not run", code, name)
+	}
+
+	return nil
+}
+
+// runAfter runs the after_each script of the config after a command,
+// however that ended. A failing after_each script is reported, but po
+// still exits with the command's exit code.
+func (hooks *globalHooks) runAfter(name string, env []string, code int, signal syscall.Signal, opts runOptions) {
+	env = cloneEnv(env)
+	env = append(env, exitEnvVars(code, signal)...)
+
+	if afterCode, err := hooks.run(name, "after_each", env, hooks.After, opts); err != nil {
+		printWarning("cannot run after_each for %s: %v", name, err)
+	} else if afterCode != 0 {
+		printWarning("after_each for %s failed (exit %d)", name, afterCode)
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const defaultLintSeverity = "style"
+
+var lintSeverities = []string{"error", "warning", "info", "style"}
+
+type shellcheckFinding struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Level   string `json:"level"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// scriptSegment is a part of a composed script, such as the prelude or the
+// command's own script, along with the line of the script it starts on.
+type scriptSegment struct {
+	Text      string
+	StartLine int
+}
+
+func lineCount(text string) int {
+	return strings.Count(strings.TrimRight(text, "\n"), "\n") + 1
+}
+
+func shellDialect(interpreter string) string {
+	fields := strings.Fields(interpreter)
+	name := filepath.Base(fields[0])
+
+	if name == "env" && len(fields) > 1 {
+		name = filepath.Base(fields[1])
+	}
+
+	switch name {
+	case "sh", "bash", "dash", "ksh":
+		return name
+	default:
+		return ""
+	}
+}
+
+type sourceIndex struct {
+	files []string
+	lines map[string][]string
+}
+
+func readSourceIndex() *sourceIndex {
+	index := &sourceIndex{lines: make(map[string][]string)}
+
+	for _, file := range sourceFiles {
+		if _, ok := index.lines[file]; ok {
+			continue
+		}
+
+		if dat, err := os.ReadFile(file); err == nil {
+			index.files = append(index.files, file)
+			index.lines[file] = strings.Split(string(dat), "\n")
+		}
+	}
+
+	return index
+}
+
+func matchesFrom(src []string, start int, lines []string) bool {
+	for j := 1; j < len(lines); j++ {
+		if start+j >= len(src) || strings.TrimSpace(src[start+j]) != strings.TrimSpace(lines[j]) {
+			return false
+		}
+	}
+	return true
+}
+
+// locate finds where some script text came from. YAML doesn't keep track
+// of where a string was read from, so instead this looks for a run of
+// lines in the loaded files that match the text.
+func (index *sourceIndex) locate(text string) (string, int, bool) {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	first := strings.TrimSpace(lines[0])
+
+	if first == "" {
+		return "", 0, false
+	}
+
+	for _, file := range index.files {
+		src := index.lines[file]
+
+		for i := range src {
+			if strings.HasSuffix(strings.TrimSpace(src[i]), first) && matchesFrom(src, i, lines) {
+				return file, i + 1, true
+			}
+		}
+	}
+
+	return "", 0, false
+}
+
+// columnOffset is how far the text of a script line is shifted to the
+// right in the file it came from, by indentation or a 'script:' key.
+func columnOffset(srcLine string, scriptLine string) int {
+	trimmed := strings.TrimSpace(scriptLine)
+
+	if trimmed == "" {
+		return 0
+	}
+
+	return strings.LastIndex(srcLine, trimmed) - strings.Index(scriptLine, trimmed)
+}
+
+func (index *sourceIndex) describe(segments []scriptSegment, finding shellcheckFinding) string {
+	for i := len(segments) - 1; i >= 0; i-- {
+		seg := segments[i]
+
+		if finding.Line < seg.StartLine {
+			continue
+		}
+
+		offset := finding.Line - seg.StartLine
+		file, line, ok := index.locate(seg.Text)
+
+		if !ok {
+			break
+		}
+
+		srcLine := index.lines[file][line-1+offset]
+		scriptLine := strings.Split(seg.Text, "\n")[offset]
+		column := finding.Column + columnOffset(srcLine, scriptLine)
+
+		if cwd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(cwd, file); err == nil && !strings.HasPrefix(rel, "..") {
+				file = rel
+			}
+		}
+
+		return fmt.Sprintf("%s:%d:%d", file, line+offset, column)
+	}
+
+	return fmt.Sprintf("line %d:%d", finding.Line, finding.Column)
+}
+
+func runShellcheck(script string, dialect string, severity string) ([]shellcheckFinding, error) {
+	file, err := os.CreateTemp("", "po-lint-")
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer os.Remove(file.Name())
+
+	if _, err := file.WriteString(script); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	file.Close()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("shellcheck", "-f", "json", "-s", dialect, "-S", severity, file.Name())
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// shellcheck exits with 1 when it has findings, and higher on errors.
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+			return nil, fmt.Errorf("shellcheck failed: %s", strings.TrimSpace(stderr.String()))
+		}
+	}
+
+	var findings []shellcheckFinding
+
+	if err := json.Unmarshal(stdout.Bytes(), &findings); err != nil {
+		return nil, fmt.Errorf("cannot read shellcheck output: %v", err)
+	}
+
+	return findings, nil
+}
+
+func commandNames(commands map[string]Command, prefix string) []string {
+	var names []string
+
+	for name, cmd := range commands {
+		names = append(names, prefix+name)
+		names = append(names, commandNames(cmd.Commands, prefix+name+":")...)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+type linter struct {
+	config   *Config
+	index    *sourceIndex
+	severity string
+	out      io.Writer
+	findings int
+	reported map[string]bool
+}
+
+func (l *linter) lintCommand(name string) error {
+	command, _, err := findCommand(l.config, name)
+
+	if err != nil {
+		return err
+	}
+
+	if !command.HasScript() {
+		return nil
+	}
+
+	if command.IsTemplate() {
+		fmt.Fprintf(l.out, "%s: skipped (template)\n", name)
+		return nil
+	}
+
+	interpreter := command.Exec
+	prelude := ""
+
+	if interpreter == "" {
+		interpreter = l.config.Shell
+
+		if command.IncludePrelude() {
+			prelude = l.config.Prelude
+		}
+	}
+
+	interpreter = interpreterOrDefault(interpreter)
+	dialect := shellDialect(interpreter)
+
+	if dialect == "" {
+		fmt.Fprintf(l.out, "%s: skipped (runs with %s)\n", name, interpreter)
+		return nil
+	}
+
+	script := command.PlatformScript(runtime.GOOS)
+
+	if script == "" && command.ScriptUrl != "" {
+		if script, err = readScriptUrl(command.ScriptUrl, command.Sha256); err != nil {
+			return err
+		}
+	}
+
+	segments := []scriptSegment{}
+	line := 2
+
+	if prelude != "" {
+		segments = append(segments, scriptSegment{Text: prelude, StartLine: line})
+		line += lineCount(prelude)
+	}
+
+	segments = append(segments, scriptSegment{Text: script, StartLine: line})
+
+	findings, err := runShellcheck(buildScript(interpreter, composeScript(prelude, script)), dialect, l.severity)
+
+	if err != nil {
+		return fmt.Errorf("cannot lint %s: %v", name, err)
+	}
+
+	// The prelude is shared between commands, so its problems would be
+	// reported for each of them unless duplicates are left out.
+	var lines []string
+
+	for _, f := range findings {
+		location := l.index.describe(segments, f)
+		key := fmt.Sprintf("%s SC%d", location, f.Code)
+
+		if !strings.HasPrefix(location, "line ") && l.reported[key] {
+			continue
+		}
+
+		l.reported[key] = true
+		lines = append(lines, fmt.Sprintf("  %s: %s SC%d: %s", location, f.Level, f.Code, f.Message))
+	}
+
+	if len(lines) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(l.out, name)
+	fmt.Fprintln(l.out, strings.Join(lines, "\n"))
+
+	l.findings += len(lines)
+	return nil
+}
+
+func isLintSeverity(severity string) bool {
+	for _, s := range lintSeverities {
+		if s == severity {
+			return true
+		}
+	}
+	return false
+}
+
+func makeLintCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lint [COMMAND...]",
+		Short: "Check command scripts with shellcheck",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			severity, err := cmd.Flags().GetString("severity")
+
+			if err != nil {
+				return err
+			}
+
+			if !isLintSeverity(severity) {
+				return fmt.Errorf("severity must be one of: %s", strings.Join(lintSeverities, ", "))
+			}
+
+			if _, err := exec.LookPath("shellcheck"); err != nil {
+				printWarning("shellcheck not found in PATH, so scripts cannot be linted")
+				return nil
+			}
+
+			names := args
+
+			if len(names) == 0 {
+				names = commandNames(config.Commands, "")
+			}
+
+			l := linter{
+				config:   config,
+				index:    readSourceIndex(),
+				severity: severity,
+				out:      cmd.OutOrStdout(),
+				reported: make(map[string]bool),
+			}
+
+			for _, name := range names {
+				if err := l.lintCommand(name); err != nil {
+					return err
+				}
+			}
+
+			if l.findings == 1 {
+				return fmt.Errorf("found 1 problem at or above %s severity", severity)
+			} else if l.findings > 1 {
+				return fmt.Errorf("found %d problems at or above %s severity", l.findings, severity)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("severity", defaultLintSeverity, "minimum severity to report: "+strings.Join(lintSeverities, ", "))
+	return cmd
+}
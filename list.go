@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"io"
+)
+
+const listCmdName = "list"
+
+// printCommandList prints the commands from the config, followed by any
+// unavailable ones if asked for, then the aliases, and last of all po's
+// built-in commands under a heading of their own.
+func printCommandList(root *cobra.Command, unavailable bool) {
+	root.Print(rootCommandUsages(root, "", false))
+
+	if unavailable {
+		dim := color.New(color.Faint)
+		dim.Fprint(root.OutOrStderr(), unavailableUsages(root, ""))
+	}
+
+	root.Print(aliasUsages(root, "", false))
+
+	if builtins := rootCommandUsages(root, "", true) + aliasUsages(root, "", true); builtins != "" {
+		bold := color.New(color.Bold)
+		root.Println()
+		bold.Fprintln(root.OutOrStderr(), "BUILT-IN COMMANDS")
+		root.Print(builtins)
+	}
+}
+
+// listedArg, listedFlag and listedCommand are how po list --json gives the
+// commands, so that tools can read them without parsing the columns that
+// are meant for people.
+type listedArg struct {
+	Name    string   `json:"name"`
+	Desc    string   `json:"desc,omitempty"`
+	Min     int      `json:"min"`
+	Max     *int     `json:"max"`
+	Choices []string `json:"choices,omitempty"`
+}
+
+type listedFlag struct {
+	Name    string   `json:"name"`
+	Short   string   `json:"short,omitempty"`
+	Type    string   `json:"type"`
+	Default string   `json:"default,omitempty"`
+	Desc    string   `json:"desc,omitempty"`
+	Choices []string `json:"choices,omitempty"`
+}
+
+type listedCommand struct {
+	Name        string          `json:"name"`
+	Short       string          `json:"short,omitempty"`
+	Long        string          `json:"long,omitempty"`
+	Builtin     bool            `json:"builtin"`
+	Aliases     []string        `json:"aliases,omitempty"`
+	Unavailable string          `json:"unavailable,omitempty"`
+	Args        []listedArg     `json:"args,omitempty"`
+	Flags       []listedFlag    `json:"flags,omitempty"`
+	Commands    []listedCommand `json:"commands,omitempty"`
+}
+
+func newListedArgs(defs []Argument) []listedArg {
+	var args []listedArg
+
+	for _, def := range defs {
+		arg := listedArg{
+			Name:    def.DisplayName(),
+			Desc:    def.Desc,
+			Min:     def.AtLeast(),
+			Choices: def.Choices,
+		}
+
+		// An argument with no upper limit has a max of null.
+		if max := def.AtMost(); max > 0 {
+			arg.Max = &max
+		}
+
+		args = append(args, arg)
+	}
+
+	return args
+}
+
+func newListedFlags(cmd *cobra.Command, command *Command) []listedFlag {
+	var flags []listedFlag
+
+	cmd.LocalFlags().VisitAll(func(f *pflag.Flag) {
+		if f.Name == "help" || f.Hidden || isNegationFlag(f) {
+			return
+		}
+
+		flag := listedFlag{
+			Name:    f.Name,
+			Short:   f.Shorthand,
+			Type:    f.Value.Type(),
+			Default: f.DefValue,
+			Desc:    f.Usage,
+		}
+
+		if command != nil {
+			flag.Choices = command.Flags[f.Name].Choices
+		}
+
+		flags = append(flags, flag)
+	})
+
+	return flags
+}
+
+// listCommands describes the commands below a cobra command, in the order
+// they're listed in. Aliases belong to the colon form of a subcommand, so
+// they're looked up by its full name.
+func listCommands(config *Config, cmd *cobra.Command, aliases map[string][]string, unavailable bool) []listedCommand {
+	var listed []listedCommand
+
+	for _, subCmd := range listedCommands(cmd) {
+		reason := unavailableReason(subCmd)
+
+		if isColonForm(subCmd) || (subCmd.Hidden && (reason == "" || !unavailable)) {
+			continue
+		}
+
+		name := commandName(subCmd)
+		entry := listedCommand{
+			Name:        name,
+			Short:       subCmd.Short,
+			Long:        subCmd.Long,
+			Builtin:     !isConfigCommand(subCmd),
+			Aliases:     aliases[name],
+			Unavailable: reason,
+		}
+
+		var command *Command
+
+		if !entry.Builtin {
+			if commands, key, ok := lookupCommand(config.Commands, name); ok {
+				def := commands[key]
+				command = &def
+				entry.Args = newListedArgs(def.Args)
+			}
+		}
+
+		entry.Flags = newListedFlags(subCmd, command)
+		entry.Commands = listCommands(config, subCmd, aliases, unavailable)
+		listed = append(listed, entry)
+	}
+
+	return listed
+}
+
+func commandAliases(root *cobra.Command) map[string][]string {
+	aliases := make(map[string][]string)
+
+	for _, cmd := range root.Commands() {
+		if len(cmd.Aliases) > 0 {
+			names := append([]string(nil), cmd.Aliases...)
+			sortNames(names)
+			aliases[cmd.Name()] = names
+		}
+	}
+
+	return aliases
+}
+
+func printCommandListJson(out io.Writer, config *Config, root *cobra.Command, unavailable bool) error {
+	listed := listCommands(config, root, commandAliases(root), unavailable)
+
+	if listed == nil {
+		listed = []listedCommand{}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(listed)
+}
+
+func makeListCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   listCmdName,
+		Short: "List commands",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			unavailable, _ := cmd.Flags().GetBool("unavailable")
+			asJson, _ := cmd.Flags().GetBool("json")
+
+			if asJson {
+				return printCommandListJson(cmd.OutOrStdout(), config, cmd.Root(), unavailable)
+			}
+
+			printCommandList(cmd.Root(), unavailable)
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("unavailable", false, "include unavailable commands")
+	cmd.Flags().Bool("json", false, "list the commands, their arguments and their flags as JSON")
+	return cmd
+}
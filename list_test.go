@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCommandListKeepsBuiltinsApart(t *testing.T) {
+	config := mustParseConfig(t, `
+aliases:
+  h: hello
+commands:
+  hello:
+    short: Prints a greeting
+    script: echo hello
+`)
+
+	root := newRootCommand()
+
+	if err := addCommands(root, config, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	root.SetOut(&out)
+	printCommandList(root, false)
+
+	project, builtins, ok := strings.Cut(out.String(), "BUILT-IN COMMANDS\n")
+
+	if !ok {
+		t.Fatalf("expected a section for the built-in commands, got:\n%s", out.String())
+	}
+
+	for _, name := range []string{"hello", "h "} {
+		if !strings.Contains(project, name) {
+			t.Errorf("expected %q to be listed with the project's commands", name)
+		}
+	}
+
+	for _, name := range []string{"validate", "!!"} {
+		if strings.Contains(project, name) || !strings.Contains(builtins, name) {
+			t.Errorf("expected %q to be listed with the built-in commands only", name)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/fatih/color"
+	"regexp"
+	"strings"
+)
+
+var (
+	markdownHeadingRegexp = regexp.MustCompile(`^#{1,6}\s+(.*?)\s*#*$`)
+	markdownBulletRegexp  = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+)
+
+// renderMarkdown formats the little markdown that's useful in a command's
+// description for a terminal: headings are bold, bullets are indented, and
+// fenced code blocks are indented and dimmed. Anything else is left as it
+// was written.
+func renderMarkdown(text string) string {
+	bold := color.New(color.Bold)
+	dim := color.New(color.Faint)
+	var lines []string
+	inCode := false
+
+	for _, line := range strings.Split(text, "\n") {
+		switch {
+		case strings.HasPrefix(strings.TrimSpace(line), "```"):
+			inCode = !inCode
+		case inCode:
+			lines = append(lines, "    "+dim.Sprint(line))
+		case markdownHeadingRegexp.MatchString(line):
+			lines = append(lines, bold.Sprint(markdownHeadingRegexp.FindStringSubmatch(line)[1]))
+		case markdownBulletRegexp.MatchString(line):
+			m := markdownBulletRegexp.FindStringSubmatch(line)
+			lines = append(lines, "  "+m[1]+"• "+m[2])
+		default:
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
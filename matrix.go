@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"regexp"
+	"strings"
+)
+
+type MatrixAxis struct {
+	Name   string
+	Values []string
+}
+
+// Matrix keeps its axes in the order they're written, as that decides how
+// the commands it expands to are nested.
+type Matrix []MatrixAxis
+
+func (m *Matrix) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var items yaml.MapSlice
+
+	if err := unmarshal(&items); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		values, ok := item.Value.([]interface{})
+
+		if !ok {
+			return fmt.Errorf("matrix '%v' must be a list of values", item.Key)
+		}
+
+		axis := MatrixAxis{Name: fmt.Sprint(item.Key)}
+
+		for _, v := range values {
+			axis.Values = append(axis.Values, fmt.Sprint(v))
+		}
+
+		*m = append(*m, axis)
+	}
+
+	return nil
+}
+
+func (m Matrix) jsonSchema(b *schemaBuilder) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"additionalProperties": map[string]interface{}{
+			"type":  "array",
+			"items": map[string]interface{}{"type": []string{"string", "number", "boolean"}},
+		},
+	}
+}
+
+var matrixVarRegexp = regexp.MustCompile(`\{\{\s*\.(\w+)\s*\}\}`)
+
+// substituteMatrixVars replaces references such as '{{ .service }}' to the
+// matrix's axes, and leaves anything else for templates to deal with.
+func substituteMatrixVars(s string, vars map[string]string) string {
+	return matrixVarRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+		if value, ok := vars[matrixVarRegexp.FindStringSubmatch(ref)[1]]; ok {
+			return value
+		}
+		return ref
+	})
+}
+
+func (cmd *Command) withMatrixVars(vars map[string]string) Command {
+	expanded := *cmd
+	expanded.Matrix = nil
+	expanded.Commands = nil
+	expanded.Short = substituteMatrixVars(cmd.Short, vars)
+	expanded.Long = substituteMatrixVars(cmd.Long, vars)
+	expanded.Script = substituteMatrixVars(cmd.Script, vars)
+	expanded.ScriptDarwin = substituteMatrixVars(cmd.ScriptDarwin, vars)
+	expanded.ScriptLinux = substituteMatrixVars(cmd.ScriptLinux, vars)
+	expanded.ScriptWindows = substituteMatrixVars(cmd.ScriptWindows, vars)
+
+	if cmd.Flags != nil {
+		expanded.Flags = make(map[string]Flag)
+		mergeFlags(expanded.Flags, cmd.Flags)
+	}
+
+	if cmd.Environment != nil {
+		expanded.Environment = make(map[string]string)
+
+		for k, v := range cmd.Environment {
+			expanded.Environment[k] = substituteMatrixVars(v, vars)
+		}
+	}
+
+	return expanded
+}
+
+func (cmd *Command) expandAxes(axes Matrix, vars map[string]string) (map[string]Command, error) {
+	axis := axes[0]
+	commands := make(map[string]Command)
+
+	for _, value := range axis.Values {
+		if err := validateCommandName(value); err != nil {
+			return nil, fmt.Errorf("matrix '%s': %v", axis.Name, err)
+		}
+
+		vars[axis.Name] = value
+
+		if len(axes) == 1 {
+			commands[value] = cmd.withMatrixVars(vars)
+			continue
+		}
+
+		subCommands, err := cmd.expandAxes(axes[1:], vars)
+
+		if err != nil {
+			return nil, err
+		}
+
+		commands[value] = Command{Commands: subCommands, commandOrder: axes[1].Values}
+	}
+
+	delete(vars, axis.Name)
+	return commands, nil
+}
+
+// expandMatrices turns each command with a matrix into a parent of one
+// command for each combination of values, nested in the order the axes
+// are written. Expansion happens once all the configs are merged, so the
+// subcommands a config adds to a matrix command override the expanded
+// ones of the same name. The parent keeps the command's description,
+// with the name of each key where its value would be.
+func expandMatrices(commands map[string]Command) error {
+	for name, cmd := range commands {
+		if err := expandMatrices(cmd.Commands); err != nil {
+			return err
+		}
+
+		if len(cmd.Matrix) == 0 {
+			continue
+		}
+
+		tracef("expanding matrix for %s", name)
+		expanded, err := cmd.expandAxes(cmd.Matrix, make(map[string]string))
+
+		if err != nil {
+			return fmt.Errorf("cannot expand command '%s': %v", name, err)
+		}
+
+		mergeCommands(expanded, cmd.Commands)
+		parentVars := make(map[string]string)
+
+		for _, axis := range cmd.Matrix {
+			parentVars[axis.Name] = strings.ToUpper(axis.Name)
+		}
+
+		commands[name] = Command{
+			Short:        substituteMatrixVars(cmd.Short, parentVars),
+			Long:         substituteMatrixVars(cmd.Long, parentVars),
+			HiddenP:      cmd.HiddenP,
+			Commands:     expanded,
+			commandOrder: mergeOrder(cmd.Matrix[0].Values, cmd.commandOrder),
+		}
+	}
+
+	return nil
+}
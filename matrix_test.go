@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMatrixParentKeepsDescription(t *testing.T) {
+	config := mustParseConfig(t, `
+commands:
+  deploy:
+    matrix:
+      service: [api, web]
+    short: Deploys the {{ .service }} service
+    long: Runs the deploy script for {{ .service }}.
+    script: ./deploy.sh {{ .service }}
+`)
+
+	if err := expandMatrices(config.Commands); err != nil {
+		t.Fatal(err)
+	}
+
+	deploy := config.Commands["deploy"]
+
+	if deploy.Short != "Deploys the SERVICE service" || deploy.Long != "Runs the deploy script for SERVICE." {
+		t.Errorf("expected the parent to keep its description, got %q and %q", deploy.Short, deploy.Long)
+	}
+
+	if api := deploy.Commands["api"]; api.Short != "Deploys the api service" {
+		t.Errorf("expected the value in the subcommand's description, got %q", api.Short)
+	}
+}
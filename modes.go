@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	defaultScriptFileMode os.FileMode = 0700
+	defaultCacheDirMode   os.FileMode = 0700
+)
+
+// scriptFileMode and cacheDirMode are the permissions of cached scripts
+// and of the cache directories, from the script_file_mode and
+// cache_dir_mode keys of the config.
+var (
+	scriptFileMode = defaultScriptFileMode
+	cacheDirMode   = defaultCacheDirMode
+)
+
+func parseFileMode(key string, s string, required os.FileMode) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid %s '%s' (must be an octal mode such as 0700)", key, s)
+	}
+
+	if os.FileMode(mode)&required != required {
+		return 0, fmt.Errorf("invalid %s '%s' (must include %04o, so that po can use it)", key, s, required)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+func (config *Config) validateFileModes() error {
+	if config.ScriptFileMode != "" {
+		if _, err := parseFileMode("script_file_mode", config.ScriptFileMode, 0400); err != nil {
+			return err
+		}
+	}
+
+	if config.CacheDirMode != "" {
+		if _, err := parseFileMode("cache_dir_mode", config.CacheDirMode, 0700); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// configureFileModes sets the modes of cached scripts and the cache from
+// the configs, with the first config that sets one taking precedence.
+func configureFileModes(configs ...*Config) {
+	for i := len(configs) - 1; i >= 0; i-- {
+		config := configs[i]
+
+		if config == nil {
+			continue
+		}
+
+		if config.ScriptFileMode != "" {
+			if mode, err := parseFileMode("script_file_mode", config.ScriptFileMode, 0400); err == nil {
+				scriptFileMode = mode
+			}
+		}
+
+		if config.CacheDirMode != "" {
+			if mode, err := parseFileMode("cache_dir_mode", config.CacheDirMode, 0700); err == nil {
+				cacheDirMode = mode
+			}
+		}
+	}
+}
+
+var (
+	umaskOnce sync.Once
+	umask     os.FileMode
+)
+
+// withUmask takes the process's umask away from a mode, as creating a
+// file would, for modes that are set with chmod.
+func withUmask(mode os.FileMode) os.FileMode {
+	umaskOnce.Do(func() {
+		mask := unix.Umask(0)
+		unix.Umask(mask)
+		umask = os.FileMode(mask)
+	})
+
+	return mode &^ umask
+}
+
+// tightenMode takes away any permissions a file or directory has beyond
+// the given mode, such as those of a script cached by an older version of
+// po. It never adds any.
+func tightenMode(path string, mode os.FileMode) error {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return err
+	}
+
+	if perm := info.Mode().Perm(); perm&^mode != 0 {
+		tracef("changing the mode of %s from %04o to %04o", path, perm, perm&mode)
+		return os.Chmod(path, perm&mode)
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useFileModes sets the modes of cached scripts and the cache for a test.
+func useFileModes(t *testing.T, script os.FileMode, dir os.FileMode) {
+	t.Helper()
+
+	saved := [...]os.FileMode{scriptFileMode, cacheDirMode}
+	scriptFileMode, cacheDirMode = script, dir
+	t.Cleanup(func() { scriptFileMode, cacheDirMode = saved[0], saved[1] })
+
+	tempCacheDir(t)
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+}
+
+func checkMode(t *testing.T, path string, mode os.FileMode) {
+	t.Helper()
+	info, err := os.Stat(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if perm := info.Mode().Perm(); perm != mode {
+		t.Errorf("expected %s to have mode %04o, got %04o", path, mode, perm)
+	}
+}
+
+func TestCachedScriptModes(t *testing.T) {
+	for _, test := range []struct {
+		script os.FileMode
+		dir    os.FileMode
+	}{
+		{defaultScriptFileMode, defaultCacheDirMode},
+		{0750, 0750},
+		{0755, 0755},
+	} {
+		useFileModes(t, test.script, test.dir)
+		path, err := scriptCachePath("hello", "", "echo hello")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkMode(t, path, withUmask(test.script))
+		checkMode(t, filepath.Dir(path), withUmask(test.dir))
+	}
+}
+
+func TestTempScriptModes(t *testing.T) {
+	for _, mode := range []os.FileMode{defaultScriptFileMode, 0750, 0755} {
+		useFileModes(t, mode, defaultCacheDirMode)
+		dir, path, err := tempScriptPath("hello", "", "echo hello")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		checkMode(t, path, withUmask(mode))
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLooseModesAreTightened(t *testing.T) {
+	useFileModes(t, defaultScriptFileMode, defaultCacheDirMode)
+	path, err := scriptCachePath("hello", "", "echo hello")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{path, filepath.Dir(path)} {
+		if err := os.Chmod(p, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := scriptCachePath("hello", "", "echo hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	checkMode(t, path, 0700)
+	checkMode(t, filepath.Dir(path), 0700)
+}
+
+func TestTighterModesAreKept(t *testing.T) {
+	useFileModes(t, 0750, 0750)
+	path, err := scriptCachePath("hello", "", "echo hello")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chmod(path, 0500); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := scriptCachePath("hello", "", "echo hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	checkMode(t, path, 0500)
+}
+
+func TestFileModesFromConfig(t *testing.T) {
+	useFileModes(t, defaultScriptFileMode, defaultCacheDirMode)
+
+	configureFileModes(
+		mustParseConfig(t, "script_file_mode: \"0750\"\n"),
+		mustParseConfig(t, "script_file_mode: \"0755\"\ncache_dir_mode: \"0750\"\n"),
+	)
+
+	if scriptFileMode != 0750 || cacheDirMode != 0750 {
+		t.Errorf("expected the first config to set each mode, got %04o and %04o", scriptFileMode, cacheDirMode)
+	}
+
+	for _, yml := range []string{
+		"script_file_mode: \"0800\"\n",
+		"script_file_mode: \"0300\"\n",
+		"cache_dir_mode: \"0500\"\n",
+		"cache_dir_mode: rwx\n",
+	} {
+		if _, err := parseConfig([]byte(yml)); err == nil {
+			t.Errorf("expected %q to be invalid", yml)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+var negativeNumberRegexp = regexp.MustCompile(`^-(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?$`)
+
+// negativeNumberMark is put in front of a negative number so that flag
+// parsing leaves it alone. A NUL can't be part of an argument passed to a
+// program, so nothing typed can look like a marked number.
+const negativeNumberMark = "\x00"
+
+// resolveInvokedCommand finds the command from the config that the
+// arguments run, following its subcommands as far as they go.
+func resolveInvokedCommand(config *Config, args []string) (*Command, bool) {
+	name, ok := invokedCommand(config, args)
+
+	if !ok {
+		return nil, false
+	}
+
+	command := config.Commands[name]
+	path := positionalArgs(args)
+
+	if len(path) > 0 {
+		path = append(strings.Split(path[0], ":")[1:], path[1:]...)
+	}
+
+	for _, part := range path {
+		sub, ok := command.Commands[part]
+
+		if !ok {
+			break
+		}
+
+		command = sub
+	}
+
+	return &command, true
+}
+
+// acceptsNegativeNumbers is true unless one of a command's flags has a
+// digit as its shorthand, in which case -5 could be that flag.
+func (cmd *Command) acceptsNegativeNumbers() bool {
+	for _, flag := range cmd.Flags {
+		for _, r := range flag.Short {
+			if unicode.IsDigit(r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// takesValue is true if a flag reads its value from the next argument
+// when it's not given with an '='.
+func takesValue(command *Command, arg string) bool {
+	switch arg {
+	case "--" + hostFlag, "--" + directoryFlag, "-" + directoryShorthand:
+		return true
+	}
+
+	for name, flag := range command.Flags {
+		if flag.Type != "bool" && (arg == "--"+name || (flag.Short != "" && arg == "-"+flag.Short)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// markNegativeNumbers marks the arguments to a command from the config
+// that are negative numbers, such as the -5 in 'po math add -5 3', so that
+// they're read as arguments rather than flags. Flag values and anything
+// after a '--' are left as they are.
+func markNegativeNumbers(config *Config, args []string) []string {
+	command, ok := resolveInvokedCommand(config, args)
+
+	if !ok || !command.acceptsNegativeNumbers() {
+		return args
+	}
+
+	marked := make([]string, len(args))
+	copy(marked, args)
+
+	for i := 0; i < len(marked); i++ {
+		arg := marked[i]
+
+		switch {
+		case arg == "--":
+			return marked
+		case takesValue(command, arg):
+			i++
+		case negativeNumberRegexp.MatchString(arg):
+			marked[i] = negativeNumberMark + arg
+		}
+	}
+
+	return marked
+}
+
+// unmarkNegativeNumbers removes the marks that markNegativeNumbers put on
+// arguments, once flag parsing is done with them.
+func unmarkNegativeNumbers(args []string) []string {
+	unmarked := make([]string, len(args))
+
+	for i, arg := range args {
+		unmarked[i] = strings.TrimPrefix(arg, negativeNumberMark)
+	}
+
+	return unmarked
+}
+
+// unmarkArgs removes the marks from the arguments to a command before
+// they're checked or the command is run.
+func unmarkArgs(cmd *cobra.Command) {
+	validateArgs := cmd.Args
+	run := cmd.Run
+
+	cmd.Args = func(cmd *cobra.Command, args []string) error {
+		return validateArgs(cmd, unmarkNegativeNumbers(args))
+	}
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		run(cmd, unmarkNegativeNumbers(args))
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNegativeNumberArgs(t *testing.T) {
+	config := `
+commands:
+  add:
+    args:
+      - var: a
+      - var: b
+    flags:
+      scale:
+        type: int
+      verbose:
+        type: bool
+        short: v
+    script: echo "$a $b $scale"
+  top:
+    args:
+      - var: a
+      - var: b
+    flags:
+      one:
+        type: bool
+        short: "1"
+    script: echo "$a $b"
+`
+
+	for _, test := range []struct {
+		args []string
+		out  string
+		code int
+	}{
+		{[]string{"add", "-5", "3"}, "-5 3 0", 0},
+		{[]string{"add", "-1.5", "-.5"}, "-1.5 -.5 0", 0},
+		{[]string{"add", "2", "-1e3"}, "2 -1e3 0", 0},
+		{[]string{"add", "-v", "-5", "-3"}, "-5 -3 0", 0},
+		{[]string{"add", "--scale", "-2", "-5", "3"}, "-5 3 -2", 0},
+		{[]string{"add", "--scale=-2", "-5", "3"}, "-5 3 -2", 0},
+		{[]string{"add", "--", "-5", "3"}, "-5 3 0", 0},
+		{[]string{"add", "-5", "--", "-3"}, "-5 -3 0", 0},
+		{[]string{"add", "-x", "3"}, "", exitUsage},
+		// With a digit as a shorthand, -5 could be a flag, so it needs a '--'.
+		{[]string{"top", "-5", "3"}, "", exitUsage},
+		{[]string{"top", "--", "-5", "3"}, "-5 3", 0},
+	} {
+		result := runPo(t, config, test.args...)
+
+		if result.Code != test.code {
+			t.Errorf("%v: expected exit code %d, got %d: %s", test.args, test.code, result.Code, result.Stderr)
+		} else if test.code == 0 && strings.TrimSpace(result.Stdout) != test.out {
+			t.Errorf("%v: expected %q, got %q", test.args, test.out, result.Stdout)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+func userDataDir() string {
+	if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+		return dir
+	} else if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".local", "share")
+	} else {
+		return filepath.Join(fallbackDir(), "data")
+	}
+}
+
+// isNoexecDir is true if a directory, or the nearest parent of it that
+// exists, is on a filesystem mounted noexec.
+func isNoexecDir(dir string) bool {
+	for {
+		if noexec, err := isNoexecMount(dir); err == nil {
+			return noexec
+		}
+
+		parent := filepath.Dir(dir)
+
+		if parent == dir {
+			return false
+		}
+
+		dir = parent
+	}
+}
+
+// noexecFallbackDir is where scripts are kept when the cache is on a
+// filesystem mounted noexec, as some laptops have ~/.cache.
+func noexecFallbackDir() string {
+	return filepath.Join(userDataDir(), "po", scriptsCacheName)
+}
+
+// scriptsDir is the directory scripts are kept in. This is in the cache,
+// unless the cache is mounted noexec and the fallback directory isn't.
+// Setting cache_dir moves the cache, and the scripts with it.
+func scriptsDir() (string, error) {
+	dir, err := cacheDir(scriptsCacheName)
+
+	if err != nil || !isNoexecDir(dir) {
+		return dir, err
+	}
+
+	if fallback := noexecFallbackDir(); !isNoexecDir(fallback) {
+		return fallback, nil
+	}
+
+	return dir, nil
+}
+
+// makeScriptsDir makes the directory scripts are kept in. The first time
+// scripts are moved out of a cache mounted noexec, po says where to.
+func makeScriptsDir() (string, error) {
+	dir, err := scriptsDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	if cache, err := cacheDir(scriptsCacheName); err != nil || dir == cache {
+		return makeCacheDir(scriptsCacheName)
+	}
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		printWarning("the cache is on a filesystem mounted noexec, so scripts are kept in %s "+
+			"(set cache_dir in the config to use another directory)", dir)
+	}
+
+	if err := os.MkdirAll(dir, cacheDirMode); err != nil {
+		return "", err
+	}
+
+	return dir, tightenMode(dir, withUmask(cacheDirMode))
+}
@@ -0,0 +1,15 @@
+//go:build darwin || freebsd
+
+package main
+
+import "golang.org/x/sys/unix"
+
+func isNoexecMount(path string) (bool, error) {
+	var stat unix.Statfs_t
+
+	if err := unix.Statfs(path, &stat); err != nil {
+		return false, err
+	}
+
+	return uint64(stat.Flags)&unix.MNT_NOEXEC != 0, nil
+}
@@ -0,0 +1,13 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+func isNoexecMount(path string) (bool, error) {
+	var stat unix.Statfs_t
+
+	if err := unix.Statfs(path, &stat); err != nil {
+		return false, err
+	}
+
+	return stat.Flags&unix.ST_NOEXEC != 0, nil
+}
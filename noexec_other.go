@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+// isNoexecMount can't tell on this system, so assumes the filesystem
+// isn't mounted noexec.
+func isNoexecMount(path string) (bool, error) {
+	return false, nil
+}
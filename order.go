@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+	"gopkg.in/yaml.v2"
+	"sort"
+	"strconv"
+)
+
+// configCommandAnnotation marks a cobra command built from the config with
+// its position among the commands of its parent, so that listings can keep
+// such commands in the order they were declared.
+const configCommandAnnotation = "po_config_command"
+
+// orderedNames puts names in the order they were declared in. Any names
+// that weren't declared, such as those added by a matrix, follow in
+// alphabetical order.
+func orderedNames(names []string, order []string) []string {
+	position := make(map[string]int, len(order))
+
+	for i, name := range order {
+		position[name] = i
+	}
+
+	rank := func(name string) int {
+		if i, ok := position[name]; ok {
+			return i
+		}
+		return len(order)
+	}
+
+	sort.Strings(names)
+	sort.SliceStable(names, func(i, j int) bool {
+		return rank(names[i]) < rank(names[j])
+	})
+
+	return names
+}
+
+// mergeOrder is the declaration order of a map merged from a and b: the
+// keys of a, followed by any keys that only b declares.
+func mergeOrder(a []string, b []string) []string {
+	order := append([]string(nil), a...)
+
+	for _, name := range b {
+		if !containsString(order, name) {
+			order = append(order, name)
+		}
+	}
+
+	return order
+}
+
+func orderedCommandNames(commands map[string]Command, order []string) []string {
+	names := make([]string, 0, len(commands))
+
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	return orderedNames(names, order)
+}
+
+func orderedFlagNames(flags map[string]Flag, order []string) []string {
+	names := make([]string, 0, len(flags))
+
+	for name := range flags {
+		names = append(names, name)
+	}
+
+	return orderedNames(names, order)
+}
+
+func orderedEnvNames(env map[string]string, order []string) []string {
+	return orderedNames(sortedKeys(env), order)
+}
+
+// CommandNames are the names of the config's commands, in the order they
+// were declared.
+func (config *Config) CommandNames() []string {
+	return orderedCommandNames(config.Commands, config.commandOrder)
+}
+
+// CommandNames are the names of the command's subcommands, in the order
+// they were declared.
+func (cmd *Command) CommandNames() []string {
+	return orderedCommandNames(cmd.Commands, cmd.commandOrder)
+}
+
+// FlagNames are the names of the command's flags, in the order they were
+// declared.
+func (cmd *Command) FlagNames() []string {
+	return orderedFlagNames(cmd.Flags, cmd.flagOrder)
+}
+
+func mapSliceKeys(value interface{}) []string {
+	m, _ := value.(yaml.MapSlice)
+	keys := make([]string, 0, len(m))
+
+	for _, item := range m {
+		keys = append(keys, fmt.Sprint(item.Key))
+	}
+
+	return keys
+}
+
+func mapSliceValue(value interface{}, key string) interface{} {
+	m, _ := value.(yaml.MapSlice)
+
+	for _, item := range m {
+		if fmt.Sprint(item.Key) == key {
+			return item.Value
+		}
+	}
+
+	return nil
+}
+
+// recordOrder records the order that the commands, flags and environment
+// variables of a config were written in, as Go maps don't keep it.
+func recordOrder(config *Config, dat []byte) error {
+	var doc yaml.MapSlice
+
+	if err := yaml.Unmarshal(dat, &doc); err != nil {
+		return err
+	}
+
+	commands := mapSliceValue(doc, "commands")
+	config.envOrder = mapSliceKeys(mapSliceValue(doc, "environment"))
+	config.commandOrder = mapSliceKeys(commands)
+	recordCommandOrder(config.Commands, commands)
+	return nil
+}
+
+func recordCommandOrder(commands map[string]Command, value interface{}) {
+	m, _ := value.(yaml.MapSlice)
+
+	for _, item := range m {
+		name := fmt.Sprint(item.Key)
+		command, ok := commands[name]
+
+		if !ok {
+			continue
+		}
+
+		subCommands := mapSliceValue(item.Value, "commands")
+		command.flagOrder = mapSliceKeys(mapSliceValue(item.Value, "flags"))
+		command.envOrder = mapSliceKeys(mapSliceValue(item.Value, "environment"))
+		command.commandOrder = mapSliceKeys(subCommands)
+		recordCommandOrder(command.Commands, subCommands)
+		commands[name] = command
+	}
+}
+
+func isConfigCommand(cmd *cobra.Command) bool {
+	_, ok := cmd.Annotations[configCommandAnnotation]
+	return ok
+}
+
+func configCommandPosition(cmd *cobra.Command) int {
+	position, _ := strconv.Atoi(cmd.Annotations[configCommandAnnotation])
+	return position
+}
+
+// listCollator sorts the commands of the config by name when sort_locale
+// is set, by the rules of that language rather than by byte, so that
+// names that aren't in English are listed where a reader would expect.
+var listCollator *collate.Collator
+
+func configureSortLocale(locale string) {
+	if tag, err := language.Parse(locale); locale != "" && err == nil {
+		listCollator = collate.New(tag)
+	}
+}
+
+// sortNames sorts names for listing, with the rules of the sort_locale if
+// there is one.
+func sortNames(names []string) {
+	if listCollator != nil {
+		listCollator.SortStrings(names)
+	} else {
+		sort.Strings(names)
+	}
+}
+
+// listedCommands are the subcommands of a cobra command in the order they
+// should be listed: the commands from the config in the order they were
+// declared, or by name if there's a sort_locale, followed by po's built-in
+// commands in alphabetical order.
+func listedCommands(cmd *cobra.Command) []*cobra.Command {
+	cmds := append([]*cobra.Command(nil), cmd.Commands()...)
+
+	sort.SliceStable(cmds, func(i, j int) bool {
+		a, b := cmds[i], cmds[j]
+
+		if isConfigCommand(a) && isConfigCommand(b) {
+			if listCollator != nil {
+				return listCollator.CompareString(a.Name(), b.Name()) < 0
+			}
+			return configCommandPosition(a) < configCommandPosition(b)
+		}
+
+		return isConfigCommand(a) && !isConfigCommand(b)
+	})
+
+	return cmds
+}
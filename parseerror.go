@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/fatih/color"
+	"gopkg.in/yaml.v2"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// sourceError is a syntax error at a known line and column of a config,
+// from converting JSON to YAML.
+type sourceError struct {
+	Format  string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (err *sourceError) Error() string {
+	return fmt.Sprintf("%s: line %d: %s", err.Format, err.Line, err.Message)
+}
+
+// configDiagnostic is one problem found parsing a config. Line and Column
+// start at 1, and are 0 when they aren't known.
+type configDiagnostic struct {
+	Line    int
+	Column  int
+	Length  int
+	Message string
+}
+
+// configParseError is a config that couldn't be parsed, with its source,
+// so that the lines around each problem can be shown as a compiler would.
+// Chain is the configs that imported it, nearest first.
+type configParseError struct {
+	Location    string
+	Source      []byte
+	Diagnostics []configDiagnostic
+	Chain       []string
+}
+
+var (
+	yamlErrorLine   = regexp.MustCompile(`^line (\d+): (.*)$`)
+	tomlErrorPrefix = regexp.MustCompile(`^toml: line \d+( \(last key "[^"]*"\))?: `)
+)
+
+// yamlParserProblems are the errors yaml.v2 reports from its parser rather
+// than its scanner. It reports these on the line before the problem, as it
+// counts their lines from zero.
+var yamlParserProblems = []string{
+	"did not find expected key",
+	"did not find expected node content",
+	"did not find expected '-' indicator",
+	"did not find expected ',' or ']'",
+	"did not find expected ',' or '}'",
+	"did not find expected <document start>",
+}
+
+// newConfigParseError adds the location and source of a config to an
+// error from parsing it. Any other error, such as one from validating the
+// config, is returned as it is.
+func newConfigParseError(location string, dat []byte, format string, err error) error {
+	var diags []configDiagnostic
+
+	switch e := err.(type) {
+	case *configVersionError:
+		diags = []configDiagnostic{{Message: e.Message}}
+	case *sourceError:
+		diags = []configDiagnostic{{Line: e.Line, Column: e.Column, Message: e.Message}}
+	case toml.ParseError:
+		diags = []configDiagnostic{tomlDiagnostic(dat, e)}
+	case *yaml.TypeError:
+		for _, msg := range e.Errors {
+			diags = append(diags, yamlTypeDiagnostic(dat, msg))
+		}
+	default:
+		msg := err.Error()
+		converted := fmt.Sprintf("invalid %s config: yaml: ", format)
+
+		switch {
+		case strings.HasPrefix(msg, "yaml: "):
+			diags = []configDiagnostic{yamlSyntaxDiagnostic(dat, strings.TrimPrefix(msg, "yaml: "))}
+		case format != formatYAML && strings.HasPrefix(msg, converted):
+			// Once JSON or TOML are converted to YAML, the lines of
+			// the error no longer match the config's source.
+			diags = []configDiagnostic{{Message: msg}}
+		default:
+			return err
+		}
+	}
+
+	return &configParseError{Location: location, Source: dat, Diagnostics: diags}
+}
+
+func yamlSyntaxDiagnostic(dat []byte, msg string) configDiagnostic {
+	m := yamlErrorLine.FindStringSubmatch(msg)
+
+	if m == nil {
+		return configDiagnostic{Message: msg}
+	}
+
+	line, _ := strconv.Atoi(m[1])
+
+	for _, problem := range yamlParserProblems {
+		if strings.HasPrefix(m[2], problem) {
+			line++
+			break
+		}
+	}
+
+	lines := sourceLines(dat)
+
+	if line < 1 {
+		line = 1
+	}
+
+	if line > len(lines) {
+		line = len(lines)
+	}
+
+	return configDiagnostic{Line: line, Column: firstColumn(lines, line), Message: m[2]}
+}
+
+// yamlTypeDiagnostic points at the value of a type error, when it can be
+// found on its line.
+func yamlTypeDiagnostic(dat []byte, msg string) configDiagnostic {
+	m := yamlErrorLine.FindStringSubmatch(msg)
+
+	if m == nil {
+		return configDiagnostic{Message: msg}
+	}
+
+	line, _ := strconv.Atoi(m[1])
+	lines := sourceLines(dat)
+	diag := configDiagnostic{Line: line, Column: firstColumn(lines, line), Message: m[2]}
+
+	if line < 1 || line > len(lines) {
+		diag.Line, diag.Column = 0, 0
+		return diag
+	}
+
+	start := strings.Index(m[2], "`")
+	end := strings.LastIndex(m[2], "`")
+
+	if start >= 0 && end > start {
+		value := m[2][start+1 : end]
+		truncated := strings.HasSuffix(value, "...")
+		value = strings.TrimSuffix(value, "...")
+
+		if i := strings.Index(lines[line-1], value); value != "" && i >= 0 {
+			// yaml.v2 shortens long values, so the rest of a shortened
+			// value is found in the source.
+			if truncated {
+				rest := lines[line-1][i+len(value):]
+				value += rest[:len(rest)-len(strings.TrimLeftFunc(rest, func(r rune) bool { return !unicode.IsSpace(r) }))]
+			}
+
+			diag.Column = len([]rune(lines[line-1][:i])) + 1
+			diag.Length = len([]rune(value))
+		}
+	}
+
+	return diag
+}
+
+func tomlDiagnostic(dat []byte, err toml.ParseError) configDiagnostic {
+	msg := err.Message
+
+	if msg == "" {
+		msg = tomlErrorPrefix.ReplaceAllString(err.Error(), "")
+	}
+
+	diag := configDiagnostic{Line: err.Position.Line, Message: msg}
+	start := err.Position.Start
+
+	if start >= 0 && start <= len(dat) {
+		lineStart := strings.LastIndex(string(dat[:start]), "\n") + 1
+		diag.Column = len([]rune(string(dat[lineStart:start]))) + 1
+		diag.Length = err.Position.Len
+	}
+
+	return diag
+}
+
+func sourceLines(dat []byte) []string {
+	text := strings.Replace(string(dat), "\r\n", "\n", -1)
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// firstColumn is the column of the first character on a line that isn't
+// a space, as yaml.v2 doesn't give the column of its errors.
+func firstColumn(lines []string, line int) int {
+	if line < 1 || line > len(lines) {
+		return 0
+	}
+
+	text := lines[line-1]
+	indent := len(text) - len(strings.TrimLeftFunc(text, unicode.IsSpace))
+	return len([]rune(text[:indent])) + 1
+}
+
+// displayLocation shows a config file relative to the directory po was
+// run from, if it's inside it.
+func displayLocation(location string) string {
+	if !filepath.IsAbs(location) || invocationDir == "" {
+		return location
+	}
+
+	if rel, err := filepath.Rel(invocationDir, location); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+
+	return location
+}
+
+func (err *configParseError) Error() string {
+	bold := color.New(color.Bold)
+	dim := color.New(color.Faint)
+	caret := color.New(color.Bold, color.FgRed)
+	lines := sourceLines(err.Source)
+	location := displayLocation(err.Location)
+
+	var b strings.Builder
+
+	for i, diag := range err.Diagnostics {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		switch {
+		case diag.Line > 0 && diag.Column > 0:
+			b.WriteString(bold.Sprintf("%s:%d:%d:", location, diag.Line, diag.Column))
+		case diag.Line > 0:
+			b.WriteString(bold.Sprintf("%s:%d:", location, diag.Line))
+		default:
+			b.WriteString(bold.Sprintf("%s:", location))
+		}
+
+		b.WriteString(" " + diag.Message)
+
+		if diag.Line < 1 || diag.Line > len(lines) {
+			continue
+		}
+
+		first, last := diag.Line-1, diag.Line+1
+
+		if first < 1 {
+			first = 1
+		}
+
+		if last > len(lines) {
+			last = len(lines)
+		}
+
+		width := len(strconv.Itoa(last))
+
+		for n := first; n <= last; n++ {
+			b.WriteString("\n" + dim.Sprintf(" %*d | ", width, n) + lines[n-1])
+
+			if n == diag.Line && diag.Column > 0 {
+				marker := "^"
+
+				if diag.Length > 1 {
+					marker += strings.Repeat("~", diag.Length-1)
+				}
+
+				b.WriteString("\n" + dim.Sprintf(" %*s | ", width, "") +
+					caretPadding(lines[n-1], diag.Column) + caret.Sprint(marker))
+			}
+		}
+	}
+
+	for _, parent := range err.Chain {
+		b.WriteString("\n  imported by " + displayLocation(parent))
+	}
+
+	return b.String()
+}
+
+// caretPadding lines a caret up under a column, keeping any tabs before
+// it so that it lines up however wide they're shown.
+func caretPadding(line string, column int) string {
+	var b strings.Builder
+
+	for i, r := range []rune(line) {
+		if i >= column-1 {
+			break
+		}
+
+		if r == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+
+	return b.String()
+}
+
+// importChain is the locations of the configs that led to an import,
+// nearest first.
+func importChain(parents []Import) []string {
+	chain := make([]string, len(parents))
+
+	for i, parent := range parents {
+		chain[len(parents)-1-i] = parent.Location()
+	}
+
+	return chain
+}
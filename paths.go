@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+)
+
+const (
+	pathTypeFile = "file"
+	pathTypeDir  = "dir"
+)
+
+var argTypes = []string{"string", pathTypeFile, pathTypeDir}
+
+// invocationDir is the directory po was run from, before any --directory
+// option changed it. Paths given to file and dir arguments and flags are
+// relative to it.
+var invocationDir string
+
+func isPathType(t string) bool {
+	return t == pathTypeFile || t == pathTypeDir
+}
+
+// mustExist is true unless must_exist is set to false, in which case only
+// the directory a path would be in has to exist.
+func mustExist(p *bool) bool {
+	return p == nil || *p
+}
+
+func (arg *Argument) MustExist() bool {
+	return mustExist(arg.MustExistP)
+}
+
+func (flag *Flag) MustExist() bool {
+	return mustExist(flag.MustExistP)
+}
+
+// resolvePath makes a path given on the command line absolute.
+func resolvePath(path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	if invocationDir == "" {
+		if abs, err := filepath.Abs(path); err == nil {
+			return abs
+		}
+		return path
+	}
+
+	return filepath.Join(invocationDir, path)
+}
+
+// checkPath checks that a path is a file or directory, as its type says.
+// If it doesn't have to exist, the directory it would be in has to.
+func checkPath(pathType string, path string, exist bool) error {
+	info, err := os.Stat(path)
+
+	if os.IsNotExist(err) && !exist {
+		if info, err := os.Stat(filepath.Dir(path)); err != nil || !info.IsDir() {
+			return fmt.Errorf("no such directory: %s", filepath.Dir(path))
+		}
+		return nil
+	}
+
+	switch {
+	case os.IsNotExist(err) && pathType == pathTypeDir:
+		return fmt.Errorf("no such directory: %s", path)
+	case os.IsNotExist(err):
+		return fmt.Errorf("no such file: %s", path)
+	case err != nil:
+		return err
+	case pathType == pathTypeDir && !info.IsDir():
+		return fmt.Errorf("not a directory: %s", path)
+	case pathType == pathTypeFile && info.IsDir():
+		return fmt.Errorf("is a directory: %s", path)
+	}
+
+	return nil
+}
+
+func (arg *Argument) checkPaths(vals []string) error {
+	if !isPathType(arg.Type) {
+		return nil
+	}
+
+	for _, val := range vals {
+		if err := checkPath(arg.Type, resolvePath(val), arg.MustExist()); err != nil {
+			return fmt.Errorf("argument %s: %v", arg.Var, err)
+		}
+	}
+
+	return nil
+}
+
+// pathValue is the value of a file or dir flag, which is made absolute
+// when it's set, so that it still refers to the same path in a script run
+// from another directory.
+type pathValue struct {
+	value    string
+	pathType string
+}
+
+func (v *pathValue) String() string {
+	return v.value
+}
+
+func (v *pathValue) Set(s string) error {
+	v.value = resolvePath(s)
+	return nil
+}
+
+func (v *pathValue) Type() string {
+	return v.pathType
+}
+
+// checkFlagPaths adds a check that the paths given to file and dir flags
+// exist to a command's check of its arguments.
+func checkFlagPaths(checkArgs cobra.PositionalArgs, flags map[string]Flag) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		for _, name := range orderedFlagNames(flags, nil) {
+			flag := flags[name]
+			value := cmd.Flags().Lookup(name)
+
+			if !isPathType(flag.Type) || !value.Changed || value.Value.String() == "" {
+				continue
+			}
+
+			if err := checkPath(flag.Type, value.Value.String(), flag.MustExist()); err != nil {
+				return fmt.Errorf("flag --%s: %v", name, err)
+			}
+		}
+
+		if checkArgs == nil {
+			return nil
+		}
+
+		return checkArgs(cmd, args)
+	}
+}
+
+// resolvePathArgs makes the paths given to a command's file and dir
+// arguments absolute before it's run.
+func resolvePathArgs(cmd *cobra.Command, defs []Argument) {
+	run := cmd.Run
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		resolved := append([]string(nil), args...)
+		i := 0
+
+		for d, vals := range splitArgs(defs, args) {
+			for range vals {
+				if isPathType(defs[d].Type) {
+					resolved[i] = resolvePath(resolved[i])
+				}
+				i++
+			}
+		}
+
+		run(cmd, resolved)
+	}
+}
+
+// pathCompletion is how the shell completes a file or dir value.
+func pathCompletion(pathType string) cobra.ShellCompDirective {
+	if pathType == pathTypeDir {
+		return cobra.ShellCompDirectiveFilterDirs
+	}
+	return cobra.ShellCompDirectiveDefault
+}
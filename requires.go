@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	skipChecksFlag      = "skip-checks"
+	defaultVersionRegex = `(\d+(?:\.\d+)+)`
+)
+
+var requirementRegexp = regexp.MustCompile(`^([^<>=\s]+)\s*(?:(>=|<=|==|=|>|<)\s*(\S+))?$`)
+
+// Requirement is a program a command needs on the PATH, optionally with a
+// version constraint such as '>=1.27'. The version is found by running the
+// program with --version and matching VersionRegex against the output.
+type Requirement struct {
+	Name         string
+	Version      string
+	VersionRegex string `yaml:"version_regex"`
+}
+
+// UnmarshalYAML reads a requirement written either as a string such as
+// 'kubectl>=1.27', or as a map with the same keys as the struct.
+func (r *Requirement) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+
+	if err := unmarshal(&s); err == nil {
+		return r.parse(s)
+	}
+
+	type plain Requirement
+	return unmarshal((*plain)(r))
+}
+
+func (r *Requirement) parse(s string) error {
+	match := requirementRegexp.FindStringSubmatch(strings.TrimSpace(s))
+
+	if match == nil {
+		return fmt.Errorf("invalid requirement: %s", s)
+	}
+
+	r.Name = match[1]
+
+	if match[2] != "" {
+		r.Version = match[2] + match[3]
+	}
+
+	return nil
+}
+
+func (r Requirement) jsonSchema(b *schemaBuilder) map[string]interface{} {
+	t := reflect.TypeOf(r)
+	b.define(t)
+
+	return map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string"},
+			map[string]interface{}{"$ref": "#/definitions/" + t.Name()},
+		},
+	}
+}
+
+func (r *Requirement) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("requirement must have a name")
+	}
+
+	if r.Version != "" {
+		var parsed Requirement
+
+		if err := parsed.parse(r.Name + r.Version); err != nil || parsed.Version == "" {
+			return fmt.Errorf("invalid version constraint for %s: %s", r.Name, r.Version)
+		}
+	}
+
+	if r.VersionRegex != "" {
+		if _, err := regexp.Compile(r.VersionRegex); err != nil {
+			return fmt.Errorf("invalid version_regex for %s: %v", r.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func parseVersion(s string) []int {
+	var parts []int
+
+	for _, p := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(p)
+
+		if err != nil {
+			break
+		}
+
+		parts = append(parts, n)
+	}
+
+	return parts
+}
+
+func compareVersions(a []int, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func satisfiesVersion(version string, constraint string) bool {
+	match := requirementRegexp.FindStringSubmatch("x" + constraint)
+	cmp := compareVersions(parseVersion(version), parseVersion(match[3]))
+
+	switch match[2] {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	default:
+		return cmp == 0
+	}
+}
+
+// check returns why the requirement isn't met, or an empty string if it is.
+func (r *Requirement) check() string {
+	path, err := exec.LookPath(r.Name)
+
+	if err != nil {
+		return "not found in PATH"
+	}
+
+	if r.Version == "" {
+		return ""
+	}
+
+	out, _ := exec.Command(path, "--version").CombinedOutput()
+	pattern := r.VersionRegex
+
+	if pattern == "" {
+		pattern = defaultVersionRegex
+	}
+
+	match := regexp.MustCompile(pattern).FindStringSubmatch(string(out))
+
+	if match == nil {
+		return fmt.Sprintf("cannot find version to check it is %s", r.Version)
+	}
+
+	version := match[0]
+
+	if len(match) > 1 {
+		version = match[1]
+	}
+
+	if !satisfiesVersion(version, r.Version) {
+		return fmt.Sprintf("version %s does not satisfy %s", version, r.Version)
+	}
+
+	return ""
+}
+
+// checkRequirements checks every requirement before any fail, so that
+// they can all be reported together.
+func checkRequirements(requires []Requirement, hints map[string]string) error {
+	var problems []string
+
+	for _, r := range requires {
+		if reason := r.check(); reason != "" {
+			problem := fmt.Sprintf("  %s: %s", r.Name, reason)
+
+			if hint, ok := hints[r.Name]; ok {
+				problem += fmt.Sprintf(" (%s)", hint)
+			}
+
+			problems = append(problems, problem)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("requirements not met:\n%s", strings.Join(problems, "\n"))
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"strings"
+)
+
+const runCmdName = "run"
+
+// runArgs removes 'run' from the arguments to po if it's followed by a
+// command from the config, so that po runs that command directly. If it's
+// followed by anything else, the arguments are left for po run to reject.
+func runArgs(config *Config, args []string) []string {
+	i := firstPositionalIndex(args)
+
+	if i < 0 || args[i] != runCmdName {
+		return args
+	}
+
+	if _, ok := invokedCommand(config, args); !ok {
+		return args
+	}
+
+	return append(append([]string(nil), args[:i]...), args[i+1:]...)
+}
+
+// makeRunCommand makes po run, which only ever runs a command from the
+// config, so that scripts calling po can't be caught out by a built-in
+// command of the same name. Commands from the config are run directly, so
+// this only handles anything else.
+func makeRunCommand(config *Config) *cobra.Command {
+	return &cobra.Command{
+		Use:                "run COMMAND [ARGS...]",
+		Short:              "Run a command from the config, never a built-in one",
+		DisableFlagParsing: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			positional := positionalArgs(args)
+
+			switch {
+			case len(positional) > 0:
+				return usageError{fmt.Errorf("unknown command %q in the config", positional[0])}
+			case hasAnyArg(args, "-h", "--help"):
+				return cmd.Help()
+			default:
+				return usageError{fmt.Errorf("requires the name of a command")}
+			}
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) > 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			var names []string
+
+			for _, name := range sortedCommandNames(config.Commands) {
+				command := config.Commands[name]
+
+				if !command.Hidden() && strings.HasPrefix(name, toComplete) {
+					names = append(names, completionWithDesc(name, command.Short))
+				}
+			}
+
+			return names, cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+}
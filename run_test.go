@@ -0,0 +1,26 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRunArgs(t *testing.T) {
+	config := mustParseConfig(t, "commands:\n  build:\n    script: echo build\n")
+
+	for _, test := range []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"run", "build"}, []string{"build"}},
+		{[]string{"--directory", "run", "run", "build"}, []string{"--directory", "run", "build"}},
+		{[]string{"-C", "run", "build"}, []string{"-C", "run", "build"}},
+		{[]string{"build", "run"}, []string{"build", "run"}},
+		{[]string{"run", "deploy"}, []string{"run", "deploy"}},
+		{[]string{"--", "run", "build"}, []string{"--", "run", "build"}},
+	} {
+		if args := runArgs(config, test.args); !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("%v: expected %v, got %v", test.args, test.expected, args)
+		}
+	}
+}
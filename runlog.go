@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// runLog appends a line to the project's log file when a command starts,
+// and another when it finishes, so that there's a record of who ran what.
+type runLog struct {
+	Path    string
+	User    string
+	Command string
+	Args    []string
+	Flags   []string
+	start   time.Time
+}
+
+func currentUsername() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// newRunLog returns nil if there's no log file, so that logging can be
+// skipped without checking first.
+func newRunLog(path string, cmd *cobra.Command, args []string) *runLog {
+	if path == "" {
+		return nil
+	}
+
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	var flags []string
+
+	cmd.LocalFlags().VisitAll(func(flag *pflag.Flag) {
+		if !flag.Changed {
+			return
+		}
+
+		value := flag.Value.String()
+
+		if isSensitiveEnvVar(flag.Name) {
+			value = "****"
+		}
+
+		flags = append(flags, fmt.Sprintf("--%s=%s", flag.Name, value))
+	})
+
+	return &runLog{
+		Path:    path,
+		User:    currentUsername(),
+		Command: cmd.Root().Name() + " " + spacedName(commandName(cmd)),
+		Args:    args,
+		Flags:   flags,
+	}
+}
+
+func (l *runLog) Start() {
+	if l == nil {
+		return
+	}
+
+	l.start = time.Now()
+	l.write(fmt.Sprintf("start args=%q flags=%q",
+		strings.Join(l.Args, " "), strings.Join(l.Flags, " ")))
+}
+
+func (l *runLog) Finish(code int) {
+	if l == nil {
+		return
+	}
+
+	l.write(fmt.Sprintf("finish exit=%d duration=%v",
+		code, time.Since(l.start).Round(time.Millisecond)))
+}
+
+// write appends a line to the log. The file is locked while it's written,
+// so that the lines of commands run at the same time don't interleave. A
+// log that can't be written is a warning, not a reason to stop the command.
+func (l *runLog) write(event string) {
+	line := fmt.Sprintf("%s user=%s command=%q %s\n",
+		time.Now().Format(time.RFC3339), l.User, l.Command, event)
+
+	if err := appendLocked(l.Path, maskSecrets(line)); err != nil {
+		printWarning("cannot write to log file: %v", err)
+	}
+}
+
+func appendLocked(path string, s string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	if err := unix.Flock(int(file.Fd()), unix.LOCK_EX); err != nil {
+		return err
+	}
+
+	defer unix.Flock(int(file.Fd()), unix.LOCK_UN)
+
+	_, err = file.WriteString(s)
+	return err
+}
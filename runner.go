@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const timeFlag = "time"
+
+var forwardedSignals = []os.Signal{
+	syscall.SIGINT,
+	syscall.SIGTERM,
+	syscall.SIGHUP,
+	syscall.SIGQUIT,
+}
+
+func processExitCode(err error) (int, error) {
+	code, _, err := processStatus(err)
+	return code, err
+}
+
+// processStatus is how a process ended: its exit code, which is 128 plus
+// the number of the signal that killed it if there was one, as a shell
+// would report it, and that signal. A process that exited with such a code
+// of its own accord wasn't killed, so the signal is taken from the wait
+// status rather than the code.
+func processStatus(err error) (int, syscall.Signal, error) {
+	if err == nil {
+		return 0, 0, nil
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal()), status.Signal(), nil
+		}
+		return exitErr.ExitCode(), 0, nil
+	}
+
+	return 0, 0, err
+}
+
+// runProcess runs a script as a child process rather than replacing po
+// with it, so that po can act after the script exits. An interrupt from
+// the terminal already reaches the child through its process group, so
+// it isn't forwarded a second time.
+func runProcess(args []string, env []string) (int, error) {
+	code, _, err := runProcessWithInput(args, env, os.Stdin)
+	return code, err
+}
+
+func runProcessWithInput(args []string, env []string, stdin io.Reader) (int, syscall.Signal, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = env
+	cmd.Stdin = stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+
+	defer func() {
+		signal.Stop(signals)
+		close(signals)
+	}()
+
+	if err := cmd.Start(); err != nil {
+		return 0, 0, err
+	}
+
+	go func() {
+		for sig := range signals {
+			if sig != syscall.SIGINT {
+				cmd.Process.Signal(sig)
+			}
+		}
+	}()
+
+	return processStatus(cmd.Wait())
+}
+
+// printTimingSummary goes to stderr so that it doesn't end up in the
+// output of a command that's being piped elsewhere.
+func printTimingSummary(name string, duration time.Duration, code int) {
+	status := color.New(color.FgGreen)
+
+	if code != 0 {
+		status = color.New(color.FgRed)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s finished in %v ", name, duration.Round(time.Millisecond))
+	status.Fprintf(os.Stderr, "(exit %d)\n", code)
+}
+
+const (
+	bannerFlag    = "banner"
+	bannerNever   = "never"
+	bannerFailure = "failure"
+	bannerAlways  = "always"
+)
+
+// bannerMode decides when to print a banner after a script exits, or
+// returns an empty string if it shouldn't be printed. The --banner flag
+// overrides the failure_banner setting in the config.
+func bannerMode(cmd *cobra.Command, failureBanner bool) (string, error) {
+	flag := cmd.Flags().Lookup(bannerFlag)
+
+	if flag == nil || !flag.Changed {
+		if failureBanner {
+			return bannerFailure, nil
+		}
+		return "", nil
+	}
+
+	switch mode := flag.Value.String(); mode {
+	case bannerNever:
+		return "", nil
+	case bannerFailure, bannerAlways:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid --%s: %s (must be one of: %s, %s, %s)",
+			bannerFlag, mode, bannerNever, bannerFailure, bannerAlways)
+	}
+}
+
+// printBanner prints a line that stands out at the end of a long log, to
+// show whether the command succeeded. Like the timing summary, it goes to
+// stderr.
+func printBanner(name string, duration time.Duration, code int, mode string) {
+	if mode == "" || (code == 0 && mode != bannerAlways) {
+		return
+	}
+
+	if duration >= time.Second {
+		duration = duration.Round(time.Second)
+	} else {
+		duration = duration.Round(time.Millisecond)
+	}
+
+	name = "po " + spacedName(name)
+
+	if code == 0 {
+		green := color.New(color.Bold, color.FgGreen)
+		green.Fprintf(os.Stderr, "\u2713 %s succeeded after %v\n", name, duration)
+	} else {
+		red := color.New(color.Bold, color.FgRed)
+		red.Fprintf(os.Stderr, "\u2717 %s failed (exit %d) after %v\n", name, code, duration)
+	}
+}
+
+const noRetryFlag = "no-retry"
+
+type Retry struct {
+	Attempts int
+	Delay    string
+	Backoff  float64
+}
+
+func (r *Retry) Validate() error {
+	if r.Attempts < 1 {
+		return fmt.Errorf("retry attempts must be at least 1")
+	}
+
+	if r.Delay != "" {
+		if _, err := time.ParseDuration(r.Delay); err != nil {
+			return fmt.Errorf("invalid retry delay: %v", err)
+		}
+	}
+
+	if r.Backoff != 0 && r.Backoff < 1 {
+		return fmt.Errorf("retry backoff cannot be less than 1")
+	}
+
+	return nil
+}
+
+func (r *Retry) delay() time.Duration {
+	delay, _ := time.ParseDuration(r.Delay)
+	return delay
+}
+
+func (r *Retry) backoff() float64 {
+	if r.Backoff == 0 {
+		return 1
+	}
+	return r.Backoff
+}
+
+// runWithRetries calls run until it succeeds or the attempts run out, and
+// returns the exit code of the last attempt. A script interrupted from
+// the terminal isn't retried.
+func runWithRetries(retry *Retry, run func() (int, error)) (int, error) {
+	if retry == nil {
+		return run()
+	}
+
+	delay := retry.delay()
+	yellow := color.New(color.FgYellow)
+
+	for attempt := 1; ; attempt++ {
+		code, err := run()
+
+		if err != nil || code == 0 || attempt >= retry.Attempts || code == 128+int(syscall.SIGINT) {
+			return code, err
+		}
+
+		yellow.Fprintf(os.Stderr, "attempt %d/%d failed (exit %d), retrying in %v\n",
+			attempt, retry.Attempts, code, delay)
+
+		time.Sleep(delay)
+		delay = time.Duration(float64(delay) * retry.backoff())
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+func TestProcessStatusTellsSignalsFromExitCodes(t *testing.T) {
+	for _, test := range []struct {
+		script string
+		code   int
+		signal syscall.Signal
+	}{
+		{"exit 0", 0, 0},
+		{"exit 3", 3, 0},
+		{"exit 130", 130, 0},
+		{"kill -INT $$", 130, syscall.SIGINT},
+		{"kill -TERM $$", 143, syscall.SIGTERM},
+	} {
+		code, signal, err := processStatus(exec.Command("/bin/sh", "-c", test.script).Run())
+
+		if err != nil || code != test.code || signal != test.signal {
+			t.Errorf("%s: expected (%d, %v), got (%d, %v, %v)",
+				test.script, test.code, test.signal, code, signal, err)
+		}
+	}
+}
+
+func TestExitEnvVars(t *testing.T) {
+	env := exitEnvVars(130, 0)
+
+	if env[0] != "PO_EXIT_CODE=130" || env[1] != "PO_SIGNAL=" {
+		t.Errorf("expected no signal for a script that exited, got %q", env)
+	}
+
+	env = exitEnvVars(130, syscall.SIGINT)
+
+	if env[1] != "PO_SIGNAL=SIGINT" {
+		t.Errorf("expected SIGINT, got %q", env)
+	}
+}
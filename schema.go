@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"reflect"
+	"strings"
+)
+
+const jsonSchemaVersion = "http://json-schema.org/draft-07/schema#"
+
+var flagTypes = []string{"string", "int", "bool", pathTypeFile, pathTypeDir}
+
+// schemaEnums restricts fields to a fixed set of values, keyed by the
+// struct name and YAML key of the field.
+var schemaEnums = map[string][]string{
+	"Flag.type":     flagTypes,
+	"Argument.type": argTypes,
+}
+
+// schemaScalars are string fields that are commonly written as other
+// kinds of YAML scalar, such as a default of 3 for an int flag.
+var schemaScalars = map[string]bool{
+	"Flag.default":            true,
+	"Config.version":          true,
+	"Config.script_file_mode": true,
+	"Config.cache_dir_mode":   true,
+}
+
+// yamlFieldName returns the key a struct field is read from, following
+// the same rules as the YAML decoder: the tag if there is one, otherwise
+// the lowercased field name.
+func yamlFieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+
+	tag := strings.Split(field.Tag.Get("yaml"), ",")[0]
+
+	if tag == "-" {
+		return "", false
+	} else if tag != "" {
+		return tag, true
+	}
+
+	return strings.ToLower(field.Name), true
+}
+
+// schemaTyper is implemented by types that are read from YAML in a form
+// that reflection can't tell, such as a list kept as a map.
+type schemaTyper interface {
+	jsonSchema(b *schemaBuilder) map[string]interface{}
+}
+
+type schemaBuilder struct {
+	definitions map[string]interface{}
+}
+
+func (b *schemaBuilder) typeSchema(t reflect.Type) map[string]interface{} {
+	if s, ok := reflect.Zero(t).Interface().(schemaTyper); ok {
+		return s.jsonSchema(b)
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.typeSchema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": b.typeSchema(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": b.typeSchema(t.Elem()),
+		}
+	case reflect.Struct:
+		b.define(t)
+		return map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+	default:
+		// An empty schema accepts any value, which is better than a schema
+		// that rejects a config po would read.
+		return map[string]interface{}{}
+	}
+}
+
+func (b *schemaBuilder) define(t reflect.Type) {
+	if _, ok := b.definitions[t.Name()]; ok {
+		return
+	}
+
+	properties := make(map[string]interface{})
+	def := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+
+	// The definition is added before its fields are walked, so that
+	// recursive types such as Command refer back to it.
+	b.definitions[t.Name()] = def
+
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := yamlFieldName(t.Field(i))
+
+		if !ok {
+			continue
+		}
+
+		prop := b.typeSchema(t.Field(i).Type)
+		key := t.Name() + "." + name
+
+		if enum, ok := schemaEnums[key]; ok {
+			prop["enum"] = enum
+		}
+
+		if schemaScalars[key] {
+			prop["type"] = []string{"string", "number", "boolean"}
+		}
+
+		properties[name] = prop
+	}
+}
+
+// configSchema builds a JSON Schema for the config file from the Config
+// struct, so that it can't drift from what po actually reads.
+func configSchema() map[string]interface{} {
+	b := &schemaBuilder{definitions: make(map[string]interface{})}
+	b.define(reflect.TypeOf(Config{}))
+
+	// Keywords alongside a $ref are ignored, so the root has a copy of
+	// the Config definition rather than a reference to it.
+	schema := make(map[string]interface{})
+
+	for k, v := range b.definitions["Config"].(map[string]interface{}) {
+		schema[k] = v
+	}
+
+	schema["$schema"] = jsonSchemaVersion
+	schema["title"] = "po configuration"
+	schema["definitions"] = b.definitions
+	return schema
+}
+
+func makeSchemaCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "schema",
+		Short: "Print a JSON Schema for the configuration file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dat, err := json.MarshalIndent(configSchema(), "", "  ")
+
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), string(dat))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// checkSchemaFields checks that each field the YAML decoder reads from a
+// struct, and the structs it contains, is in the schema's definitions.
+// Types that give their own schema are left to it.
+func checkSchemaFields(t *testing.T, definitions map[string]interface{}, typ reflect.Type, seen map[reflect.Type]bool) {
+	t.Helper()
+
+	for {
+		if _, ok := reflect.Zero(typ).Interface().(schemaTyper); ok {
+			return
+		}
+
+		if k := typ.Kind(); k != reflect.Ptr && k != reflect.Slice && k != reflect.Map {
+			break
+		}
+
+		typ = typ.Elem()
+	}
+
+	if typ.Kind() != reflect.Struct || seen[typ] {
+		return
+	}
+
+	seen[typ] = true
+	def, ok := definitions[typ.Name()].(map[string]interface{})
+
+	if !ok {
+		t.Errorf("expected a definition for %s", typ.Name())
+		return
+	}
+
+	properties := def["properties"].(map[string]interface{})
+
+	for i := 0; i < typ.NumField(); i++ {
+		name, ok := yamlFieldName(typ.Field(i))
+
+		if !ok {
+			continue
+		}
+
+		if _, ok := properties[name]; !ok {
+			t.Errorf("expected %s.%s in the schema", typ.Name(), name)
+		}
+
+		checkSchemaFields(t, definitions, typ.Field(i).Type, seen)
+	}
+}
+
+func TestSchemaHasEveryField(t *testing.T) {
+	schema := configSchema()
+	definitions := schema["definitions"].(map[string]interface{})
+	checkSchemaFields(t, definitions, reflect.TypeOf(Config{}), make(map[reflect.Type]bool))
+
+	for _, name := range []string{"Command", "Flag", "Argument", "Amount", "Import"} {
+		if _, ok := definitions[name]; !ok {
+			t.Errorf("expected a definition for %s", name)
+		}
+	}
+}
+
+func TestSchemaAcceptsAnythingForUnknownKinds(t *testing.T) {
+	b := &schemaBuilder{definitions: make(map[string]interface{})}
+
+	for _, value := range []interface{}{make(chan int), func() {}, complex(1, 2)} {
+		if s := b.typeSchema(reflect.TypeOf(value)); len(s) != 0 {
+			t.Errorf("expected an empty schema for %T, got %v", value, s)
+		}
+	}
+
+	if s := b.typeSchema(reflect.TypeOf(uint32(0))); s["type"] != "integer" {
+		t.Errorf("expected an integer schema for uint32, got %v", s)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	debugTimingEnvVar = "PO_DEBUG_TIMING"
+	debugTimingFlag   = "debug-timing"
+)
+
+type startupPhase struct {
+	Name     string
+	Duration time.Duration
+}
+
+var (
+	startupTime        = time.Now()
+	debugTimingEnabled = parseBool(os.Getenv(debugTimingEnvVar)) || hasArg("--"+debugTimingFlag)
+	startupPhases      []startupPhase
+	startupPhasesMutex sync.Mutex
+)
+
+func endNoPhase() {}
+
+// startPhase starts timing a phase of loading po, and returns a function
+// that ends it. Phases are only timed with --debug-timing, so that they
+// cost nothing otherwise.
+func startPhase(name string) func() {
+	if !debugTimingEnabled {
+		return endNoPhase
+	}
+
+	start := time.Now()
+
+	return func() {
+		startupPhasesMutex.Lock()
+		defer startupPhasesMutex.Unlock()
+		startupPhases = append(startupPhases, startupPhase{name, time.Since(start)})
+	}
+}
+
+func milliseconds(d time.Duration) string {
+	return fmt.Sprintf("%.1f ms", float64(d)/float64(time.Millisecond))
+}
+
+// printStartupTiming prints how long each phase took, in the order they
+// finished, followed by the total time taken to start.
+func printStartupTiming() {
+	if !debugTimingEnabled {
+		return
+	}
+
+	padding := len("total")
+
+	for _, phase := range startupPhases {
+		if l := displayWidth(phase.Name); l > padding {
+			padding = l
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "po startup timing:")
+
+	for _, phase := range startupPhases {
+		fmt.Fprintf(os.Stderr, "  %s  %10s\n", rightPad(phase.Name, padding), milliseconds(phase.Duration))
+	}
+
+	fmt.Fprintf(os.Stderr, "  %s  %10s\n", rightPad("total", padding), milliseconds(time.Since(startupTime)))
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	statsFileName = "stats.json"
+	statsLockName = "stats.lock"
+
+	// statsVersion is the version of the stats file format. A file with a
+	// newer version is left alone, rather than being rewritten in a format
+	// that would lose what the newer po recorded.
+	statsVersion = 1
+
+	// maxStatsDurations is how many of a command's most recent durations
+	// are kept for its percentiles.
+	maxStatsDurations = 100
+)
+
+type statsFile struct {
+	Version  int                      `json:"version"`
+	Projects map[string]*projectStats `json:"projects"`
+}
+
+type projectStats struct {
+	Commands map[string]*commandStats `json:"commands"`
+}
+
+// commandStats are the runs of a command in a project. Every run is
+// counted, but only runs that po waited for, as a child process, have an
+// exit code and a duration.
+type commandStats struct {
+	Runs        int       `json:"runs"`
+	Finished    int       `json:"finished"`
+	Failures    int       `json:"failures"`
+	TotalMs     int64     `json:"total_ms"`
+	DurationsMs []int64   `json:"durations_ms,omitempty"`
+	LastRun     time.Time `json:"last_run"`
+}
+
+// runStats is a run of a command that's to be added to the stats file.
+type runStats struct {
+	Project string
+	Command string
+	Start   time.Time
+}
+
+func statsPath() string {
+	return filepath.Join(userStateDir(), "po", statsFileName)
+}
+
+// statsProject is the directory of the project config, or the current
+// directory if there isn't one, so that a command run from anywhere in a
+// project is counted as the same command.
+func statsProject() string {
+	if path, err := findProjectConfig(); err == nil && path != "" {
+		return filepath.Dir(path)
+	}
+
+	dir, _ := os.Getwd()
+	return dir
+}
+
+func newRunStats(cmd *cobra.Command) *runStats {
+	return &runStats{
+		Project: statsProject(),
+		Command: commandName(cmd),
+		Start:   time.Now(),
+	}
+}
+
+func readStatsFile(path string) (*statsFile, error) {
+	dat, err := os.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return &statsFile{Version: statsVersion}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var stats statsFile
+
+	if err := json.Unmarshal(dat, &stats); err != nil {
+		return nil, fmt.Errorf("invalid stats file %s: %v", path, err)
+	}
+
+	return &stats, nil
+}
+
+func (stats *statsFile) command(project string, command string) *commandStats {
+	if stats.Projects == nil {
+		stats.Projects = make(map[string]*projectStats)
+	}
+
+	p := stats.Projects[project]
+
+	if p == nil {
+		p = &projectStats{}
+		stats.Projects[project] = p
+	}
+
+	if p.Commands == nil {
+		p.Commands = make(map[string]*commandStats)
+	}
+
+	c := p.Commands[command]
+
+	if c == nil {
+		c = &commandStats{}
+		p.Commands[command] = c
+	}
+
+	return c
+}
+
+func (c *commandStats) add(start time.Time, exitCode *int) {
+	c.Runs++
+	c.LastRun = start
+
+	if exitCode == nil {
+		return
+	}
+
+	ms := time.Since(start).Milliseconds()
+	c.Finished++
+	c.TotalMs += ms
+	c.DurationsMs = append(c.DurationsMs, ms)
+
+	if len(c.DurationsMs) > maxStatsDurations {
+		c.DurationsMs = c.DurationsMs[len(c.DurationsMs)-maxStatsDurations:]
+	}
+
+	if *exitCode != 0 {
+		c.Failures++
+	}
+}
+
+// Record adds the run to the stats file, with its exit code if po waited
+// for it. Like history, stats are a convenience, so any error is ignored.
+func (run *runStats) Record(exitCode *int) {
+	if run == nil {
+		return
+	}
+
+	path := statsPath()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+
+	lock, err := os.OpenFile(filepath.Join(filepath.Dir(path), statsLockName), os.O_CREATE|os.O_RDWR, 0600)
+
+	if err != nil {
+		return
+	}
+
+	defer lock.Close()
+
+	if err := unix.Flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		return
+	}
+
+	defer unix.Flock(int(lock.Fd()), unix.LOCK_UN)
+
+	stats, err := readStatsFile(path)
+
+	if err != nil || stats.Version > statsVersion {
+		return
+	}
+
+	stats.Version = statsVersion
+	stats.command(run.Project, run.Command).add(run.Start, exitCode)
+
+	dat, err := json.Marshal(stats)
+
+	if err != nil {
+		return
+	}
+
+	writeFileAtomic(path, dat, 0600)
+}
+
+// merge adds the runs of the same command in another project.
+func (c *commandStats) merge(other *commandStats) {
+	c.Runs += other.Runs
+	c.Finished += other.Finished
+	c.Failures += other.Failures
+	c.TotalMs += other.TotalMs
+	c.DurationsMs = append(c.DurationsMs, other.DurationsMs...)
+
+	if other.LastRun.After(c.LastRun) {
+		c.LastRun = other.LastRun
+	}
+}
+
+// percentile is the duration that the given percentage of the recorded
+// durations are no longer than.
+func (c *commandStats) percentile(p float64) time.Duration {
+	if len(c.DurationsMs) == 0 {
+		return 0
+	}
+
+	sorted := append([]int64(nil), c.DurationsMs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+
+	if i < 0 {
+		i = 0
+	}
+
+	return time.Duration(sorted[i]) * time.Millisecond
+}
+
+type commandStatsRow struct {
+	Command string
+	Stats   *commandStats
+}
+
+// statsRows are the commands of one project, or of every project added
+// together, with the most used first.
+func statsRows(stats *statsFile, project string, global bool) []commandStatsRow {
+	merged := make(map[string]*commandStats)
+
+	for dir, p := range stats.Projects {
+		if p == nil || (!global && dir != project) {
+			continue
+		}
+
+		for name, c := range p.Commands {
+			if c == nil {
+				continue
+			}
+
+			if merged[name] == nil {
+				merged[name] = &commandStats{}
+			}
+
+			merged[name].merge(c)
+		}
+	}
+
+	rows := make([]commandStatsRow, 0, len(merged))
+
+	for name, c := range merged {
+		rows = append(rows, commandStatsRow{name, c})
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Stats.Runs != rows[j].Stats.Runs {
+			return rows[i].Stats.Runs > rows[j].Stats.Runs
+		}
+		return rows[i].Command < rows[j].Command
+	})
+
+	return rows
+}
+
+func formatStatsDuration(d time.Duration, finished int) string {
+	if finished == 0 {
+		return "-"
+	}
+
+	switch {
+	case d < time.Second:
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	case d < time.Minute:
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	default:
+		return d.Round(time.Second).String()
+	}
+}
+
+func formatSuccessRate(c *commandStats) string {
+	if c.Finished == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d%%", (c.Finished-c.Failures)*100/c.Finished)
+}
+
+func printStats(out io.Writer, rows []commandStatsRow) {
+	padding := minCommandPadding
+
+	for _, row := range rows {
+		if l := displayWidth(row.Command); l > padding {
+			padding = l
+		}
+	}
+
+	fmt.Fprintf(out, "%s  %5s  %7s  %7s  %7s  %7s  %s\n",
+		rightPad("COMMAND", padding), "RUNS", "SUCCESS", "AVG", "P50", "P90", "LAST RUN")
+
+	for _, row := range rows {
+		c := row.Stats
+		var average time.Duration
+
+		if c.Finished > 0 {
+			average = time.Duration(c.TotalMs/int64(c.Finished)) * time.Millisecond
+		}
+
+		fmt.Fprintf(out, "%s  %5d  %7s  %7s  %7s  %7s  %s ago\n",
+			rightPad(row.Command, padding),
+			c.Runs,
+			formatSuccessRate(c),
+			formatStatsDuration(average, c.Finished),
+			formatStatsDuration(c.percentile(50), len(c.DurationsMs)),
+			formatStatsDuration(c.percentile(90), len(c.DurationsMs)),
+			formatAge(time.Since(c.LastRun)))
+	}
+}
+
+func makeStatsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stats [COMMAND]",
+		Short: "Show how often commands are run and how long they take",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			global, err := cmd.Flags().GetBool("global")
+
+			if err != nil {
+				return err
+			}
+
+			stats, err := readStatsFile(statsPath())
+
+			if err != nil {
+				return err
+			}
+
+			if stats.Version > statsVersion {
+				return fmt.Errorf("%s was written by a newer po (stats version %d); run 'po upgrade' to read it",
+					statsPath(), stats.Version)
+			}
+
+			project := statsProject()
+			rows := statsRows(stats, project, global)
+
+			if len(args) > 0 {
+				name := strings.Replace(args[0], " ", ":", -1)
+				var matched []commandStatsRow
+
+				for _, row := range rows {
+					if row.Command == name {
+						matched = append(matched, row)
+					}
+				}
+
+				rows = matched
+			}
+
+			if len(rows) == 0 {
+				what, where := "no commands have", ""
+
+				if len(args) > 0 {
+					what = args[0] + " has not"
+				}
+
+				if !global {
+					where = " in " + project
+				}
+
+				return fmt.Errorf("%s been run%s", what, where)
+			}
+
+			printStats(cmd.OutOrStdout(), rows)
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("global", false, "show runs from every project")
+	return cmd
+}
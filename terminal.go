@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/mattn/go-isatty"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func isTerminal(file *os.File) bool {
+	fd := file.Fd()
+	return isatty.IsTerminal(fd) || isatty.IsCygwinTerminal(fd)
+}
+
+func isTerminalWriter(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	return ok && isTerminal(file)
+}
+
+const noInputFlag = "no-input"
+
+// isInteractive is true if po can prompt the user, and read their answer.
+// It decides whether po asks before trusting an import or for a missing
+// argument, and tells scripts whether they can prompt through
+// PO_INTERACTIVE. --no-input turns prompting off even in a terminal.
+func isInteractive() bool {
+	return isTerminal(os.Stdin) && isTerminal(os.Stderr) && !hasArg("--"+noInputFlag)
+}
+
+func readLine() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	return strings.TrimSpace(line), nil
+}
+
+// confirm asks a yes or no question, giving up on the answer if ctx is
+// cancelled.
+func confirm(ctx context.Context, prompt string) (bool, error) {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", prompt)
+
+	type reply struct {
+		answer string
+		err    error
+	}
+
+	replies := make(chan reply, 1)
+
+	go func() {
+		answer, err := readLine()
+		replies <- reply{answer, err}
+	}()
+
+	var answer string
+
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(os.Stderr)
+		return false, fmt.Errorf("interrupted")
+	case r := <-replies:
+		if r.err != nil {
+			return false, r.err
+		}
+		answer = r.answer
+	}
+
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// choose asks for one of a list of choices from a numbered menu, taking
+// either the number of a choice or the choice itself, and asking again
+// until it gets one.
+func choose(prompt string, choices []string) (string, error) {
+	fmt.Fprintf(os.Stderr, "%s:\n", prompt)
+
+	for i, choice := range choices {
+		fmt.Fprintf(os.Stderr, "  %d) %s\n", i+1, choice)
+	}
+
+	for {
+		fmt.Fprintf(os.Stderr, "Enter 1-%d: ", len(choices))
+		answer, err := readLine()
+
+		if err != nil {
+			return "", err
+		}
+
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(choices) {
+			return choices[n-1], nil
+		}
+
+		if containsString(choices, answer) {
+			return answer, nil
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const testCmdName = "test"
+
+// testCase is a command marked as a test, and why it can't be run here if
+// its conditions aren't met.
+type testCase struct {
+	Name string
+	Skip string
+}
+
+type testResult struct {
+	testCase
+	Code     int
+	Err      error
+	Duration time.Duration
+}
+
+func (r testResult) Passed() bool {
+	return r.Skip == "" && r.Err == nil && r.Code == 0
+}
+
+// testCases finds the commands marked with test: true, in the order they
+// were declared. Marking a parent command marks its subcommands too, so
+// that a group of tests can be marked at once.
+func testCases(commands map[string]Command, names []string, prefix string, marked bool, skip string) []testCase {
+	var cases []testCase
+
+	for _, name := range names {
+		command := commands[name]
+
+		if command.Abstract() {
+			continue
+		}
+
+		fullName := prefix + name
+		isTest := marked || command.Test()
+		reason := skip
+
+		if reason == "" {
+			reason = command.When.unmetReason()
+		}
+
+		if isTest && command.HasScript() {
+			cases = append(cases, testCase{Name: fullName, Skip: reason})
+		}
+
+		cases = append(cases, testCases(command.Commands, command.CommandNames(), fullName+":", isTest, reason)...)
+	}
+
+	return cases
+}
+
+func filterTestCases(cases []testCase, filter string) []testCase {
+	var filtered []testCase
+
+	for _, tc := range cases {
+		if strings.Contains(spacedName(tc.Name), filter) {
+			filtered = append(filtered, tc)
+		}
+	}
+
+	return filtered
+}
+
+// testArgs runs a test through po run, so that it's always the command
+// from the config that runs.
+func testArgs(executable string, name string) []string {
+	return append([]string{executable, runCmdName}, strings.Split(name, ":")...)
+}
+
+// runTestsInOrder runs each test in turn, with its output going straight
+// to the terminal.
+func runTestsInOrder(executable string, cases []testCase) []testResult {
+	results := make([]testResult, len(cases))
+	bold := color.New(color.Bold)
+
+	for i, tc := range cases {
+		results[i].testCase = tc
+
+		if tc.Skip != "" {
+			continue
+		}
+
+		bold.Fprintf(os.Stderr, "=== po %s\n", spacedName(tc.Name))
+		start := time.Now()
+		results[i].Code, results[i].Err = runProcess(testArgs(executable, tc.Name), os.Environ())
+		results[i].Duration = time.Since(start)
+	}
+
+	return results
+}
+
+// runTestsInParallel runs the tests at the same time, as many as there are
+// CPUs, and prints the output of each as it finishes, so that the output
+// of different tests isn't mixed together.
+func runTestsInParallel(executable string, cases []testCase) []testResult {
+	results := make([]testResult, len(cases))
+	semaphore := make(chan struct{}, runtime.NumCPU())
+	bold := color.New(color.Bold)
+
+	var wg sync.WaitGroup
+	var outputMutex sync.Mutex
+
+	for i, tc := range cases {
+		results[i].testCase = tc
+
+		if tc.Skip != "" {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(result *testResult) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			var output bytes.Buffer
+			args := testArgs(executable, result.Name)
+			cmd := exec.Command(args[0], args[1:]...)
+			cmd.Stdout = &output
+			cmd.Stderr = &output
+
+			start := time.Now()
+			result.Code, result.Err = processExitCode(cmd.Run())
+			result.Duration = time.Since(start)
+
+			outputMutex.Lock()
+			defer outputMutex.Unlock()
+
+			bold.Fprintf(os.Stderr, "=== po %s\n", spacedName(result.Name))
+			os.Stderr.Write(output.Bytes())
+		}(&results[i])
+	}
+
+	wg.Wait()
+	return results
+}
+
+// printTestSummary prints a line for each test, and how many passed,
+// failed and were skipped. Like the timing summary, it goes to stderr.
+func printTestSummary(out io.Writer, results []testResult) (passed int, failed int) {
+	green := color.New(color.Bold, color.FgGreen)
+	red := color.New(color.Bold, color.FgRed)
+	dim := color.New(color.Faint)
+	padding := 0
+	skipped := 0
+
+	for _, r := range results {
+		if l := displayWidth(spacedName(r.Name)); l > padding {
+			padding = l
+		}
+	}
+
+	fmt.Fprintln(out)
+
+	for _, r := range results {
+		name := rightPad(spacedName(r.Name), padding)
+		duration := r.Duration.Round(time.Millisecond)
+
+		switch {
+		case r.Skip != "":
+			skipped++
+			dim.Fprintf(out, "SKIP  %s  %s\n", name, r.Skip)
+		case r.Err != nil:
+			failed++
+			red.Fprint(out, "FAIL")
+			fmt.Fprintf(out, "  %s  %v\n", name, r.Err)
+		case r.Code != 0:
+			failed++
+			red.Fprint(out, "FAIL")
+			fmt.Fprintf(out, "  %s  %v (exit %d)\n", name, duration, r.Code)
+		default:
+			passed++
+			green.Fprint(out, "PASS")
+			fmt.Fprintf(out, "  %s  %v\n", name, duration)
+		}
+	}
+
+	fmt.Fprintf(out, "\n%d passed, %d failed, %d skipped\n", passed, failed, skipped)
+	return passed, failed
+}
+
+// makeTestCommand makes po test, which runs the commands marked as tests.
+// A command in the config called test takes its place, so that configs
+// that already have one keep working.
+func makeTestCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   testCmdName,
+		Short: "Run the commands marked as tests",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			parallel, err := cmd.Flags().GetBool("parallel")
+
+			if err != nil {
+				return err
+			}
+
+			filter, err := cmd.Flags().GetString("filter")
+
+			if err != nil {
+				return err
+			}
+
+			cases := testCases(config.Commands, config.CommandNames(), "", false, "")
+
+			if len(cases) == 0 {
+				return fmt.Errorf("no commands are marked with test: true")
+			}
+
+			if filter != "" {
+				if cases = filterTestCases(cases, filter); len(cases) == 0 {
+					return fmt.Errorf("no tests match '%s'", filter)
+				}
+			}
+
+			executable, err := os.Executable()
+
+			if err != nil {
+				return err
+			}
+
+			var results []testResult
+
+			if parallel {
+				results = runTestsInParallel(executable, cases)
+			} else {
+				results = runTestsInOrder(executable, cases)
+			}
+
+			passed, failed := printTestSummary(cmd.ErrOrStderr(), results)
+
+			if failed > 0 {
+				return fmt.Errorf("%d of %d tests failed", failed, passed+failed)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("parallel", false, "run the tests at the same time")
+	cmd.Flags().String("filter", "", "only run tests whose names contain this")
+	return cmd
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMergeCanUnmarkTests(t *testing.T) {
+	config := mustParseConfig(t, `
+commands:
+  style:
+    test: true
+    script: echo style
+  unit:
+    test: true
+    script: echo unit
+`)
+	config.Merge(mustParseConfig(t, "commands:\n  style:\n    test: false\n  unit:\n    short: Runs the unit tests\n"))
+
+	cases := testCases(config.Commands, config.CommandNames(), "", false, "")
+
+	if len(cases) != 1 || cases[0].Name != "unit" {
+		t.Errorf("expected test: false in a later layer to unmark style only, got %v", cases)
+	}
+}
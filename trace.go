@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fatih/color"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	verboseEnvVar = "PO_VERBOSE"
+	verboseFlag   = "verbose"
+)
+
+// minSecretLength stops short values, like "1" or "yes", from being
+// masked wherever they happen to appear in a trace message.
+const minSecretLength = 4
+
+var (
+	traceEnabled = parseBool(os.Getenv(verboseEnvVar)) || hasArg("--"+verboseFlag)
+	traceMutex   sync.Mutex
+	secretValues []string
+)
+
+var urlPasswordRegexp = regexp.MustCompile(`(://[^:/@\s]+):[^@/\s]+@`)
+
+// addSecrets records the values of sensitive variables in env, so that
+// they're masked if they turn up in a trace message.
+func addSecrets(env map[string]string) {
+	traceMutex.Lock()
+	defer traceMutex.Unlock()
+
+	for k, v := range env {
+		if isSensitiveEnvVar(k) && len(v) >= minSecretLength {
+			secretValues = append(secretValues, v)
+		}
+	}
+}
+
+func environSecrets() map[string]string {
+	env := make(map[string]string)
+
+	for _, kv := range os.Environ() {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	return env
+}
+
+func maskSecrets(s string) string {
+	s = urlPasswordRegexp.ReplaceAllString(s, "$1:****@")
+
+	for _, secret := range secretValues {
+		s = strings.Replace(s, secret, "****", -1)
+	}
+
+	return s
+}
+
+// tracef prints a line about what po is doing to stderr when verbose mode
+// is on. It's safe to call from concurrent imports.
+func tracef(format string, a ...interface{}) {
+	if !traceEnabled {
+		return
+	}
+
+	traceMutex.Lock()
+	defer traceMutex.Unlock()
+
+	dim := color.New(color.Faint)
+	dim.Fprint(os.Stderr, "po: ")
+	fmt.Fprintln(os.Stderr, maskSecrets(fmt.Sprintf(format, a...)))
+}
+
+func init() {
+	if traceEnabled {
+		addSecrets(environSecrets())
+	}
+}
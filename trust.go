@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	trustFileName       = "trusted.yml"
+	trustImportsEnvVar  = "PO_TRUST_IMPORTS"
+	trustAllFlag        = "trust-all"
+	trustHashPrefixSize = 12
+)
+
+type trustStore struct {
+	Imports map[string]string
+}
+
+func trustStorePath() string {
+	if userConfigDir() == "" {
+		return filepath.Join(fallbackDir(), trustFileName)
+	}
+	return filepath.Join(userConfigDir(), "po", trustFileName)
+}
+
+func readTrustStore() (*trustStore, error) {
+	store := trustStore{Imports: make(map[string]string)}
+	dat, err := os.ReadFile(trustStorePath())
+
+	if os.IsNotExist(err) {
+		return &store, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(dat, &store); err != nil {
+		return nil, fmt.Errorf("cannot read %s: %v", trustStorePath(), err)
+	}
+
+	if store.Imports == nil {
+		store.Imports = make(map[string]string)
+	}
+
+	return &store, nil
+}
+
+func (store *trustStore) Write() error {
+	dat, err := yaml.Marshal(store)
+
+	if err != nil {
+		return err
+	}
+
+	path := trustStorePath()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, dat, 0644)
+}
+
+func hasArg(name string) bool {
+	for _, arg := range os.Args[1:] {
+		if arg == "--" {
+			return false
+		}
+		if arg == name {
+			return true
+		}
+	}
+	return false
+}
+
+func trustAllImports() bool {
+	return parseBool(os.Getenv(trustImportsEnvVar)) || hasArg("--"+trustAllFlag)
+}
+
+var trustMutex sync.Mutex
+
+func checkImportTrust(ctx context.Context, url string, dat []byte) error {
+	return checkUrlTrust(ctx, "import", url, dat)
+}
+
+// checkScriptTrust checks a script downloaded for a command, which runs
+// as surely as the commands of an import do. Its hash is kept with those
+// of trusted imports.
+func checkScriptTrust(ctx context.Context, url string, dat []byte) error {
+	return checkUrlTrust(ctx, "script", url, dat)
+}
+
+// checkUrlTrust asks whether to trust something fetched from a URL, if
+// it's new or has changed since it was last trusted.
+func checkUrlTrust(ctx context.Context, kind string, url string, dat []byte) error {
+	if trustAllImports() {
+		return nil
+	}
+
+	trustMutex.Lock()
+	defer trustMutex.Unlock()
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted before trusting %s", url)
+	}
+
+	store, err := readTrustStore()
+
+	if err != nil {
+		return err
+	}
+
+	sum := sha256HexString(dat)
+
+	if store.Imports[url] == sum {
+		return nil
+	}
+
+	if !isInteractive() {
+		return fmt.Errorf("%s %s (sha256 %s) has not been trusted; "+
+			"run po in a terminal to review it, or set %s=1 or use --%s",
+			kind, url, sum, trustImportsEnvVar, trustAllFlag)
+	}
+
+	prompt := fmt.Sprintf("Trust %s %s (sha256 %s…)?", kind, url, sum[:trustHashPrefixSize])
+
+	if _, ok := store.Imports[url]; ok {
+		prompt = fmt.Sprintf("The %s %s has changed (sha256 %s…). Trust it?", kind, url, sum[:trustHashPrefixSize])
+	}
+
+	ok, err := confirm(ctx, prompt)
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("%s %s was not trusted", kind, url)
+	}
+
+	store.Imports[url] = sum
+	return store.Write()
+}
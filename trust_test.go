@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// serveScript serves a script over HTTP, and points po's cache and config
+// at temporary directories.
+func serveScript(t *testing.T, script string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, script)
+	}))
+
+	t.Cleanup(server.Close)
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	tempCacheDir(t)
+
+	return server.URL + "/release.sh"
+}
+
+func TestScriptUrlMismatchIsNotCached(t *testing.T) {
+	url := serveScript(t, "echo release\n")
+	t.Setenv(trustImportsEnvVar, "1")
+
+	if _, err := readScriptUrl(url, "0000"); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+
+	if dat, err := readUrlCache(url); err != nil || dat != nil {
+		t.Errorf("expected nothing to be cached, got %q (%v)", dat, err)
+	}
+
+	sum := sha256HexString([]byte("echo release\n"))
+
+	if script, err := readScriptUrl(url, sum); err != nil || script != "echo release\n" {
+		t.Fatalf("expected the script, got %q (%v)", script, err)
+	}
+
+	if dat, _ := readUrlCache(url); string(dat) != "echo release\n" {
+		t.Errorf("expected a script that matches to be cached, got %q", dat)
+	}
+}
+
+func TestScriptUrlMustBeTrusted(t *testing.T) {
+	url := serveScript(t, "echo release\n")
+	t.Setenv(trustImportsEnvVar, "")
+
+	// Without a terminal po can't ask, so it refuses.
+	stdin := os.Stdin
+	devNull, err := os.Open(os.DevNull)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Stdin = devNull
+	t.Cleanup(func() { os.Stdin = stdin; devNull.Close() })
+
+	_, err = readScriptUrl(url, "")
+
+	if err == nil || !strings.Contains(err.Error(), "script "+url+" (sha256") {
+		t.Errorf("expected the script to need trusting, got %v", err)
+	}
+}
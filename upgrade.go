@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const (
+	updateUrlEnvVar   = "PO_UPDATE_URL"
+	defaultUpdateUrl  = "https://api.github.com/repos/weavejester/po/releases/latest"
+	checksumsFileName = "checksums.txt"
+)
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	Url  string `json:"browser_download_url"`
+}
+
+type release struct {
+	TagName string         `json:"tag_name"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+func (r *release) Version() string {
+	return strings.TrimPrefix(r.TagName, "v")
+}
+
+func (r *release) asset(name string) (*releaseAsset, error) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return &a, nil
+		}
+	}
+	return nil, fmt.Errorf("release %s has no %s", r.TagName, name)
+}
+
+// releaseAssetName is the name of the binary for this platform, such as
+// po_linux_amd64.
+func releaseAssetName() string {
+	name := fmt.Sprintf("po_%s_%s", runtime.GOOS, runtime.GOARCH)
+
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	return name
+}
+
+func updateUrl() string {
+	if url := os.Getenv(updateUrlEnvVar); url != "" {
+		return url
+	}
+	return defaultUpdateUrl
+}
+
+func fetchLatestRelease() (*release, error) {
+	dat, err := fetchUrlWithRetries(httpClient, updateUrl())
+
+	if err != nil {
+		return nil, err
+	}
+
+	var r release
+
+	if err := json.Unmarshal(dat, &r); err != nil {
+		return nil, fmt.Errorf("cannot read release from %s: %v", updateUrl(), err)
+	}
+
+	if r.TagName == "" {
+		return nil, fmt.Errorf("no release version found at %s", updateUrl())
+	}
+
+	return &r, nil
+}
+
+// releaseChecksum finds the SHA-256 of a file in a checksums file written
+// by sha256sum.
+func releaseChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+
+	return "", fmt.Errorf("no checksum for %s in %s", name, checksumsFileName)
+}
+
+// downloadFile streams a download into a file, as a binary can be larger
+// than fetchUrl allows, and returns its SHA-256.
+func downloadFile(url string, file *os.File) (string, error) {
+	if offlineMode() {
+		return "", fmt.Errorf("offline mode: cannot fetch %s", url)
+	}
+
+	// The download can take longer than the timeout for imports.
+	client := &http.Client{Transport: httpClient.Transport}
+	resp, err := client.Get(url)
+
+	if err != nil {
+		return "", fetchError(client, url, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &httpStatusError{Url: url, Status: resp.Status, Code: resp.StatusCode}
+	}
+
+	hash := sha256.New()
+
+	if _, err := io.Copy(io.MultiWriter(file, hash), resp.Body); err != nil {
+		return "", fetchError(client, url, err)
+	}
+
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+func executablePath() (string, error) {
+	path, err := os.Executable()
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.EvalSymlinks(path)
+}
+
+// installRelease downloads the binary to a temporary file beside the
+// running executable, then renames it into place, so that po is never left
+// half written.
+func installRelease(r *release, exe string) error {
+	name := releaseAssetName()
+	binary, err := r.asset(name)
+
+	if err != nil {
+		return err
+	}
+
+	checksumsAsset, err := r.asset(checksumsFileName)
+
+	if err != nil {
+		return err
+	}
+
+	checksums, err := fetchUrlWithRetries(httpClient, checksumsAsset.Url)
+
+	if err != nil {
+		return err
+	}
+
+	want, err := releaseChecksum(checksums, name)
+
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(exe), ".po-upgrade-")
+
+	if err != nil {
+		return fmt.Errorf("cannot write to %s; if po was installed by a package manager, use it to upgrade po instead", filepath.Dir(exe))
+	}
+
+	tempPath := file.Name()
+	defer os.Remove(tempPath)
+
+	got, err := downloadFile(binary.Url, file)
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if got != want {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", name, want, got)
+	}
+
+	if err := os.Chmod(tempPath, 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, exe)
+}
+
+func makeUpgradeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "Upgrade po to the latest release",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			check, err := cmd.Flags().GetBool("check")
+
+			if err != nil {
+				return err
+			}
+
+			if offlineMode() {
+				return fmt.Errorf("cannot check for a new release while offline")
+			}
+
+			current := cmd.Root().Version
+			latest, err := fetchLatestRelease()
+
+			if err != nil {
+				return err
+			}
+
+			if compareVersions(parseVersion(latest.Version()), parseVersion(current)) <= 0 {
+				fmt.Fprintf(cmd.OutOrStdout(), "po %s is the latest version\n", current)
+				return nil
+			}
+
+			if check {
+				fmt.Fprintf(cmd.OutOrStdout(), "po %s is available (installed: %s)\n", latest.Version(), current)
+				return nil
+			}
+
+			exe, err := executablePath()
+
+			if err != nil {
+				return err
+			}
+
+			tracef("upgrading %s to %s", exe, latest.TagName)
+
+			if err := installRelease(latest, exe); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Upgraded po from %s to %s\n", current, latest.Version())
+			return nil
+		},
+	}
+
+	cmd.Flags().Bool("check", false, "only report whether a newer version is available")
+	return cmd
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// checkGolden compares output with a golden file in testdata, or writes
+// the file with -update.
+func checkGolden(t *testing.T, name string, output string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+
+	if *updateGolden {
+		if err := os.WriteFile(path, []byte(output), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	golden, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output != string(golden) {
+		t.Errorf("output differs from %s\nexpected:\n%s\ngot:\n%s", path, golden, output)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	config := mustParseConfig(t, `
+commands:
+  build:
+    short: Builds the project
+    args:
+      - var: target
+        desc: What to build
+    flags:
+      release:
+        type: bool
+        desc: Build for release
+    script: echo build $target
+  db:
+    short: Database tasks
+    commands:
+      migrate:
+        short: Runs migrations
+        script: echo migrate
+      seed:
+        short: Seeds the database
+        script: echo seed
+  check:
+    short: Runs the checks
+    script: echo check
+    commands:
+      unit:
+        short: Runs the unit tests
+        script: echo unit
+`)
+
+	for _, test := range []struct {
+		name    string
+		command string
+	}{
+		{"script-only", "build"},
+		{"children-only", "db"},
+		{"mixed", "check"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			checkGolden(t, filepath.Join("usage", test.name), runTestCommand(t, config, "help", test.command))
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"sort"
+)
+
+const validateCmdName = "validate"
+
+// reservedCommandNames are the names of po's built-in commands. A command
+// in a config can't have one of these names, as the built-in command would
+// hide it.
+var reservedCommandNames = []string{
+	"alias", "cache", completionCmdName, doctorCmdName, "docs", envCmdName,
+	examplesCmdName, "export", "freeze", graphCmdName, helpCmdName,
+	"history", "info", listCmdName, "lint", "logs", "ps", "rerun",
+	runCmdName, "schema", "stats", "stop", testCmdName, "upgrade",
+	validateCmdName,
+}
+
+func validateReservedName(name string) error {
+	if containsString(reservedCommandNames, name) {
+		return fmt.Errorf("command %s has the same name as the built-in po %s; "+
+			"rename it, and add an alias if a shorter name is wanted", name, name)
+	}
+	return nil
+}
+
+func isBuiltinCommand(root *cobra.Command, config *Config, name string) bool {
+	if _, ok := config.Commands[name]; ok {
+		return false
+	}
+
+	for _, cmd := range root.Commands() {
+		if cmd.Name() == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// aliasProblems finds aliases that can't work as intended: those with the
+// same name as a command, those whose command doesn't exist, and those
+// replaced by an alias of the same name from another config.
+func aliasProblems(config *Config, root *cobra.Command) []string {
+	var problems []string
+
+	for _, name := range sortedKeys(config.Aliases) {
+		target := config.Aliases[name]
+
+		if _, ok := config.Commands[name]; ok {
+			problems = append(problems, fmt.Sprintf(
+				"alias %s (for %s) has the same name as a command", name, target))
+		} else if isBuiltinCommand(root, config, name) {
+			problems = append(problems, fmt.Sprintf(
+				"alias %s (for %s) has the same name as a built-in command", name, target))
+		}
+
+		if command, _, err := findCommand(config, target); err != nil {
+			problems = append(problems, fmt.Sprintf(
+				"alias %s is for %s, which does not exist", name, target))
+		} else if command.Abstract() {
+			problems = append(problems, fmt.Sprintf(
+				"alias %s is for %s, which is abstract", name, target))
+		}
+	}
+
+	shadowed := config.shadowedAliases
+
+	sort.SliceStable(shadowed, func(i, j int) bool {
+		return shadowed[i].Name < shadowed[j].Name
+	})
+
+	for _, s := range shadowed {
+		problems = append(problems, fmt.Sprintf(
+			"alias %s is defined in both %s and %s; the one in %s is used",
+			s.Name, s.Shadowed, s.Source, s.Source))
+	}
+
+	return problems
+}
+
+const lenientFlag = "lenient"
+
+// isEmpty is true if a command has nothing to run and no subcommands, so
+// that all it can do is print its help. This usually means its script is
+// indented under the wrong key.
+func (cmd *Command) isEmpty() bool {
+	return !cmd.HasScript() && len(cmd.Commands) == 0 && len(cmd.Imports) == 0 && !cmd.Abstract()
+}
+
+func emptyCommandProblems(config *Config) []string {
+	var problems []string
+	var visit func(prefix string, source string, commands map[string]Command)
+
+	visit = func(prefix string, source string, commands map[string]Command) {
+		for _, name := range sortedCommandNames(commands) {
+			command := commands[name]
+			fullName := prefix + name
+
+			if prefix == "" {
+				source = config.commandSources[name]
+			}
+
+			if command.isEmpty() {
+				msg := fmt.Sprintf("command %s has no script, script_file or subcommands", fullName)
+
+				if source != "" {
+					msg = fmt.Sprintf("command %s in %s has no script, script_file or subcommands", fullName, source)
+				}
+
+				problems = append(problems, msg+"; check the indentation of its keys")
+			}
+
+			visit(fullName+" ", source, command.Commands)
+		}
+	}
+
+	visit("", "", config.Commands)
+	return problems
+}
+
+// checkEmptyCommands fails if a command has nothing to run, unless po
+// validate is being run, or --lenient is given to make it a warning.
+func checkEmptyCommands(config *Config, args []string) error {
+	problems := emptyCommandProblems(config)
+
+	if len(problems) == 0 || invokesCommand(args, validateCmdName) || hasArg("--"+lenientFlag) {
+		return nil
+	}
+
+	return fmt.Errorf("%s (or run with --%s to only warn about it)", problems[0], lenientFlag)
+}
+
+func configProblems(config *Config, root *cobra.Command) []string {
+	problems := append(aliasProblems(config, root), finalProblems(config)...)
+	problems = append(problems, emptyCommandProblems(config)...)
+
+	for _, s := range config.shadowedCommands {
+		problems = append(problems, fmt.Sprintf(
+			"command %s is defined in both %s and %s; the one in %s is used",
+			s.Name, s.Shadowed, s.Source, s.Source))
+	}
+
+	return problems
+}
+
+// warnConfigProblems prints problems with the config as warnings, unless
+// po validate is being run, as it reports them itself.
+func warnConfigProblems(config *Config, root *cobra.Command, args []string) {
+	if cmd, _, err := root.Find(args); err == nil && cmd.Name() == validateCmdName {
+		return
+	}
+
+	for _, problem := range configProblems(config, root) {
+		printWarning("%s", problem)
+	}
+}
+
+func makeValidateCommand(config *Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   validateCmdName,
+		Short: "Check the configuration for problems",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			target, err := cmd.Flags().GetInt(targetVersionFlag)
+
+			if err != nil {
+				return err
+			}
+
+			if cmd.Flags().Changed(targetVersionFlag) && target < 1 {
+				return usageError{fmt.Errorf("invalid --%s %d (must be at least 1)", targetVersionFlag, target)}
+			}
+
+			problems := configProblems(config, cmd.Root())
+
+			if configLoadError != nil {
+				problems = append([]string{configLoadError.Error()}, problems...)
+			}
+
+			if target > 0 {
+				problems = append(problems, targetVersionProblems(target)...)
+			}
+
+			for _, problem := range problems {
+				fmt.Fprintln(cmd.OutOrStdout(), problem)
+			}
+
+			if len(problems) == 1 {
+				return fmt.Errorf("found 1 problem")
+			} else if len(problems) > 1 {
+				return fmt.Errorf("found %d problems", len(problems))
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().Int(targetVersionFlag, 0, "also report what would stop the configs working with this config version")
+	return cmd
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReservedCommandNames(t *testing.T) {
+	for _, name := range []string{"help", "completion", "lint", "test", "validate"} {
+		_, err := parseConfig([]byte("commands:\n  " + name + ":\n    script: echo " + name + "\n"))
+
+		if err == nil || !strings.Contains(err.Error(), "built-in po "+name) {
+			t.Errorf("expected a command named %s to be rejected, got %v", name, err)
+		}
+	}
+
+	config := mustParseConfig(t, `
+commands:
+  check:
+    commands:
+      lint:
+        script: echo lint
+`)
+
+	root := newRootCommand()
+
+	if err := addCommands(root, config, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if cmd, _, err := root.Find([]string{"lint"}); err != nil || isConfigCommand(cmd) {
+		t.Errorf("expected po lint to be the built-in command, got %v", err)
+	}
+}
+
+func mustParseConfig(t testing.TB, yml string) *Config {
+	t.Helper()
+	config, err := parseConfig([]byte(yml))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return config
+}
+
+func TestEmptyCommandProblems(t *testing.T) {
+	config := mustParseConfig(t, `
+commands:
+  empty:
+    short: Does nothing
+  linux:
+    script_linux: echo linux
+  remote:
+    script_url: https://example.com/run.sh
+  group:
+    commands:
+      sub:
+        script: echo sub
+`)
+
+	problems := emptyCommandProblems(config)
+
+	if len(problems) != 1 || !strings.Contains(problems[0], "command empty ") {
+		t.Errorf("expected a problem with empty only, got %q", problems)
+	}
+}
+
+func TestValidateImportTimeout(t *testing.T) {
+	for timeout, valid := range map[string]bool{"30s": true, "0s": true, "-5s": false, "soon": false} {
+		if _, err := parseConfig([]byte("import_timeout: " + timeout + "\n")); (err == nil) != valid {
+			t.Errorf("%s: expected valid to be %v, got %v", timeout, valid, err)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"regexp"
+)
+
+var varRefRegexp = regexp.MustCompile(`\{\{\s*vars\.([\w-]+)\s*\}\}|\$\{vars\.([\w-]+)\}`)
+
+// substituteVars replaces references to vars, written as either
+// '{{ vars.name }}' or '${vars.name}'. The location describes where the
+// text came from, for the error when a var isn't defined.
+func substituteVars(s string, vars map[string]string, location string) (string, error) {
+	var err error
+
+	result := varRefRegexp.ReplaceAllStringFunc(s, func(ref string) string {
+		match := varRefRegexp.FindStringSubmatch(ref)
+		name := match[1] + match[2]
+
+		if value, ok := vars[name]; ok {
+			return value
+		}
+
+		if err == nil {
+			err = fmt.Errorf("undefined var '%s' in %s", name, location)
+		}
+
+		return ref
+	})
+
+	return result, err
+}
+
+func substituteVarsInEnv(env map[string]string, order []string, vars map[string]string, location func(string) string) error {
+	for _, k := range orderedEnvNames(env, order) {
+		v := env[k]
+		value, err := substituteVars(v, vars, location("environment variable "+k))
+
+		if err != nil {
+			return err
+		}
+
+		env[k] = value
+	}
+
+	return nil
+}
+
+func scopeVars(parent map[string]string, vars map[string]string) map[string]string {
+	scope := make(map[string]string)
+	mergeStringMaps(scope, parent)
+	mergeStringMaps(scope, vars)
+	return scope
+}
+
+func (cmd *Command) substituteVars(name string, vars map[string]string) error {
+	vars = scopeVars(vars, cmd.Vars)
+	where := func(part string) string {
+		return fmt.Sprintf("%s of command %s", part, name)
+	}
+
+	texts := []struct {
+		field *string
+		part  string
+	}{
+		{&cmd.Script, "script"},
+		{&cmd.ScriptDarwin, "script_darwin"},
+		{&cmd.ScriptLinux, "script_linux"},
+		{&cmd.ScriptWindows, "script_windows"},
+		{&cmd.Example, "example"},
+	}
+
+	for _, text := range texts {
+		value, err := substituteVars(*text.field, vars, where(text.part))
+
+		if err != nil {
+			return err
+		}
+
+		*text.field = value
+	}
+
+	examples := append([]CommandExample(nil), cmd.Examples...)
+
+	for i := range examples {
+		for _, field := range []*string{&examples[i].Desc, &examples[i].Cmd} {
+			value, err := substituteVars(*field, vars, where("examples"))
+
+			if err != nil {
+				return err
+			}
+
+			*field = value
+		}
+	}
+
+	cmd.Examples = examples
+
+	if err := substituteVarsInEnv(cmd.Environment, cmd.envOrder, vars, where); err != nil {
+		return err
+	}
+
+	for _, flagName := range cmd.FlagNames() {
+		flag := cmd.Flags[flagName]
+		value, err := substituteVars(flag.Default, vars, where("default of flag "+flagName))
+
+		if err != nil {
+			return err
+		}
+
+		flag.Default = value
+		cmd.Flags[flagName] = flag
+	}
+
+	for _, subName := range cmd.CommandNames() {
+		subCmd := cmd.Commands[subName]
+
+		if err := subCmd.substituteVars(name+":"+subName, vars); err != nil {
+			return err
+		}
+
+		cmd.Commands[subName] = subCmd
+	}
+
+	return nil
+}
+
+// resolveVars substitutes vars throughout the config. It runs once all the
+// configs are merged, so that a project can override the vars of the
+// configs it imports, and a command's vars apply to its subcommands.
+func resolveVars(config *Config) error {
+	topLevel := func(part string) string { return part }
+
+	if err := substituteVarsInEnv(config.Environment, config.envOrder, config.Vars, topLevel); err != nil {
+		return err
+	}
+
+	prelude, err := substituteVars(config.Prelude, config.Vars, "prelude")
+
+	if err != nil {
+		return err
+	}
+
+	config.Prelude = prelude
+
+	for _, name := range config.CommandNames() {
+		cmd := config.Commands[name]
+
+		if err := cmd.substituteVars(name, config.Vars); err != nil {
+			return err
+		}
+
+		config.Commands[name] = cmd
+	}
+
+	return nil
+}
+
+func makeInfoCommand(config *Config) *cobra.Command {
+	return &cobra.Command{
+		Use:   "info",
+		Short: "Print the loaded configs and their resolved vars",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out := cmd.OutOrStdout()
+
+			for _, path := range systemConfigFiles {
+				fmt.Fprintf(out, "%-15s %s\n", "system config:", path)
+			}
+
+			for _, file := range []struct{ label, envVar string }{
+				{"user config", poConfigFileEnvVar},
+				{"project config", poProjectFileEnvVar},
+			} {
+				path := os.Getenv(file.envVar)
+
+				if path == "" {
+					path = "(none)"
+				}
+
+				fmt.Fprintf(out, "%-15s %s\n", file.label+":", path)
+			}
+
+			if finals := finalCommands(config); len(finals) > 0 {
+				fmt.Fprintln(out, "\nfinal commands:")
+				padding := 0
+
+				for _, final := range finals {
+					if l := displayWidth(final.Name); l > padding {
+						padding = l
+					}
+				}
+
+				for _, final := range finals {
+					fmt.Fprintf(out, "  %s  %s\n", rightPad(final.Name, padding), final.Source)
+				}
+			}
+
+			if len(config.Vars) == 0 {
+				return nil
+			}
+
+			fmt.Fprintln(out, "\nvars:")
+			padding := 0
+
+			for name := range config.Vars {
+				if l := displayWidth(name); l > padding {
+					padding = l
+				}
+			}
+
+			for _, name := range sortedKeys(config.Vars) {
+				fmt.Fprintf(out, "  %s  %s\n", rightPad(name, padding), escapeControl(config.Vars[name]))
+			}
+
+			return nil
+		},
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestProjectVarsOverrideImportedVars(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "po.yml")
+
+	writeTestFile(t, path, `
+imports:
+  - file: shared.yml
+vars:
+  x: proj
+commands:
+  show:
+    script: echo ${vars.x} ${vars.y}
+`)
+
+	writeTestFile(t, filepath.Join(dir, "shared.yml"), `
+vars:
+  x: imp
+  y: imp
+`)
+
+	config, err := readConfigFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loadAllImports(context.Background(), config, path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := resolveVars(config); err != nil {
+		t.Fatal(err)
+	}
+
+	if x := config.Vars["x"]; x != "proj" {
+		t.Errorf("expected the project's var to be kept, got %s", x)
+	}
+
+	if y := config.Vars["y"]; y != "imp" {
+		t.Errorf("expected a var only set by the import to be used, got %s", y)
+	}
+
+	if script := config.Commands["show"].Script; script != "echo proj imp" {
+		t.Errorf("expected the script to use the project's var, got %q", script)
+	}
+}
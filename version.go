@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// configVersion is the newest major version of the config format that
+// this po understands. A config without a version key is version 1.
+const configVersion = 1
+
+const targetVersionFlag = "target-version"
+
+var versionLine = regexp.MustCompile(`(?m)^version:[ \t]*["']?([0-9][0-9.]*)`)
+
+// configDeprecation is a construct from an older version of the config
+// format that still works, but should be migrated before the version it's
+// removed in. Find returns a description of each place a config uses it.
+type configDeprecation struct {
+	Removed int
+	Migrate string
+	Find    func(config *Config) []string
+}
+
+// configDeprecations are checked in every config po reads. Version 1 is
+// the first version of the format, so nothing is deprecated yet.
+var configDeprecations []configDeprecation
+
+// deprecationUse is a deprecated construct found in a config.
+type deprecationUse struct {
+	Location string
+	Where    string
+	Removed  int
+	Migrate  string
+}
+
+func (use deprecationUse) String() string {
+	return fmt.Sprintf("%s: %s is deprecated, and is removed in config version %d; %s",
+		use.Location, use.Where, use.Removed, use.Migrate)
+}
+
+var (
+	configVersions      = make(map[string]int)
+	deprecationUses     []deprecationUse
+	configVersionsMutex sync.Mutex
+)
+
+// configVersionError is a config whose version po can't read, found
+// before the rest of the config is parsed.
+type configVersionError struct {
+	Message string
+}
+
+func (err *configVersionError) Error() string {
+	return err.Message
+}
+
+// parseConfigVersion finds the major version of a version key, which can
+// be written as 1 or as 1.2.
+func parseConfigVersion(version string) (int, error) {
+	if version == "" {
+		return 1, nil
+	}
+
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+
+	if err != nil || major < 1 {
+		return 0, fmt.Errorf("invalid version '%s' (must be a number such as %d)", version, configVersion)
+	}
+
+	return major, nil
+}
+
+// checkConfigVersion reads only the version of a config, before the rest
+// of it is parsed, so that a config for a newer po is reported as such
+// rather than as whatever parts of it this po can't read.
+func checkConfigVersion(dat []byte) error {
+	var header struct {
+		Version string
+	}
+
+	// A config that isn't valid YAML to this po could still be valid to
+	// a newer one, so the version is looked for on its own line too.
+	if yaml.Unmarshal(dat, &header) != nil {
+		m := versionLine.FindSubmatch(dat)
+
+		if m == nil {
+			return nil
+		}
+
+		header.Version = string(m[1])
+	}
+
+	major, err := parseConfigVersion(header.Version)
+
+	if err != nil {
+		return &configVersionError{err.Error()}
+	}
+
+	if major > configVersion {
+		return &configVersionError{fmt.Sprintf("config is for version %d of the config format, but "+
+			"this po only understands up to version %d; run 'po upgrade' to get a newer po",
+			major, configVersion)}
+	}
+
+	return nil
+}
+
+// recordConfigVersion records the version of a config that was read, and
+// any deprecated constructs it uses.
+func recordConfigVersion(location string, config *Config) {
+	major, _ := parseConfigVersion(config.Version)
+
+	configVersionsMutex.Lock()
+	defer configVersionsMutex.Unlock()
+
+	configVersions[location] = major
+
+	for _, d := range configDeprecations {
+		for _, where := range d.Find(config) {
+			deprecationUses = append(deprecationUses, deprecationUse{location, where, d.Removed, d.Migrate})
+		}
+	}
+}
+
+// deprecationWarnings are the deprecated constructs found in the configs,
+// each given once, however many times its config was read.
+func deprecationWarnings() []string {
+	configVersionsMutex.Lock()
+	defer configVersionsMutex.Unlock()
+
+	seen := make(map[string]bool)
+	var warnings []string
+
+	for _, use := range deprecationUses {
+		if msg := use.String(); !seen[msg] {
+			seen[msg] = true
+			warnings = append(warnings, msg)
+		}
+	}
+
+	return warnings
+}
+
+func printDeprecationWarnings() {
+	for _, warning := range deprecationWarnings() {
+		printWarning("%s", warning)
+	}
+}
+
+// targetVersionProblems finds what would stop the configs working with a
+// po that only understands the target version: configs declaring a newer
+// version, and deprecated constructs removed by that version.
+func targetVersionProblems(target int) []string {
+	configVersionsMutex.Lock()
+	defer configVersionsMutex.Unlock()
+
+	var problems []string
+	locations := make([]string, 0, len(configVersions))
+
+	for location := range configVersions {
+		locations = append(locations, location)
+	}
+
+	sort.Strings(locations)
+
+	for _, location := range locations {
+		if version := configVersions[location]; version > target {
+			problems = append(problems, fmt.Sprintf(
+				"%s: is for config version %d, which a po for version %d cannot read",
+				location, version, target))
+		}
+	}
+
+	seen := make(map[string]bool)
+
+	for _, use := range deprecationUses {
+		if msg := use.String(); use.Removed <= target && !seen[msg] {
+			seen[msg] = true
+			problems = append(problems, msg)
+		}
+	}
+
+	return problems
+}
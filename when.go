@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"os/exec"
+	"reflect"
+)
+
+const (
+	unavailableAnnotation = "po:unavailable"
+	listUnavailableFlag   = "list-unavailable"
+)
+
+type Condition struct {
+	CommandExists string `yaml:"command_exists"`
+	FileExists    string `yaml:"file_exists"`
+	EnvSet        string `yaml:"env_set"`
+}
+
+// When is a list of conditions that must all hold for a command to be
+// available. It can be written as a single condition or a list of them.
+type When []Condition
+
+func (w *When) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []Condition
+
+	if err := unmarshal(&list); err == nil {
+		*w = list
+		return nil
+	}
+
+	var single Condition
+
+	if err := unmarshal(&single); err != nil {
+		return err
+	}
+
+	*w = When{single}
+	return nil
+}
+
+func (w When) jsonSchema(b *schemaBuilder) map[string]interface{} {
+	condition := b.typeSchema(reflect.TypeOf(Condition{}))
+
+	return map[string]interface{}{
+		"oneOf": []interface{}{
+			condition,
+			map[string]interface{}{"type": "array", "items": condition},
+		},
+	}
+}
+
+func (c Condition) Validate() error {
+	if c.CommandExists == "" && c.FileExists == "" && c.EnvSet == "" {
+		return fmt.Errorf("when requires a 'command_exists', 'file_exists' or 'env_set' key set")
+	}
+	return nil
+}
+
+// conditionCache holds the result of each condition, so that it's checked
+// at most once however many commands use it.
+var conditionCache = make(map[Condition]string)
+
+// unmetReason checks the condition, and returns why it isn't met, or an
+// empty string if it is.
+func (c Condition) unmetReason() string {
+	if reason, ok := conditionCache[c]; ok {
+		return reason
+	}
+
+	reason := ""
+
+	if c.CommandExists != "" {
+		if _, err := exec.LookPath(c.CommandExists); err != nil {
+			reason = c.CommandExists + " not found"
+		}
+	}
+
+	if reason == "" && c.FileExists != "" {
+		if _, err := os.Stat(c.FileExists); err != nil {
+			reason = c.FileExists + " does not exist"
+		}
+	}
+
+	if reason == "" && c.EnvSet != "" {
+		if _, ok := os.LookupEnv(c.EnvSet); !ok {
+			reason = c.EnvSet + " is not set"
+		}
+	}
+
+	conditionCache[c] = reason
+	return reason
+}
+
+func (w When) unmetReason() string {
+	for _, c := range w {
+		if reason := c.unmetReason(); reason != "" {
+			return reason
+		}
+	}
+	return ""
+}
+
+func unavailableReason(cmd *cobra.Command) string {
+	return cmd.Annotations[unavailableAnnotation]
+}
+
+// markUnavailable hides a command whose conditions aren't met, and makes
+// running it an error that says why. Subcommands of an unavailable command
+// are unavailable for the same reason.
+func markUnavailable(cmd *cobra.Command, parentCmd *cobra.Command, command *Command) {
+	reason := unavailableReason(parentCmd)
+
+	if reason == "" {
+		reason = command.When.unmetReason()
+	}
+
+	if reason == "" {
+		return
+	}
+
+	if cmd.Annotations == nil {
+		cmd.Annotations = make(map[string]string)
+	}
+
+	cmd.Annotations[unavailableAnnotation] = reason
+	cmd.Hidden = true
+	cmd.Args = cobra.ArbitraryArgs
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		printError(cmd, fmt.Errorf("command %s is unavailable: %s", spacedName(commandName(cmd)), reason))
+		os.Exit(exitFailure)
+	}
+}
+
+func unavailableUsages(command *cobra.Command, prefix string) string {
+	usage := ""
+	padding := rootCommandPadding(command)
+
+	for _, cmd := range listedCommands(command) {
+		if reason := unavailableReason(cmd); reason != "" && !isColonForm(cmd) {
+			usage += fmt.Sprintf("%s%s  unavailable: %s\n", prefix, rightPad(cmd.Name(), padding), reason)
+		}
+	}
+
+	return usage
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"golang.org/x/text/width"
+	"strings"
+	"unicode"
+)
+
+// runeWidth is the number of columns a terminal shows a rune in: two for
+// wide characters such as kanji, none for combining marks, and one for
+// anything else.
+func runeWidth(r rune) int {
+	if unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) {
+		return 0
+	}
+
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// displayWidth is the number of columns a string takes up in a terminal,
+// which is what lines it up with others, rather than its length in bytes.
+func displayWidth(s string) int {
+	w := 0
+
+	for _, r := range s {
+		w += runeWidth(r)
+	}
+
+	return w
+}
+
+// rightPad pads a string with spaces to the given display width.
+func rightPad(s string, padding int) string {
+	if w := displayWidth(s); w < padding {
+		return s + strings.Repeat(" ", padding-w)
+	}
+	return s
+}